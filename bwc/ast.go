@@ -2,20 +2,41 @@ package bwc
 
 import (
 	"fmt"
-	"strconv"
+	"math/big"
+	"strings"
 )
 
 type (
 	// Int represents integer numbers
-	Int int64
+	Int struct {
+		Val *big.Int
+
+		// Typ is the type given by suffixes, like in 1ULL,
+		// or by Verilog sizes. The zero Type means the type
+		// is decided by the dialect.
+		Typ Type
+
+		// Lit is the literal as written in the code, with its
+		// radix and suffix. It is empty for the integers made
+		// by the interpreter.
+		Lit string
+
+		Span
+	}
 
 	// Var is a variable
-	Var string
+	Var struct {
+		Name string
+
+		Span
+	}
 
 	// UnaryExpr holds unary operations like ~
 	UnaryExpr struct {
 		Op    Optype
 		Value Node
+
+		Span
 	}
 
 	// BinExpr holds binary operations like &,|,etc
@@ -23,16 +44,182 @@ type (
 		Op  Optype
 		Lhs Node
 		Rhs Node
+
+		Span
+	}
+
+	// CondExpr is the conditional expression Cond ? Then : Else
+	CondExpr struct {
+		Cond Node
+		Then Node
+		Else Node
+
+		Span
+	}
+
+	// Call is a function call, like popcount(x)
+	Call struct {
+		Name string
+		Args []Node
+
+		Span
+	}
+
+	// FuncDecl defines the function Name. The Body is an
+	// expression or a StmtList, the result of the function is
+	// the result of the last statement, or of a Return.
+	FuncDecl struct {
+		Name   string
+		Params []string
+		Body   Node
+
+		// Types of the Params and the Result of functions
+		// with typed signatures, like the Go ones. They are
+		// empty for untyped functions.
+		Types  []Type
+		Result Type
+
+		Span
+	}
+
+	// Return ends the function with the Value as result
+	Return struct {
+		Value Node
+
+		Span
+	}
+
+	// If runs Then if Cond is not zero, otherwise Else.
+	// Else is nil if missing.
+	If struct {
+		Cond Node
+		Then Node
+		Else Node
+
+		Span
+	}
+
+	// For runs Init and then Body and Post while Cond is not
+	// zero. Init, Cond and Post could be nil.
+	For struct {
+		Init Node
+		Cond Node
+		Post Node
+		Body Node
+
+		Span
+	}
+
+	// MacroDecl is a C #define, or #undef, of the macro
+	// Name. The Body is expanded where the macro is used.
+	MacroDecl struct {
+		Name   string
+		Params []string
+		Body   string
+
+		Func  bool // Func tells if it is a function-like macro
+		Undef bool
+
+		Span
+	}
+
+	// Enum defines the constants of a C enum, in order
+	Enum struct {
+		Consts []Assign
+
+		Span
+	}
+
+	// Slice is the Verilog bit slice Value[Hi:Lo], or the
+	// single bit Value[Hi] if Lo is nil.
+	Slice struct {
+		Value Node
+		Hi    Node
+		Lo    Node
+
+		Span
+	}
+
+	// Concat is the Verilog concatenation {a, b}, the
+	// first part has the most significant bits.
+	Concat struct {
+		Parts []Node
+
+		Span
+	}
+
+	// Replicate is the Verilog replication {Count{a, b}},
+	// the Value concatenated Count times.
+	Replicate struct {
+		Count Node
+		Value Concat
+
+		Span
+	}
+
+	// SliceAssign sets the bits Varname[Hi:Lo] to Expr
+	SliceAssign struct {
+		Varname string
+		Hi      Node
+		Lo      Node
+		Expr    Node
+
+		Span
+	}
+
+	// Cast converts the Value to the type To
+	Cast struct {
+		To    Type
+		Value Node
+
+		Span
 	}
 
 	Assign struct {
 		Varname string // Varname is the lhs of the assignment
 		Expr    Node   // Expr is the rhs of the assignment
+
+		// Op is the operation of compound assignments,
+		// like |=. It is zero for = and :=
+		Op Optype
+
+		// Define tells if it is a Go short variable
+		// declaration (:=)
+		Define bool
+
+		Span
+	}
+
+	// StmtList is a list of statements separated by
+	// semicolons or newlines
+	StmtList struct {
+		Stmts []Node
+
+		Span
 	}
 
 	Node interface {
 		Type() Nodetype
 		String() string
+
+		// Loc is the code of the node
+		Loc() Span
+	}
+
+	// Position is a position in the code. Lines and columns
+	// start at 1, columns count bytes.
+	Position struct {
+		Offset int
+		Line   int
+		Column int
+	}
+
+	// Span is the code [Start, End) of a node. Nodes made
+	// by the interpreter, like the implicit values of enums,
+	// could have the span of the code creating them.
+	Span struct {
+		Start Position
+		End   Position
 	}
 
 	Nodetype int
@@ -45,17 +232,50 @@ const (
 	NodeAssign
 	NodeInt
 	NodeVar
+	NodeCast
+	NodeStmtList
+	NodeCondExpr
+	NodeCall
+	NodeFuncDecl
+	NodeReturn
+	NodeIf
+	NodeFor
+	NodeMacroDecl
+	NodeEnum
+	NodeSlice
+	NodeSliceAssign
+	NodeConcat
+	NodeReplicate
 
 	binaryOPbegin Optype = iota + 1
 	OpAND
+	OpANDNOT
 	OpOR
 	OpXOR
 	OpSHL
 	OpSHR
+	OpUSHR
+	OpADD
+	OpSUB
+	OpMUL
+	OpDIV
+	OpFDIV
+	OpMOD
+	OpEQ
+	OpNEQ
+	OpLT
+	OpLE
+	OpGT
+	OpGE
+	OpLAND
+	OpLOR
 	binaryOPend
 
 	unaryOPbegin
 	OpNOT
+	OpLNOT
+	OpNEG
+	OpPOS
 	unaryOPend
 )
 
@@ -63,16 +283,50 @@ func (o Optype) String() string {
 	switch o {
 	case OpAND:
 		return "&"
+	case OpANDNOT:
+		return "&^"
 	case OpOR:
 		return "|"
 	case OpXOR:
 		return "^"
 	case OpNOT:
 		return "~"
+	case OpLNOT:
+		return "!"
 	case OpSHL:
 		return "<<"
 	case OpSHR:
 		return ">>"
+	case OpUSHR:
+		return ">>>"
+	case OpADD, OpPOS:
+		return "+"
+	case OpSUB, OpNEG:
+		return "-"
+	case OpMUL:
+		return "*"
+	case OpDIV:
+		return "/"
+	case OpFDIV:
+		return "//"
+	case OpMOD:
+		return "%"
+	case OpEQ:
+		return "=="
+	case OpNEQ:
+		return "!="
+	case OpLT:
+		return "<"
+	case OpLE:
+		return "<="
+	case OpGT:
+		return ">"
+	case OpGE:
+		return ">="
+	case OpLAND:
+		return "&&"
+	case OpLOR:
+		return "||"
 	}
 
 	panic(fmt.Sprintf("invalid operation: %d", o))
@@ -89,28 +343,200 @@ func (nt Nodetype) String() string {
 		return "NodeAssign"
 	} else if nt == NodeVar {
 		return "NodeVar"
+	} else if nt == NodeCast {
+		return "NodeCast"
+	} else if nt == NodeStmtList {
+		return "NodeStmtList"
+	} else if nt == NodeCondExpr {
+		return "NodeCondExpr"
+	} else if nt == NodeCall {
+		return "NodeCall"
+	} else if nt == NodeFuncDecl {
+		return "NodeFuncDecl"
+	} else if nt == NodeReturn {
+		return "NodeReturn"
+	} else if nt == NodeIf {
+		return "NodeIf"
+	} else if nt == NodeFor {
+		return "NodeFor"
+	} else if nt == NodeMacroDecl {
+		return "NodeMacroDecl"
+	} else if nt == NodeEnum {
+		return "NodeEnum"
+	} else if nt == NodeSlice {
+		return "NodeSlice"
+	} else if nt == NodeSliceAssign {
+		return "NodeSliceAssign"
+	} else if nt == NodeConcat {
+		return "NodeConcat"
+	} else if nt == NodeReplicate {
+		return "NodeReplicate"
 	}
 	panic(fmt.Sprintf("invalid node: %d", nt))
 }
 
 func (_ Int) Type() Nodetype { return NodeInt }
-func (i Int) String() string { return strconv.Itoa(int(i)) }
+func (i Int) String() string { return i.Val.String() }
+
+// NewInt creates an integer node.
+func NewInt(x int64) Int {
+	return newInt(big.NewInt(x))
+}
+
+func newInt(x *big.Int) Int {
+	if x.Sign() == 0 {
+		// canonical zero, big.Int could have many
+		// internal representations for it.
+		x = new(big.Int)
+	}
+	return Int{Val: x}
+}
 
 func (_ Var) Type() Nodetype { return NodeVar }
-func (a Var) String() string { return string(a) }
+func (a Var) String() string { return a.Name }
 
 func (_ BinExpr) Type() Nodetype { return NodeBinExpr }
-func (a BinExpr) String() string {
-	return fmt.Sprintf("%s%s%s", a.Lhs, a.Op, a.Rhs)
-}
+func (a BinExpr) String() string { return Format(a) }
 
 func (_ UnaryExpr) Type() Nodetype { return NodeUnaryExpr }
-func (a UnaryExpr) String() string {
-	return fmt.Sprintf("%s%s",
-		a.Op, a.Value)
+func (a UnaryExpr) String() string { return Format(a) }
+
+func (_ CondExpr) Type() Nodetype { return NodeCondExpr }
+func (a CondExpr) String() string { return Format(a) }
+
+func (_ Call) Type() Nodetype { return NodeCall }
+func (a Call) String() string {
+	args := make([]string, len(a.Args))
+	for i, arg := range a.Args {
+		args[i] = arg.String()
+	}
+	return fmt.Sprintf("%s(%s)", a.Name, strings.Join(args, ", "))
+}
+
+func (_ FuncDecl) Type() Nodetype { return NodeFuncDecl }
+func (a FuncDecl) String() string {
+	params := make([]string, len(a.Params))
+	for i, param := range a.Params {
+		params[i] = param
+		if i < len(a.Types) {
+			params[i] += " " + a.Types[i].String()
+		}
+	}
+
+	head := fmt.Sprintf("fn %s(%s)", a.Name, strings.Join(params, ", "))
+	if a.Result != (Type{}) {
+		head += " " + a.Result.String()
+	}
+	switch a.Body.Type() {
+	case NodeStmtList, NodeReturn, NodeIf, NodeFor:
+		return fmt.Sprintf("%s { %s }", head, a.Body)
+	}
+	return fmt.Sprintf("%s = %s", head, a.Body)
+}
+
+func (_ Return) Type() Nodetype { return NodeReturn }
+func (a Return) String() string {
+	return fmt.Sprintf("return %s", a.Value)
+}
+
+func (_ If) Type() Nodetype { return NodeIf }
+func (a If) String() string {
+	s := fmt.Sprintf("if %s { %s }", a.Cond, a.Then)
+	if a.Else != nil {
+		s += fmt.Sprintf(" else { %s }", a.Else)
+	}
+	return s
+}
+
+func (_ For) Type() Nodetype { return NodeFor }
+func (a For) String() string {
+	var init, cond, post string
+	if a.Init != nil {
+		init = a.Init.String()
+	}
+	if a.Cond != nil {
+		cond = a.Cond.String()
+	}
+	if a.Post != nil {
+		post = a.Post.String()
+	}
+	return fmt.Sprintf("for %s; %s; %s { %s }", init, cond, post, a.Body)
+}
+
+func (_ MacroDecl) Type() Nodetype { return NodeMacroDecl }
+func (a MacroDecl) String() string {
+	if a.Undef {
+		return "#undef " + a.Name
+	}
+
+	name := a.Name
+	if a.Func {
+		name += "(" + strings.Join(a.Params, ", ") + ")"
+	}
+	return fmt.Sprintf("#define %s %s", name, a.Body)
+}
+
+func (_ Enum) Type() Nodetype { return NodeEnum }
+func (a Enum) String() string {
+	consts := make([]string, len(a.Consts))
+	for i, c := range a.Consts {
+		consts[i] = c.String()
+	}
+	return fmt.Sprintf("enum { %s }", strings.Join(consts, ", "))
+}
+
+func (_ Slice) Type() Nodetype { return NodeSlice }
+func (a Slice) String() string {
+	return fmt.Sprintf("%s[%s]", a.Value, sliceRange(a.Hi, a.Lo))
+}
+
+func (_ SliceAssign) Type() Nodetype { return NodeSliceAssign }
+func (a SliceAssign) String() string {
+	return fmt.Sprintf("%s[%s] = %s", a.Varname, sliceRange(a.Hi, a.Lo), a.Expr)
+}
+
+func (_ Concat) Type() Nodetype { return NodeConcat }
+func (a Concat) String() string {
+	parts := make([]string, len(a.Parts))
+	for i, part := range a.Parts {
+		parts[i] = part.String()
+	}
+	return fmt.Sprintf("{%s}", strings.Join(parts, ", "))
+}
+
+func (_ Replicate) Type() Nodetype { return NodeReplicate }
+func (a Replicate) String() string {
+	return fmt.Sprintf("{%s%s}", a.Count, a.Value)
+}
+
+func sliceRange(hi, lo Node) string {
+	if lo == nil {
+		return hi.String()
+	}
+	return fmt.Sprintf("%s:%s", hi, lo)
+}
+
+func (_ Cast) Type() Nodetype { return NodeCast }
+func (a Cast) String() string {
+	return fmt.Sprintf("%s(%s)", a.To, a.Value)
 }
 
 func (_ Assign) Type() Nodetype { return NodeAssign }
 func (a Assign) String() string {
-	return fmt.Sprintf("%s = %s", a.Varname, a.Expr)
+	op := "="
+	if a.Define {
+		op = ":="
+	} else if a.Op != 0 {
+		op = a.Op.String() + "="
+	}
+	return fmt.Sprintf("%s %s %s", a.Varname, op, a.Expr)
+}
+
+func (_ StmtList) Type() Nodetype { return NodeStmtList }
+func (a StmtList) String() string {
+	stmts := make([]string, len(a.Stmts))
+	for i, stmt := range a.Stmts {
+		stmts[i] = stmt.String()
+	}
+	return strings.Join(stmts, "; ")
 }
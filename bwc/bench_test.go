@@ -0,0 +1,47 @@
+package bwc
+
+import (
+	"strings"
+	"testing"
+)
+
+// script returns n lines of bit twiddling statements.
+func script(n int) string {
+	var b strings.Builder
+	for i := 0; i < n; i++ {
+		b.WriteString("X = (X | (X << 16)) & 0x0000ffff0000ffff // stripe\n")
+	}
+	return b.String()
+}
+
+func BenchmarkNextToken(b *testing.B) {
+	in := script(1000)
+	b.SetBytes(int64(len(in)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		l := NewLexer(in)
+		for tok := l.NextToken(); tok.Type != EOF; tok = l.NextToken() {
+		}
+	}
+}
+
+func BenchmarkLex(b *testing.B) {
+	in := script(1000)
+	b.SetBytes(int64(len(in)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		for range Lex(in) {
+		}
+	}
+}
+
+func BenchmarkParse(b *testing.B) {
+	code := script(1000)
+	b.SetBytes(int64(len(code)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := Parse(code); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
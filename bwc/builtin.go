@@ -0,0 +1,217 @@
+package bwc
+
+import (
+	"fmt"
+	"math/big"
+	"math/bits"
+)
+
+// builtin is a function provided by the interpreter.
+type builtin struct {
+	nargs int
+	fn    func(d Dialect, args []Value) (Value, error)
+}
+
+// builtins are the bit manipulation functions of the Go
+// math/bits package and the gcc __builtin_ family. They work
+// on the bits of the argument type, so clz(u8(1)) is 7.
+var builtins = map[string]builtin{
+	"popcount": {1, popcount},
+	"parity":   {1, parity},
+	"clz":      {1, clz},
+	"ctz":      {1, ctz},
+	"bitlen":   {1, bitlen},
+	"log2":     {1, log2},
+	"bitrev":   {1, bitrev},
+	"bswap":    {1, bswap},
+	"bswap16":  {1, convertArg(U16, bswap)},
+	"bswap32":  {1, convertArg(U32, bswap)},
+	"bswap64":  {1, convertArg(U64, bswap)},
+	"rotl":     {2, rotl},
+	"rotr":     {2, rotr},
+
+	// the gcc names, used by pasted C macros. The suffixes
+	// l and ll take an unsigned long (long).
+	"__builtin_popcount":   {1, convertArg(U32, popcount)},
+	"__builtin_popcountl":  {1, convertArg(U64, popcount)},
+	"__builtin_popcountll": {1, convertArg(U64, popcount)},
+	"__builtin_parity":     {1, convertArg(U32, parity)},
+	"__builtin_parityl":    {1, convertArg(U64, parity)},
+	"__builtin_parityll":   {1, convertArg(U64, parity)},
+	"__builtin_clz":        {1, convertArg(U32, clz)},
+	"__builtin_clzl":       {1, convertArg(U64, clz)},
+	"__builtin_clzll":      {1, convertArg(U64, clz)},
+	"__builtin_ctz":        {1, convertArg(U32, ctz)},
+	"__builtin_ctzl":       {1, convertArg(U64, ctz)},
+	"__builtin_ctzll":      {1, convertArg(U64, ctz)},
+	"__builtin_bswap16":    {1, convertArg(U16, bswap)},
+	"__builtin_bswap32":    {1, convertArg(U32, bswap)},
+	"__builtin_bswap64":    {1, convertArg(U64, bswap)},
+}
+
+// fixedType returns the type used to look at the bits of a
+// value of type t. Untyped values get the int type of the
+// dialect, JavaScript numbers have 32 bits as in its bitwise
+// operators. Python integers stay unbounded.
+func fixedType(d Dialect, t Type) Type {
+	if t != Unbounded {
+		return t
+	}
+	if d == LangJS {
+		return I32
+	}
+	return d.intType()
+}
+
+// unsignedBits returns the bits of v, in two's complement for
+// negative values, and the type giving its width. Only the
+// negative unbounded integers have no bits representation.
+func unsignedBits(d Dialect, v Value) (*big.Int, Type, error) {
+	t := fixedType(d, v.Type)
+	if t.Bits == 0 && v.Sign() < 0 {
+		return nil, t, fmt.Errorf("negative unbounded integer %s", v)
+	}
+	return t.Unsigned().wrap(v.int()), t, nil
+}
+
+// fixedBits is like unsignedBits but fails for the unbounded
+// integers, as the result depends on the width.
+func fixedBits(d Dialect, v Value) (*big.Int, Type, error) {
+	x, t, err := unsignedBits(d, v)
+	if err != nil {
+		return nil, t, err
+	}
+	if t.Bits == 0 {
+		return nil, t, fmt.Errorf("%s has no fixed width, use a cast like u32(x)", v)
+	}
+	return x, t, nil
+}
+
+func onesCount(x *big.Int) int {
+	n := 0
+	for _, w := range x.Bits() {
+		n += bits.OnesCount(uint(w))
+	}
+	return n
+}
+
+func popcount(d Dialect, args []Value) (Value, error) {
+	x, _, err := unsignedBits(d, args[0])
+	if err != nil {
+		return Value{}, err
+	}
+	return NewValue(int64(onesCount(x)), d.intType()), nil
+}
+
+func parity(d Dialect, args []Value) (Value, error) {
+	x, _, err := unsignedBits(d, args[0])
+	if err != nil {
+		return Value{}, err
+	}
+	return NewValue(int64(onesCount(x)&1), d.intType()), nil
+}
+
+// clz counts the leading zeros, clz(0) is the width.
+func clz(d Dialect, args []Value) (Value, error) {
+	x, t, err := fixedBits(d, args[0])
+	if err != nil {
+		return Value{}, err
+	}
+	return NewValue(int64(int(t.Bits)-x.BitLen()), d.intType()), nil
+}
+
+// ctz counts the trailing zeros, ctz(0) is the width.
+func ctz(d Dialect, args []Value) (Value, error) {
+	x, t, err := unsignedBits(d, args[0])
+	if err != nil {
+		return Value{}, err
+	}
+	if x.Sign() == 0 {
+		if t.Bits == 0 {
+			return Value{}, fmt.Errorf("zero has no fixed width, use a cast like u32(x)")
+		}
+		return NewValue(int64(t.Bits), d.intType()), nil
+	}
+	return NewValue(int64(x.TrailingZeroBits()), d.intType()), nil
+}
+
+// bitlen is the minimum number of bits to represent the value.
+func bitlen(d Dialect, args []Value) (Value, error) {
+	x, _, err := unsignedBits(d, args[0])
+	if err != nil {
+		return Value{}, err
+	}
+	return NewValue(int64(x.BitLen()), d.intType()), nil
+}
+
+// log2 is the integer (floor) base 2 logarithm.
+func log2(d Dialect, args []Value) (Value, error) {
+	x, _, err := unsignedBits(d, args[0])
+	if err != nil {
+		return Value{}, err
+	}
+	if x.Sign() == 0 {
+		return Value{}, fmt.Errorf("log2 of zero")
+	}
+	return NewValue(int64(x.BitLen()-1), d.intType()), nil
+}
+
+// bitrev reverses the order of the bits.
+func bitrev(d Dialect, args []Value) (Value, error) {
+	x, t, err := fixedBits(d, args[0])
+	if err != nil {
+		return Value{}, err
+	}
+
+	ret := new(big.Int)
+	for i := 0; i < x.BitLen(); i++ {
+		ret.SetBit(ret, int(t.Bits)-1-i, x.Bit(i))
+	}
+	return newValue(ret, t), nil
+}
+
+// bswap reverses the order of the bytes.
+func bswap(d Dialect, args []Value) (Value, error) {
+	x, t, err := fixedBits(d, args[0])
+	if err != nil {
+		return Value{}, err
+	}
+	if t.Bits%8 != 0 {
+		return Value{}, fmt.Errorf("%s is not made of bytes", t)
+	}
+
+	buf := x.FillBytes(make([]byte, t.Bits/8))
+	for i, j := 0, len(buf)-1; i < j; i, j = i+1, j-1 {
+		buf[i], buf[j] = buf[j], buf[i]
+	}
+	return newValue(new(big.Int).SetBytes(buf), t), nil
+}
+
+// convertArg converts the argument to t before calling fn,
+// like __builtin_bswap32 converts it to uint32_t.
+func convertArg(t Type, fn func(Dialect, []Value) (Value, error)) func(Dialect, []Value) (Value, error) {
+	return func(d Dialect, args []Value) (Value, error) {
+		return fn(d, []Value{args[0].Convert(t)})
+	}
+}
+
+// rotl rotates the bits to the left by args[1] modulo the
+// width. Negative counts rotate to the right, as in Go.
+func rotl(d Dialect, args []Value) (Value, error) {
+	x, t, err := fixedBits(d, args[0])
+	if err != nil {
+		return Value{}, err
+	}
+
+	width := big.NewInt(int64(t.Bits))
+	n := uint(new(big.Int).Mod(args[1].int(), width).Uint64())
+
+	ret := new(big.Int).Lsh(x, n)
+	ret.Or(ret, new(big.Int).Rsh(x, t.Bits-n))
+	return newValue(ret, t), nil
+}
+
+func rotr(d Dialect, args []Value) (Value, error) {
+	n := newValue(new(big.Int).Neg(args[1].int()), Unbounded)
+	return rotl(d, []Value{args[0], n})
+}
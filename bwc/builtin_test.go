@@ -0,0 +1,87 @@
+package bwc
+
+import "testing"
+
+func TestBuiltins(t *testing.T) {
+	for _, tc := range []struct {
+		code    string
+		dialect Dialect
+		res     string // hex
+		typ     Type
+	}{
+		{code: "popcount(0xf0f0)", dialect: LangC, res: "8", typ: I32},
+		{code: "popcount(-1)", dialect: LangC, res: "20", typ: I32},
+		{code: "popcount((int8_t)-1)", dialect: LangC, res: "8", typ: I32},
+		{code: "popcount(-1)", dialect: LangGo, res: "40", typ: I64},
+		{code: "popcount(-1)", dialect: LangJS, res: "20", typ: Unbounded},
+		{code: "popcount(1<<100 | 1)", dialect: LangPython, res: "2", typ: Unbounded},
+		{code: "parity(7)", dialect: LangC, res: "1", typ: I32},
+		{code: "parity(0x11)", dialect: LangC, res: "0", typ: I32},
+		{code: "clz(1)", dialect: LangC, res: "1f", typ: I32},
+		{code: "clz(1UL)", dialect: LangC, res: "3f", typ: I32},
+		{code: "clz(u8(1))", dialect: LangRust, res: "7", typ: I32},
+		{code: "clz(0)", dialect: LangC, res: "20", typ: I32},
+		{code: "clz(-1)", dialect: LangC, res: "0", typ: I32},
+		{code: "ctz(0x80)", dialect: LangC, res: "7", typ: I32},
+		{code: "ctz(uint16(0))", dialect: LangGo, res: "10", typ: I64},
+		{code: "ctz(1<<100)", dialect: LangPython, res: "64", typ: Unbounded},
+		{code: "bitlen(0xff)", dialect: LangGo, res: "8", typ: I64},
+		{code: "log2(0x100)", dialect: LangC, res: "8", typ: I32},
+		{code: "log2(0x1ff)", dialect: LangC, res: "8", typ: I32},
+		{code: "bitrev(1)", dialect: LangC, res: "80000000", typ: I32},
+		{code: "bitrev(u8(0x0f))", dialect: LangC, res: "f0", typ: U8},
+		{code: "bitrev(uint16(0x8001))", dialect: LangGo, res: "8001", typ: U16},
+		{code: "bswap(0x11223344u)", dialect: LangC, res: "44332211", typ: U32},
+		{code: "bswap32(0x11223344)", dialect: LangC, res: "44332211", typ: U32},
+		{code: "bswap16(0x1122)", dialect: LangJava, res: "2211", typ: U16},
+		{code: "bswap64(0x1122)", dialect: LangGo, res: "2211000000000000", typ: U64},
+		{code: "rotl(0x80000001u, 1)", dialect: LangC, res: "3", typ: U32},
+		{code: "rotl(u8(0x81), 4)", dialect: LangC, res: "18", typ: U8},
+		{code: "rotl(u8(0x81), -1)", dialect: LangC, res: "c0", typ: U8},
+		{code: "rotr(u8(0x81), 1)", dialect: LangC, res: "c0", typ: U8},
+		{code: "rotr(u8(0x81), 9)", dialect: LangC, res: "c0", typ: U8},
+		{code: "rotl(1, 31)", dialect: LangC, res: "80000000", typ: I32},
+		{code: "~popcount(x) + 1", dialect: LangC, res: "fffffffe", typ: I32},
+		{code: "popcount(x & -x)", dialect: LangC, res: "1", typ: I32},
+		{code: "X = u64(0xff); popcount(X) == 8 ? clz(X) : 0", dialect: LangC, res: "38", typ: I32},
+	} {
+		interp := NewInterp(Lang(tc.dialect))
+		interp.environ["x"] = NewValue(12, I32)
+
+		got, err := exec(interp, tc.code)
+		if err != nil {
+			t.Fatalf("%s: %s: %s", tc.dialect, tc.code, err)
+		}
+
+		if got.Text(16) != tc.res || got.Type != tc.typ {
+			t.Fatalf("%s: %s: got(%s %s) != expected(%s %s)",
+				tc.dialect, tc.code, got.Text(16), got.Type,
+				tc.res, tc.typ)
+		}
+	}
+}
+
+func TestBuiltinErrors(t *testing.T) {
+	for _, tc := range []struct {
+		code    string
+		dialect Dialect
+	}{
+		{code: "popcount()", dialect: LangC},
+		{code: "popcount(1, 2)", dialect: LangC},
+		{code: "rotl(1)", dialect: LangC},
+		{code: "nosuchfn(1)", dialect: LangC},
+		{code: "log2(0)", dialect: LangC},
+		{code: "clz(1)", dialect: LangPython},
+		{code: "ctz(0)", dialect: LangPython},
+		{code: "popcount(-1)", dialect: LangPython},
+		{code: "bswap(4'b1010)", dialect: LangC},
+		{code: "popcount(1/0)", dialect: LangC},
+	} {
+		interp := NewInterp(Lang(tc.dialect))
+		_, err := exec(interp, tc.code)
+		if err == nil {
+			t.Fatalf("%s: expected error evaluating %q",
+				tc.dialect, tc.code)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package bwc
+
+type (
+	// Option configures the parser and the interpreter.
+	Option func(*config)
+
+	config struct {
+		dialect     Dialect
+		leftToRight bool
+	}
+)
+
+// Lang sets the dialect of the code. The default is LangC.
+func Lang(d Dialect) Option {
+	return func(c *config) {
+		c.dialect = d
+	}
+}
+
+// LeftToRight makes every binary operator have the same
+// precedence, so expressions are folded strictly from
+// left to right: 0|1&2|3 == (((0|1)&2)|3).
+// This was the behavior of older bwc versions.
+func LeftToRight() Option {
+	return func(c *config) {
+		c.leftToRight = true
+	}
+}
+
+func newConfig(opts []Option) *config {
+	c := &config{
+		dialect: LangC,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// precedence returns the precedence table in use.
+func (c *config) precedence() precedence {
+	prec := c.dialect.precedence()
+	if !c.leftToRight {
+		return prec
+	}
+
+	ltr := precedence{}
+	for op := range prec {
+		ltr[op] = 1
+	}
+	return ltr
+}
@@ -0,0 +1,424 @@
+package bwc
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// Dialect is the language the evaluated code came from.
+// It defines the operator set, the precedence rules and the
+// integer semantics used by the parser and the interpreter.
+type Dialect int
+
+const (
+	LangC      Dialect = iota // C and C++
+	LangGo                    // Go, with &^ and its own precedence groups
+	LangRust                  // Rust, ! is the bitwise not
+	LangJava                  // Java, >>> is the unsigned right shift
+	LangJS                    // JavaScript, bitwise operators work on 32 bits
+	LangPython                // Python, not is the logical negation
+)
+
+// Go groups operators in fewer precedence levels than C:
+// * / % & &^ << >> bind the tighter, then + - | ^, the
+// comparisons, && and ||.
+var goPrecedence = precedence{
+	OpLOR:    1,
+	OpLAND:   2,
+	OpEQ:     3,
+	OpNEQ:    3,
+	OpLT:     3,
+	OpLE:     3,
+	OpGT:     3,
+	OpGE:     3,
+	OpOR:     4,
+	OpXOR:    4,
+	OpADD:    4,
+	OpSUB:    4,
+	OpAND:    5,
+	OpANDNOT: 5,
+	OpSHL:    5,
+	OpSHR:    5,
+	OpMUL:    5,
+	OpDIV:    5,
+	OpMOD:    5,
+}
+
+// rustPrecedence is the C precedence for bitwise and arithmetic
+// operators but the comparisons bind looser than them, so
+// x & m == m is (x & m) == m.
+var rustPrecedence = precedence{
+	OpLOR:  1,
+	OpLAND: 2,
+	OpEQ:   3,
+	OpNEQ:  3,
+	OpLT:   3,
+	OpLE:   3,
+	OpGT:   3,
+	OpGE:   3,
+	OpOR:   4,
+	OpXOR:  5,
+	OpAND:  6,
+	OpSHL:  7,
+	OpSHR:  7,
+	OpADD:  8,
+	OpSUB:  8,
+	OpMUL:  9,
+	OpDIV:  9,
+	OpMOD:  9,
+}
+
+// javaPrecedence is the C precedence with the unsigned right
+// shift (>>>) of Java and JavaScript.
+var javaPrecedence = precedence{
+	OpLOR:  1,
+	OpLAND: 2,
+	OpOR:   3,
+	OpXOR:  4,
+	OpAND:  5,
+	OpEQ:   6,
+	OpNEQ:  6,
+	OpLT:   7,
+	OpLE:   7,
+	OpGT:   7,
+	OpGE:   7,
+	OpSHL:  8,
+	OpSHR:  8,
+	OpUSHR: 8,
+	OpADD:  9,
+	OpSUB:  9,
+	OpMUL:  10,
+	OpDIV:  10,
+	OpMOD:  10,
+}
+
+// pythonPrecedence is the Rust precedence, with and/or and
+// comparisons looser than bitwise operators, but the integer
+// division is //, the / gives floats and is not supported.
+var pythonPrecedence = precedence{
+	OpLOR:  1,
+	OpLAND: 2,
+	OpEQ:   3,
+	OpNEQ:  3,
+	OpLT:   3,
+	OpLE:   3,
+	OpGT:   3,
+	OpGE:   3,
+	OpOR:   4,
+	OpXOR:  5,
+	OpAND:  6,
+	OpSHL:  7,
+	OpSHR:  7,
+	OpADD:  8,
+	OpSUB:  8,
+	OpMUL:  9,
+	OpFDIV: 9,
+	OpMOD:  9,
+}
+
+func (d Dialect) String() string {
+	switch d {
+	case LangC:
+		return "c"
+	case LangGo:
+		return "go"
+	case LangRust:
+		return "rust"
+	case LangJava:
+		return "java"
+	case LangJS:
+		return "js"
+	case LangPython:
+		return "python"
+	}
+	panic(fmt.Sprintf("invalid dialect: %d", d))
+}
+
+// ParseDialect returns the dialect with the given name.
+func ParseDialect(name string) (Dialect, error) {
+	switch strings.ToLower(name) {
+	case "c", "c++", "cpp":
+		return LangC, nil
+	case "go", "golang":
+		return LangGo, nil
+	case "rust", "rs":
+		return LangRust, nil
+	case "java":
+		return LangJava, nil
+	case "js", "javascript":
+		return LangJS, nil
+	case "python", "py":
+		return LangPython, nil
+	}
+	return 0, fmt.Errorf("unknown language %q", name)
+}
+
+// precedence of the binary operators of the dialect.
+// The operators missing in the table are not supported.
+func (d Dialect) precedence() precedence {
+	switch d {
+	case LangGo:
+		return goPrecedence
+	case LangJava, LangJS:
+		return javaPrecedence
+	case LangRust:
+		return rustPrecedence
+	case LangPython:
+		return pythonPrecedence
+	}
+	return cPrecedence
+}
+
+// ternary tells if the dialect has the ?: operator.
+func (d Dialect) ternary() bool {
+	switch d {
+	case LangC, LangJava, LangJS:
+		return true
+	}
+	return false
+}
+
+// cComments tells if the dialect has // and /* */ comments.
+// In Python the // is the integer division.
+func (d Dialect) cComments() bool {
+	return d != LangPython
+}
+
+// directives tells if the dialect has preprocessor
+// directives, like #define.
+func (d Dialect) directives() bool {
+	return d == LangC
+}
+
+// hashComments tells if the dialect has # comments.
+// In C the # starts preprocessor directives.
+func (d Dialect) hashComments() bool {
+	return d != LangC
+}
+
+// unaryOP returns the unary operation of tok in the dialect.
+func (d Dialect) unaryOP(tok Tokval) (Optype, bool) {
+	switch {
+	case tok.Type == NOT:
+		return OpNOT, true
+	case tok.Type == MINUS:
+		return OpNEG, true
+	case tok.Type == PLUS:
+		return OpPOS, true
+	case tok.Type == XOR && d == LangGo:
+		// Go has no ~, the unary ^ is the bitwise not.
+		return OpNOT, true
+	case tok.Type == BANG && d == LangRust:
+		// Rust has no ~, the ! is the bitwise not
+		// for integers.
+		return OpNOT, true
+	case tok.Type == BANG && d != LangPython:
+		return OpLNOT, true
+	case tok.Type == Ident && tok.Value == "not" && d == LangPython:
+		return OpLNOT, true
+	}
+	return 0, false
+}
+
+// binaryOP returns the binary operation of tok in the dialect.
+// The operators not supported by the dialect are left for the
+// precedence table, which gives better errors.
+func (d Dialect) binaryOP(tok Tokval) (Optype, bool) {
+	switch tok.Type {
+	case AND:
+		return OpAND, true
+	case ANDNOT:
+		return OpANDNOT, true
+	case OR:
+		return OpOR, true
+	case XOR:
+		return OpXOR, true
+	case SHL:
+		return OpSHL, true
+	case SHR:
+		return OpSHR, true
+	case USHR:
+		return OpUSHR, true
+	case PLUS:
+		return OpADD, true
+	case MINUS:
+		return OpSUB, true
+	case MUL:
+		return OpMUL, true
+	case DIV:
+		return OpDIV, true
+	case FDIV:
+		return OpFDIV, true
+	case MOD:
+		return OpMOD, true
+	case EQ:
+		return OpEQ, true
+	case NEQ:
+		return OpNEQ, true
+	case LT:
+		return OpLT, true
+	case LE:
+		return OpLE, true
+	case GT:
+		return OpGT, true
+	case GE:
+		return OpGE, true
+	case LAND:
+		// python spells it and
+		return OpLAND, d != LangPython
+	case LOR:
+		// python spells it or
+		return OpLOR, d != LangPython
+	case Ident:
+		if d != LangPython {
+			break
+		}
+		switch tok.Value {
+		case "and":
+			return OpLAND, true
+		case "or":
+			return OpLOR, true
+		}
+	}
+	return 0, false
+}
+
+// intType is the type of the int keyword, used for untyped
+// values stored in variables and for logical results.
+func (d Dialect) intType() Type {
+	switch d {
+	case LangGo:
+		return I64
+	case LangJS, LangPython:
+		return Unbounded
+	}
+	return I32
+}
+
+// literalType returns the type of the integer literal i.
+// C literals have the first type where the value fits: the
+// decimal ones are int or long, the hex, octal and binary ones
+// can also be unsigned. The decimal ones too large for long
+// are unsigned long, as in gcc. Java literals are int
+// unless they need more than 32 bits. The literals of the
+// other dialects are untyped.
+// Literals wider than 64 bits are not valid C or Java, they
+// get the first of 128 and 256 bits where they fit.
+func (d Dialect) literalType(i Int) Type {
+	x := i.Val
+	var types []Type
+	switch d {
+	case LangC:
+		types = []Type{I32, U32, I64, U64, U128, U256}
+		if isDecimal(i.Lit) {
+			types = []Type{I32, I64, U64, U128, U256}
+		}
+	case LangJava:
+		if I32.fits(x) || U32.fits(x) {
+			return I32
+		}
+		if I64.fits(x) || U64.fits(x) {
+			return I64
+		}
+		types = []Type{U128, U256}
+	default:
+		return Unbounded
+	}
+
+	for _, t := range types {
+		if t.fits(x) {
+			return t
+		}
+	}
+	return Unbounded
+}
+
+// promote applies the integer promotions before operations.
+// C and Java promote types narrower than int to int while
+// the untyped Rust integers default to i32.
+func (d Dialect) promote(t Type) Type {
+	switch d {
+	case LangC, LangJava:
+		if t.Bits != 0 && t.Bits < 32 {
+			return I32
+		}
+	case LangRust:
+		if t == Unbounded {
+			return I32
+		}
+	}
+	return t
+}
+
+// binaryType returns the type of the operands and the result
+// of a binary operation. Untyped operands take the type of the
+// other operand. In C and Java the wider type wins, and for
+// types of the same width the unsigned wins. Go and Rust
+// require both types to be the same.
+func (d Dialect) binaryType(a, b Type) (Type, error) {
+	switch {
+	case a == Unbounded:
+		return d.promote(b), nil
+	case b == Unbounded:
+		return d.promote(a), nil
+	}
+
+	a, b = d.promote(a), d.promote(b)
+	if a == b {
+		return a, nil
+	}
+
+	switch d {
+	case LangGo, LangRust:
+		return Type{}, fmt.Errorf("mismatched types %s and %s", a, b)
+	}
+
+	if a.Bits > b.Bits {
+		return a, nil
+	}
+	if b.Bits > a.Bits {
+		return b, nil
+	}
+	return a.Unsigned(), nil
+}
+
+// maxShift limits the shifts of unbounded integers.
+const maxShift = 1 << 16
+
+// shiftCount validates the rhs of a shift of a t value.
+// Java and JavaScript silently mask the count with the
+// operand width, the others fail on negative counts. C and
+// Rust also fail when the count overflows the width, Go
+// shifts all bits out. The unbounded values have no width,
+// their counts are checked as in the other dialects.
+func (d Dialect) shiftCount(v Value, t Type) (uint, error) {
+	n := v.int()
+	switch d {
+	case LangJava, LangJS:
+		if t.Bits != 0 {
+			return uint(U64.wrap(n).Uint64() & uint64(t.Bits-1)), nil
+		}
+	}
+
+	if n.Sign() < 0 {
+		return 0, fmt.Errorf("negative shift amount %s", n)
+	}
+
+	if t.Bits == 0 {
+		if n.Cmp(big.NewInt(maxShift)) > 0 {
+			return 0, fmt.Errorf("shift amount too large: %s", n)
+		}
+		return uint(n.Uint64()), nil
+	}
+
+	if n.Cmp(big.NewInt(int64(t.Bits))) >= 0 {
+		switch d {
+		case LangC, LangRust:
+			return 0, fmt.Errorf("shift amount %s >= width of %s",
+				n, t)
+		}
+		return t.Bits, nil
+	}
+	return uint(n.Uint64()), nil
+}
@@ -0,0 +1,143 @@
+package bwc
+
+import (
+	"fmt"
+	"unicode/utf8"
+)
+
+type (
+	// ParseError is a syntax error in the code. Errors of
+	// the lexer are Illegal tokens in Got.
+	ParseError struct {
+		Pos      int    // Pos is the byte offset of the error
+		Expected string // Expected describes the valid tokens
+		Got      Tokval // Got is the offending token
+
+		// Msg describes the errors other than unexpected
+		// tokens, like unsupported operators.
+		Msg string
+	}
+
+	// ErrorList is the list of syntax errors of the code,
+	// in the order they appear.
+	ErrorList []*ParseError
+
+	// EvalError is an error evaluating Node.
+	EvalError struct {
+		Node Node
+		Msg  string
+	}
+)
+
+func (e *ParseError) Error() string {
+	switch {
+	case e.Msg != "":
+		return fmt.Sprintf("%s at position %d", e.Msg, e.Pos)
+	case e.Got.Type == Illegal:
+		// the lexer errors are kept verbatim
+		return e.Got.Value
+	case e.Got.Type == EOF:
+		return fmt.Sprintf("premature eof, expects %s", e.Expected)
+	}
+	return fmt.Sprintf("expected %s but got %s at position %d",
+		e.Expected, e.Got, e.Pos)
+}
+
+// Span returns the byte offsets [start, end) of the offending
+// code. The span has at least one rune, even at the end of
+// the code.
+func (e *ParseError) Span() (int, int) {
+	n := 1
+	if e.Got.Type != Illegal && e.Got.Type != EOF && e.Got.Value != "" {
+		n = len(e.Got.Value)
+	}
+	return e.Pos, e.Pos + n
+}
+
+func (l ErrorList) Error() string {
+	switch len(l) {
+	case 0:
+		return "no errors"
+	case 1:
+		return l[0].Error()
+	}
+	return fmt.Sprintf("%s (and %d more errors)", l[0], len(l)-1)
+}
+
+// Span returns the span of the first error.
+func (l ErrorList) Span() (int, int) {
+	if len(l) == 0 {
+		return 0, 0
+	}
+	return l[0].Span()
+}
+
+// As makes errors.As find the first error of the list when
+// looking for a *ParseError.
+func (l ErrorList) As(target interface{}) bool {
+	perr, ok := target.(**ParseError)
+	if !ok || len(l) == 0 {
+		return false
+	}
+	*perr = l[0]
+	return true
+}
+
+func (e *EvalError) Error() string {
+	return e.Msg
+}
+
+// Span returns the byte offsets [start, end) of the node that
+// failed. Nodes built by hand have an empty span.
+func (e *EvalError) Span() (int, int) {
+	span := e.Node.Loc()
+	return span.Start.Offset, span.End.Offset
+}
+
+// Caret renders the line of code with the span [start, end)
+// marked by a caret, like:
+//
+//	x = 1 + @
+//	        ^
+//
+// Only the first line of spans with many lines is marked.
+func Caret(code string, start, end int) string {
+	if start < 0 {
+		start = 0
+	}
+	if start > len(code) {
+		start = len(code)
+	}
+
+	begin := start
+	for begin > 0 && code[begin-1] != '\n' {
+		begin--
+	}
+	stop := start
+	for stop < len(code) && code[stop] != '\n' {
+		stop++
+	}
+	if end > stop {
+		end = stop
+	}
+
+	line := code[begin:stop]
+	width := utf8.RuneCountInString(code[start:end])
+	if width == 0 {
+		width = 1
+	}
+
+	marker := make([]byte, 0, len(line)+width)
+	for _, r := range code[begin:start] {
+		if r == '\t' {
+			marker = append(marker, '\t')
+		} else {
+			marker = append(marker, ' ')
+		}
+	}
+	marker = append(marker, '^')
+	for i := 1; i < width; i++ {
+		marker = append(marker, '~')
+	}
+	return line + "\n" + string(marker)
+}
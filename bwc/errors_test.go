@@ -0,0 +1,146 @@
+package bwc
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseErrors(t *testing.T) {
+	for _, tc := range []struct {
+		code     string
+		dialect  Dialect
+		pos      int
+		expected string
+		got      Token
+		msg      string
+	}{
+		{
+			code:     "1 +",
+			pos:      3,
+			expected: "expr || number || ident || unary",
+			got:      EOF,
+			msg:      "premature eof, expects expr || number || ident || unary",
+		},
+		{
+			code:     "x = (1 + 2",
+			pos:      10,
+			expected: "RPAREN",
+			got:      EOF,
+			msg:      "premature eof, expects RPAREN",
+		},
+		{
+			code:     "1 + )",
+			pos:      4,
+			expected: "NUMBER",
+			got:      RParen,
+			msg:      "expected NUMBER but got Token(), )) at position 4",
+		},
+		{
+			code:     "1 + @",
+			pos:      4,
+			expected: "NUMBER",
+			got:      Illegal,
+			msg:      "Unexpected '@' at 5",
+		},
+		{
+			code:    "1 < 2 < 3",
+			dialect: LangRust,
+			pos:     6,
+			got:     LT,
+			msg:     "comparison operators cannot be chained at position 6",
+		},
+		{
+			code:    "func f() {",
+			dialect: LangGo,
+			pos:     10,
+			msg:     "expected '}', found 'EOF' at position 10",
+		},
+	} {
+		_, err := Parse(tc.code, Lang(tc.dialect))
+
+		var perr *ParseError
+		if !errors.As(err, &perr) {
+			t.Fatalf("%q: expected a *ParseError but got %#v", tc.code, err)
+		}
+		if perr.Pos != tc.pos || perr.Expected != tc.expected ||
+			perr.Got.Type != tc.got {
+			t.Fatalf("%q: got %+v", tc.code, perr)
+		}
+		if err.Error() != tc.msg {
+			t.Fatalf("%q: got message %q != expected %q", tc.code, err, tc.msg)
+		}
+	}
+}
+
+func TestEvalErrorNode(t *testing.T) {
+	for _, tc := range []struct {
+		code string
+		node string
+		msg  string
+	}{
+		{
+			code: "x = 1; x + y * 2",
+			node: "y",
+			msg:  "undefined variable y",
+		},
+		{
+			code: "1 + 2 / 0",
+			node: "2 / 0",
+			msg:  "division by zero: 2 / 0",
+		},
+		{
+			code: "(1 + 2) / (1 - 1)",
+			node: "(1 + 2) / (1 - 1)",
+			msg:  "division by zero: (1 + 2) / (1 - 1)",
+		},
+		{
+			code: "fn f(x) = x / 0; 1 + f(2)",
+			node: "f(2)",
+			msg:  "division by zero: x / 0",
+		},
+	} {
+		_, err := NewInterp().Exec(tc.code)
+
+		var everr *EvalError
+		if !errors.As(err, &everr) {
+			t.Fatalf("%q: expected an *EvalError but got %#v", tc.code, err)
+		}
+		if everr.Node.String() != tc.node || everr.Msg != tc.msg {
+			t.Fatalf("%q: got %s: %q", tc.code, everr.Node, everr.Msg)
+		}
+	}
+}
+
+func TestCaret(t *testing.T) {
+	for _, tc := range []struct {
+		code       string
+		start, end int
+		out        string
+	}{
+		{
+			code:  "x = 1 + @",
+			start: 8, end: 9,
+			out: "x = 1 + @\n        ^",
+		},
+		{
+			code:  "x = 1\ny = x << zz + 1\nz = y",
+			start: 15, end: 17,
+			out: "y = x << zz + 1\n         ^~",
+		},
+		{
+			code:  "\tx = (1 + 2",
+			start: 11, end: 11,
+			out: "\tx = (1 + 2\n\t          ^",
+		},
+		{
+			code:  "f(1,\n2)",
+			start: 0, end: 7,
+			out: "f(1,\n^~~~",
+		},
+	} {
+		got := Caret(tc.code, tc.start, tc.end)
+		if got != tc.out {
+			t.Fatalf("%q: got:\n%s\nexpected:\n%s", tc.code, got, tc.out)
+		}
+	}
+}
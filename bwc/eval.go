@@ -2,104 +2,1030 @@ package bwc
 
 import (
 	"fmt"
+	"math/big"
+	"sort"
 )
 
 type interp struct {
-	environ map[string]Int
+	environ map[string]Value
+	funcs   map[string]FuncDecl
+	macros  map[string]MacroDecl
+	cfg     *config
+
+	// locals are the variables of the running function,
+	// nil outside functions.
+	locals map[string]Value
+	depth  int // depth of function calls
+
+	// trace records the evaluations for Explain, it is nil
+	// otherwise.
+	trace *trace
+
+	// sym builds the bits of the symbolic values for
+	// EvalSymbolic, it is nil otherwise.
+	sym *formulas
+}
+
+// maxCallDepth limits the recursion of functions.
+const maxCallDepth = 1000
+
+// maxLoops limits the iterations of a loop.
+const maxLoops = 1 << 20
+
+// returnErr unwinds the evaluation from a Return up to the
+// function call.
+type returnErr struct {
+	val Value
 }
 
-func NewInterp() *interp {
+func (r returnErr) Error() string {
+	return "return outside function"
+}
+
+// NewInterp creates a new interpreter. The opts are used
+// when parsing code given to Exec.
+func NewInterp(opts ...Option) *interp {
 	return &interp{
-		environ: make(map[string]Int),
+		environ: make(map[string]Value),
+		funcs:   make(map[string]FuncDecl),
+		macros:  make(map[string]MacroDecl),
+		cfg:     newConfig(opts),
 	}
 }
 
-func (e *interp) Exec(code string) (Int, error) {
-	n, err := Parse(code)
+// Exec the code, returning the result of each statement.
+// Definitions of functions, macros and enums have no result.
+func (e *interp) Exec(code string) ([]Value, error) {
+	n, err := parse(code, e.cfg, e.macros)
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
-	return e.Eval(n)
+
+	var res []Value
+	for _, stmt := range statements(n) {
+		val, err := e.Eval(stmt)
+		if err != nil {
+			return res, err
+		}
+		if !isDecl(stmt) {
+			res = append(res, val)
+		}
+	}
+	return res, nil
 }
 
-func (e *interp) Eval(n Node) (Int, error) {
+// statements returns the statements of the parsed code.
+func statements(n Node) []Node {
+	if n.Type() == NodeStmtList {
+		return n.(StmtList).Stmts
+	}
+	return []Node{n}
+}
+
+// isDecl tells if the statement is a definition without value.
+func isDecl(n Node) bool {
+	switch n.Type() {
+	case NodeFuncDecl, NodeMacroDecl, NodeEnum:
+		return true
+	}
+	return false
+}
+
+// Eval evaluates the node. The errors are *EvalError, with
+// the innermost node that failed.
+func (e *interp) Eval(n Node) (Value, error) {
+	var ev *evaluation
+	if e.trace != nil && e.depth == 0 {
+		// the nodes in the bodies of the functions called are
+		// not in the code explained, even if their spans match
+		ev = e.trace.enter(n)
+	}
+
+	val, err := e.eval(n)
+	if err != nil {
+		err = evalErr(n, err)
+		val = Value{}
+	}
+	if ev != nil {
+		ev.val, ev.err = val, err
+	}
+	return val, err
+}
+
+// evalErr returns err as an *EvalError of n, if it isn't one
+// of the nodes of n already. Returns unwinding the calls are
+// kept as they are.
+func evalErr(n Node, err error) error {
+	switch err.(type) {
+	case *EvalError, returnErr:
+		return err
+	}
+	return &EvalError{
+		Node: n,
+		Msg:  err.Error(),
+	}
+}
+
+func (e *interp) eval(n Node) (Value, error) {
 	switch n.Type() {
 	case NodeInt:
-		return n.(Int), nil
+		return e.evalInt(n.(Int)), nil
 	case NodeVar:
 		return e.evalVar(n.(Var))
 	case NodeUnaryExpr:
 		return e.evalUnaryExpr(n.(UnaryExpr))
 	case NodeBinExpr:
 		return e.evalBinExpr(n.(BinExpr))
+	case NodeCondExpr:
+		return e.evalCondExpr(n.(CondExpr))
+	case NodeCall:
+		return e.evalCall(n.(Call))
+	case NodeFuncDecl:
+		return Value{}, e.evalFuncDecl(n.(FuncDecl))
+	case NodeMacroDecl:
+		e.evalMacroDecl(n.(MacroDecl))
+		return Value{}, nil
+	case NodeEnum:
+		return Value{}, e.evalEnum(n.(Enum))
+	case NodeReturn:
+		return e.evalReturn(n.(Return))
+	case NodeIf:
+		return e.evalIf(n.(If))
+	case NodeFor:
+		return e.evalFor(n.(For))
+	case NodeConcat:
+		return e.evalConcat(n.(Concat))
+	case NodeReplicate:
+		return e.evalReplicate(n.(Replicate))
+	case NodeSlice:
+		return e.evalSlice(n.(Slice))
+	case NodeSliceAssign:
+		return e.evalSliceAssign(n.(SliceAssign))
+	case NodeCast:
+		return e.evalCast(n.(Cast))
 	case NodeAssign:
 		return e.evalAssign(n.(Assign))
+	case NodeStmtList:
+		return e.evalStmtList(n.(StmtList))
 	}
 
-	return 0, fmt.Errorf("unexpected %s", n)
+	return Value{}, fmt.Errorf("unexpected %s", n)
 }
 
-func (e *interp) evalVar(v Var) (Int, error) {
-	if val, ok := e.environ[string(v)]; ok {
+func (e *interp) evalInt(i Int) Value {
+	if i.Typ != (Type{}) {
+		return newValue(i.Val, i.Typ)
+	}
+	return newValue(i.Val, e.cfg.dialect.literalType(i))
+}
+
+// evalVar looks for the variable in the function locals
+// and then in the global variables. In symbolic evaluations
+// the variables not found are symbols.
+func (e *interp) evalVar(v Var) (Value, error) {
+	if val, ok := e.locals[v.Name]; ok {
 		return val, nil
 	}
-	return 0, fmt.Errorf("undefined variable %s", v)
+	if val, ok := e.environ[v.Name]; ok {
+		return val, nil
+	}
+	if e.sym != nil {
+		return e.symVar(v), nil
+	}
+	return Value{}, fmt.Errorf("undefined variable %s", v)
 }
 
-func (e *interp) evalUnaryExpr(expr UnaryExpr) (Int, error) {
-	num, err := e.Eval(expr.Value)
+// vars are the variables changed by assignments, inside
+// functions they are local.
+func (e *interp) vars() map[string]Value {
+	if e.locals != nil {
+		return e.locals
+	}
+	return e.environ
+}
+
+func (e *interp) evalUnaryExpr(expr UnaryExpr) (Value, error) {
+	val, err := e.Eval(expr.Value)
 	if err != nil {
-		return 0, err
+		return Value{}, err
+	}
+	if val.sym != nil {
+		return e.symUnaryExpr(expr, val)
 	}
 
+	d := e.cfg.dialect
+	if expr.Op == OpLNOT {
+		return boolValue(d, val.Sign() == 0), nil
+	}
+
+	if d == LangJS && expr.Op == OpNOT {
+		val = val.Convert(I32)
+	}
+
+	typ := d.promote(val.Type)
+	num := val.Convert(typ).int()
+
+	var ret Value
 	switch expr.Op {
 	case OpNOT:
-		return Int(^int64(num)), nil
+		ret = newValue(new(big.Int).Not(num), typ)
+	case OpNEG:
+		ret = newValue(new(big.Int).Neg(num), typ)
+	case OpPOS:
+		ret = newValue(num, typ)
+	default:
+		return Value{}, fmt.Errorf("invalid unary expr: %s", expr.Op)
 	}
 
-	return 0, fmt.Errorf("invalid unary expr: %s", expr.Op)
+	if d == LangJS {
+		// javascript has only numbers
+		return ret.Convert(Unbounded), nil
+	}
+	return ret, nil
 }
 
-func (e *interp) evalOperand(n Node) (Int, error) {
-	if n.Type() != NodeInt {
-		return e.Eval(n)
+func (e *interp) evalBinExpr(expr BinExpr) (Value, error) {
+	switch expr.Op {
+	case OpLAND, OpLOR:
+		return e.evalLogical(expr)
 	}
 
-	return n.(Int), nil
-}
+	lhs, err := e.Eval(expr.Lhs)
+	if err != nil {
+		return Value{}, err
+	}
 
-func (e *interp) evalBinExpr(expr BinExpr) (Int, error) {
-	lhs, err := e.evalOperand(expr.Lhs)
+	rhs, err := e.Eval(expr.Rhs)
 	if err != nil {
-		return 0, err
+		return Value{}, err
+	}
+	if lhs.sym != nil || rhs.sym != nil {
+		return e.symBinExpr(expr, lhs, rhs)
+	}
+
+	d := e.cfg.dialect
+	if d == LangJS && isBitwiseOP(expr.Op) {
+		return jsBinOP(expr.Op, lhs, rhs)
+	}
+
+	switch expr.Op {
+	case OpSHL, OpSHR, OpUSHR:
+		return evalShift(d, expr.Op, lhs, rhs)
 	}
 
-	rhs, err := e.evalOperand(expr.Rhs)
+	typ, err := d.binaryType(lhs.Type, rhs.Type)
 	if err != nil {
-		return 0, err
+		return Value{}, fmt.Errorf("%s: %s", expr, err)
+	}
+
+	a := lhs.Convert(typ).int()
+	b := rhs.Convert(typ).int()
+
+	if isComparison(expr.Op) {
+		return compare(d, expr.Op, a, b), nil
 	}
 
-	var ret Int
+	ret := new(big.Int)
 	switch expr.Op {
 	case OpAND:
-		ret = lhs & rhs
+		ret.And(a, b)
+	case OpANDNOT:
+		ret.AndNot(a, b)
 	case OpOR:
-		ret = lhs | rhs
+		ret.Or(a, b)
+	case OpXOR:
+		ret.Xor(a, b)
+	case OpADD:
+		ret.Add(a, b)
+	case OpSUB:
+		ret.Sub(a, b)
+	case OpMUL:
+		ret.Mul(a, b)
+	case OpDIV, OpFDIV, OpMOD:
+		if b.Sign() == 0 {
+			return Value{}, fmt.Errorf("division by zero: %s", expr)
+		}
+
+		switch expr.Op {
+		case OpDIV:
+			ret.Quo(a, b)
+		case OpFDIV:
+			ret = floorDiv(a, b)
+		default:
+			ret.Rem(a, b)
+			if d == LangPython {
+				ret.Sub(a, ret.Mul(floorDiv(a, b), b))
+			}
+		}
+	default:
+		return Value{}, fmt.Errorf("invalid op (%v)", expr.Op)
+	}
+	return newValue(ret, typ), nil
+}
+
+// evalShift shifts lhs by rhs. The result has the type of
+// the (promoted) lhs.
+func evalShift(d Dialect, op Optype, lhs, rhs Value) (Value, error) {
+	typ := d.promote(lhs.Type)
+
+	n, err := d.shiftCount(rhs, typ)
+	if err != nil {
+		return Value{}, err
+	}
+
+	num := lhs.Convert(typ).int()
+
+	ret := new(big.Int)
+	switch op {
 	case OpSHL:
-		ret = lhs << uint(rhs)
+		ret.Lsh(num, n)
 	case OpSHR:
-		ret = lhs >> uint(rhs)
+		ret.Rsh(num, n)
+	case OpUSHR:
+		ret.Rsh(typ.Unsigned().wrap(num), n)
 	default:
-		return 0, fmt.Errorf("invalid op (%v)", expr.Op)
+		return Value{}, fmt.Errorf("invalid shift (%v)", op)
+	}
+	return newValue(ret, typ), nil
+}
+
+// floorDiv divides rounding towards negative infinity, as
+// Python does, instead of truncating towards zero.
+func floorDiv(a, b *big.Int) *big.Int {
+	q, r := new(big.Int).QuoRem(a, b, new(big.Int))
+	if r.Sign() != 0 && (r.Sign() < 0) != (b.Sign() < 0) {
+		q.Sub(q, big.NewInt(1))
+	}
+	return q
+}
+
+// evalLogical evaluates && and || with short-circuit, the rhs is
+// only evaluated if needed. The result is 0 or 1, but in Python
+// and JavaScript it is the last evaluated operand.
+func (e *interp) evalLogical(expr BinExpr) (Value, error) {
+	d := e.cfg.dialect
+
+	val, err := e.Eval(expr.Lhs)
+	if err != nil {
+		return Value{}, err
+	}
+
+	done, err := e.truth(expr.Lhs, val)
+	if err != nil {
+		return Value{}, err
+	}
+	if expr.Op == OpLAND {
+		done = !done
+	}
+
+	if !done {
+		val, err = e.Eval(expr.Rhs)
+		if err != nil {
+			return Value{}, err
+		}
+	}
+
+	switch d {
+	case LangPython, LangJS:
+		return val, nil
+	}
+	ok, err := e.truth(expr.Rhs, val)
+	if err != nil {
+		return Value{}, err
+	}
+	return boolValue(d, ok), nil
+}
+
+// truth tells if the value of the condition n is true, the
+// symbolic values are neither.
+func (e *interp) truth(n Node, val Value) (bool, error) {
+	if val.sym != nil {
+		return false, fmt.Errorf("the condition %s needs the value of %s",
+			n, val)
+	}
+	return val.Sign() != 0, nil
+}
+
+// compare a and b, the result is 0 or 1 as in C.
+func compare(d Dialect, op Optype, a, b *big.Int) Value {
+	c := a.Cmp(b)
+
+	var ok bool
+	switch op {
+	case OpEQ:
+		ok = c == 0
+	case OpNEQ:
+		ok = c != 0
+	case OpLT:
+		ok = c < 0
+	case OpLE:
+		ok = c <= 0
+	case OpGT:
+		ok = c > 0
+	case OpGE:
+		ok = c >= 0
+	}
+	return boolValue(d, ok)
+}
+
+// boolValue returns the int 1 for true and 0 for false.
+func boolValue(d Dialect, b bool) Value {
+	if b {
+		return NewValue(1, d.intType())
+	}
+	return NewValue(0, d.intType())
+}
+
+func isComparison(op Optype) bool {
+	switch op {
+	case OpEQ, OpNEQ, OpLT, OpLE, OpGT, OpGE:
+		return true
+	}
+	return false
+}
+
+func isBitwiseOP(op Optype) bool {
+	switch op {
+	case OpAND, OpANDNOT, OpOR, OpXOR, OpSHL, OpSHR, OpUSHR:
+		return true
+	}
+	return false
+}
+
+// jsBinOP evaluates op the way JavaScript does: the operands
+// are converted to 32 bits signed integers and only the >>>
+// gives an unsigned result. The results are numbers again.
+func jsBinOP(op Optype, lhs, rhs Value) (Value, error) {
+	a := lhs.Convert(I32)
+	b := rhs.Convert(I32)
+
+	var (
+		ret Value
+		err error
+	)
+
+	switch op {
+	case OpSHL, OpSHR:
+		ret, err = evalShift(LangJS, op, a, b)
+	case OpUSHR:
+		ret, err = evalShift(LangJS, OpSHR, lhs.Convert(U32), b)
+	case OpAND:
+		ret = newValue(new(big.Int).And(a.int(), b.int()), I32)
+	case OpOR:
+		ret = newValue(new(big.Int).Or(a.int(), b.int()), I32)
+	case OpXOR:
+		ret = newValue(new(big.Int).Xor(a.int(), b.int()), I32)
+	default:
+		err = fmt.Errorf("invalid op (%v)", op)
+	}
+
+	if err != nil {
+		return Value{}, err
+	}
+	return ret.Convert(Unbounded), nil
+}
+
+// evalCondExpr evaluates only the branch selected by the
+// condition, so x ? 1/x : 0 never divides by zero.
+func (e *interp) evalCondExpr(cond CondExpr) (Value, error) {
+	val, err := e.Eval(cond.Cond)
+	if err != nil {
+		return Value{}, err
+	}
+
+	ok, err := e.truth(cond.Cond, val)
+	if err != nil {
+		return Value{}, err
+	}
+	if ok {
+		return e.Eval(cond.Then)
+	}
+	return e.Eval(cond.Else)
+}
+
+// evalCall calls the function with the arguments evaluated
+// from left to right. User defined functions shadow the
+// builtin ones.
+func (e *interp) evalCall(call Call) (Value, error) {
+	if fn, ok := e.funcs[call.Name]; ok {
+		return e.callFunc(fn, call)
+	}
+
+	fn, ok := builtins[call.Name]
+	if !ok {
+		return Value{}, fmt.Errorf("undefined function %s", call.Name)
+	}
+
+	args, err := e.evalArgs(call, fn.nargs)
+	if err != nil {
+		return Value{}, err
+	}
+	for _, arg := range args {
+		if arg.sym != nil {
+			return Value{}, e.needsValue(call, arg)
+		}
+	}
+
+	d := e.cfg.dialect
+	ret, err := fn.fn(d, args)
+	if err != nil {
+		return Value{}, fmt.Errorf("%s: %s", call, err)
+	}
+
+	if d == LangJS {
+		// javascript has only numbers
+		return ret.Convert(Unbounded), nil
+	}
+	return ret, nil
+}
+
+func (e *interp) evalArgs(call Call, nargs int) ([]Value, error) {
+	if len(call.Args) != nargs {
+		return nil, fmt.Errorf("%s expects %d arguments but got %d",
+			call.Name, nargs, len(call.Args))
+	}
+
+	args := make([]Value, len(call.Args))
+	for i, arg := range call.Args {
+		val, err := e.Eval(arg)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = val
+	}
+	return args, nil
+}
+
+// callFunc calls the user defined function fn. The parameters
+// and the variables assigned in the body are local to the call,
+// the global variables are only read.
+func (e *interp) callFunc(fn FuncDecl, call Call) (Value, error) {
+	args, err := e.evalArgs(call, len(fn.Params))
+	if err != nil {
+		return Value{}, err
+	}
+
+	if e.depth >= maxCallDepth {
+		return Value{}, fmt.Errorf("%s: maximum recursion depth (%d) exceeded",
+			call.Name, maxCallDepth)
+	}
+
+	locals := make(map[string]Value, len(args))
+	for i, val := range args {
+		// parameters are like assignments
+		if i < len(fn.Types) {
+			val, err = assignable(val, fn.Types[i])
+			if err != nil {
+				return Value{}, fmt.Errorf("%s: %s", call, err)
+			}
+		} else if val.Type == Unbounded {
+			val = val.Convert(e.cfg.dialect.intType())
+		}
+		locals[fn.Params[i]] = val
+	}
+
+	caller := e.locals
+	e.locals = locals
+	e.depth++
+	defer func() {
+		e.locals = caller
+		e.depth--
+	}()
+
+	ret, err := e.Eval(fn.Body)
+	if r, ok := err.(returnErr); ok {
+		ret, err = r.val, nil
+	} else if err == nil && fn.Result != (Type{}) {
+		return Value{}, fmt.Errorf("%s: missing return", call)
+	}
+	if err != nil {
+		// the nodes of the body aren't in the code being
+		// executed, the error is the call.
+		return Value{}, &EvalError{
+			Node: call,
+			Msg:  err.Error(),
+		}
+	}
+
+	if fn.Result != (Type{}) {
+		ret, err = assignable(ret, fn.Result)
+		if err != nil {
+			return Value{}, fmt.Errorf("%s: %s", call, err)
+		}
 	}
 	return ret, nil
 }
 
-func (e *interp) evalAssign(assign Assign) (Int, error) {
+// assignable converts val to the type t of a variable, as in
+// Go: untyped values must fit in t and typed values must have
+// the type t. The bits of untyped symbolic values are wrapped.
+func assignable(val Value, t Type) (Value, error) {
+	if val.Type == t {
+		return val, nil
+	}
+	if val.Type != Unbounded {
+		return Value{}, fmt.Errorf("cannot use %s (type %s) as type %s",
+			val, val.Type, t)
+	}
+	if val.sym == nil && !t.fits(val.int()) {
+		return Value{}, fmt.Errorf("constant %s overflows %s", val, t)
+	}
+	return val.Convert(t), nil
+}
+
+func (e *interp) evalReturn(ret Return) (Value, error) {
+	val, err := e.Eval(ret.Value)
+	if err != nil {
+		return Value{}, err
+	}
+	return Value{}, returnErr{val: val}
+}
+
+func (e *interp) evalIf(n If) (Value, error) {
+	cond, err := e.Eval(n.Cond)
+	if err != nil {
+		return Value{}, err
+	}
+
+	ok, err := e.truth(n.Cond, cond)
+	if err != nil {
+		return Value{}, err
+	}
+	if ok {
+		return e.Eval(n.Then)
+	}
+	if n.Else != nil {
+		return e.Eval(n.Else)
+	}
+	return Value{}, nil
+}
+
+func (e *interp) evalFor(loop For) (Value, error) {
+	if loop.Init != nil {
+		if _, err := e.Eval(loop.Init); err != nil {
+			return Value{}, err
+		}
+	}
+
+	for i := 0; ; i++ {
+		if i == maxLoops {
+			return Value{}, fmt.Errorf("loop exceeded %d iterations", maxLoops)
+		}
+
+		if loop.Cond != nil {
+			cond, err := e.Eval(loop.Cond)
+			if err != nil {
+				return Value{}, err
+			}
+			ok, err := e.truth(loop.Cond, cond)
+			if err != nil {
+				return Value{}, err
+			}
+			if !ok {
+				return Value{}, nil
+			}
+		}
+
+		if _, err := e.Eval(loop.Body); err != nil {
+			return Value{}, err
+		}
+
+		if loop.Post != nil {
+			if _, err := e.Eval(loop.Post); err != nil {
+				return Value{}, err
+			}
+		}
+	}
+}
+
+// evalFuncDecl defines the function, replacing any previous
+// definition. Builtin functions could be redefined too.
+func (e *interp) evalFuncDecl(fn FuncDecl) error {
+	if _, ok := e.cfg.dialect.typeByName(fn.Name); ok {
+		return fmt.Errorf("cannot define function %s: it is a type", fn.Name)
+	}
+	e.funcs[fn.Name] = fn
+	return nil
+}
+
+// evalMacroDecl defines, or undefines, the C macro used by
+// the next parsed code.
+func (e *interp) evalMacroDecl(m MacroDecl) {
+	if m.Undef {
+		delete(e.macros, m.Name)
+		return
+	}
+	e.macros[m.Name] = m
+}
+
+// copyMacros returns a copy of the macros, to parse code
+// without defining its macros in the interpreter.
+func (e *interp) copyMacros() map[string]MacroDecl {
+	macros := make(map[string]MacroDecl, len(e.macros))
+	for name, m := range e.macros {
+		macros[name] = m
+	}
+	return macros
+}
+
+// evalEnum defines the enum constants, in order, as the
+// values could refer to the previous ones.
+func (e *interp) evalEnum(enum Enum) error {
+	for _, c := range enum.Consts {
+		if _, err := e.evalAssign(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Funcs returns the user defined functions, sorted by name.
+func (e *interp) Funcs() []FuncDecl {
+	var funcs []FuncDecl
+	for _, fn := range e.funcs {
+		funcs = append(funcs, fn)
+	}
+	sort.Slice(funcs, func(i, j int) bool {
+		return funcs[i].Name < funcs[j].Name
+	})
+	return funcs
+}
+
+// Undef removes the user defined function.
+func (e *interp) Undef(name string) error {
+	if _, ok := e.funcs[name]; !ok {
+		return fmt.Errorf("undefined function %s", name)
+	}
+	delete(e.funcs, name)
+	return nil
+}
+
+func (e *interp) evalCast(cast Cast) (Value, error) {
+	val, err := e.Eval(cast.Value)
+	if err != nil {
+		return Value{}, err
+	}
+	return val.Convert(cast.To), nil
+}
+
+// evalAssign stores the value in the variable. Variables take
+// the type of the value, untyped values get the int type. In
+// Go, assignments to existing variables follow the Go rules
+// instead, the variable keeps its type.
+// Compound assignments, like x |= y, are the same as
+// x = T(x | y) where T is the type of x.
+func (e *interp) evalAssign(assign Assign) (Value, error) {
+	if assign.Op != 0 {
+		return e.evalCompoundAssign(assign)
+	}
+
 	ret, err := e.Eval(assign.Expr)
 	if err != nil {
-		return 0, err
+		return Value{}, err
+	}
+
+	if old, ok := e.vars()[assign.Varname]; ok && !assign.Define &&
+		e.cfg.dialect == LangGo {
+		ret, err = assignable(ret, old.Type)
+		if err != nil {
+			return Value{}, fmt.Errorf("%s: %s", assign, err)
+		}
+	}
+
+	if ret.Type == Unbounded {
+		ret = ret.Convert(e.cfg.dialect.intType())
+	}
+	e.vars()[assign.Varname] = ret
+	return ret, nil
+}
+
+func (e *interp) evalCompoundAssign(assign Assign) (Value, error) {
+	old, err := e.evalVar(Var{Name: assign.Varname})
+	if err != nil {
+		return Value{}, err
+	}
+
+	ret, err := e.evalBinExpr(BinExpr{
+		Op:  assign.Op,
+		Lhs: Var{Name: assign.Varname},
+		Rhs: assign.Expr,
+	})
+	if err != nil {
+		return Value{}, err
+	}
+
+	ret = ret.Convert(old.Type)
+	e.vars()[assign.Varname] = ret
+	return ret, nil
+}
+
+// sliceBounds evaluates the bounds of the slice hi:lo of v,
+// they must be in the width of v. The lo of single bits is nil.
+func (e *interp) sliceBounds(v Value, hi, lo Node) (uint, uint, error) {
+	var bounds [2]Value
+	for i, n := range []Node{hi, lo} {
+		if n == nil {
+			bounds[i] = bounds[0]
+			continue
+		}
+
+		val, err := e.Eval(n)
+		if err != nil {
+			return 0, 0, err
+		}
+		if val.sym != nil {
+			return 0, 0, fmt.Errorf("the bit %s needs the value of %s", n, val)
+		}
+		bounds[i] = val
+	}
+	return sliceBits(e.cfg.dialect, v.Type, bounds[0], bounds[1])
+}
+
+// sliceBits validates the bits hi:lo of a value of type t.
+func sliceBits(d Dialect, t Type, hi, lo Value) (uint, uint, error) {
+	var bounds [2]uint
+	for i, val := range []Value{hi, lo} {
+		if val.Sign() < 0 || !val.int().IsUint64() || val.Uint64() > maxSliceBit {
+			return 0, 0, fmt.Errorf("invalid bit %s in slice", val)
+		}
+		bounds[i] = uint(val.Uint64())
+	}
+
+	hibit, lobit := bounds[0], bounds[1]
+	if hibit < lobit {
+		return 0, 0, fmt.Errorf("invalid slice [%d:%d], the high bit comes first",
+			hibit, lobit)
+	}
+
+	t = fixedType(d, t)
+	if t.Bits != 0 && hibit >= t.Bits {
+		return 0, 0, fmt.Errorf("bit %d out of range of %s", hibit, t)
+	}
+	return hibit, lobit, nil
+}
+
+// maxSliceBit limits the slices of unbounded integers.
+const maxSliceBit = 1 << 16
+
+// sliceMask returns the mask of the bits hi:lo.
+func sliceMask(hi, lo uint) *big.Int {
+	mask := new(big.Int).Lsh(big.NewInt(1), hi-lo+1)
+	mask.Sub(mask, big.NewInt(1))
+	return mask.Lsh(mask, lo)
+}
+
+// evalSlice returns the bits of the slice, shifted down to
+// bit 0. The result is unsigned with the width of the value.
+func (e *interp) evalSlice(slice Slice) (Value, error) {
+	val, err := e.Eval(slice.Value)
+	if err != nil {
+		return Value{}, err
+	}
+
+	hi, lo, err := e.sliceBounds(val, slice.Hi, slice.Lo)
+	if err != nil {
+		return Value{}, fmt.Errorf("%s: %s", slice, err)
+	}
+	if val.sym != nil {
+		return e.symSlice(slice, val, hi, lo)
+	}
+
+	d := e.cfg.dialect
+	x, t, err := unsignedBits(d, val)
+	if err != nil {
+		return Value{}, fmt.Errorf("%s: %s", slice, err)
+	}
+
+	ret := new(big.Int).And(x, sliceMask(hi, lo))
+	ret.Rsh(ret, lo)
+
+	t = t.Unsigned()
+	if d == LangJS {
+		t = Unbounded
+	}
+	return newValue(ret, t), nil
+}
+
+// evalConcat concatenates the bits of the parts, as in
+// Verilog the result is unsigned with the sum of the widths.
+// Every part must have a width, so numbers without a type
+// suffix or a Verilog size are not allowed.
+func (e *interp) evalConcat(concat Concat) (Value, error) {
+	parts := make([]Value, len(concat.Parts))
+	symbolic := false
+	for i, part := range concat.Parts {
+		if n, ok := part.(Int); ok && n.Typ == (Type{}) {
+			return Value{}, fmt.Errorf("%s: unsized number %s, use a sized number like 8'd%s",
+				concat, n, n)
+		}
+
+		val, err := e.Eval(part)
+		if err != nil {
+			return Value{}, err
+		}
+		if val.Type.Bits == 0 {
+			return Value{}, fmt.Errorf("%s: %s has no width, use a cast like u8(x)",
+				concat, part)
+		}
+		parts[i] = val
+		symbolic = symbolic || val.sym != nil
+	}
+	if symbolic {
+		return symConcat(parts), nil
+	}
+
+	ret := new(big.Int)
+	width := uint(0)
+	for _, val := range parts {
+		ret.Lsh(ret, val.Type.Bits)
+		ret.Or(ret, val.Type.Unsigned().wrap(val.int()))
+		width += val.Type.Bits
+	}
+	return newValue(ret, Type{Bits: width}), nil
+}
+
+// maxReplicate limits the replication count.
+const maxReplicate = 1 << 16
+
+// evalReplicate concatenates the value count times.
+func (e *interp) evalReplicate(rep Replicate) (Value, error) {
+	count, err := e.Eval(rep.Count)
+	if err != nil {
+		return Value{}, err
+	}
+	if count.sym != nil {
+		return Value{}, fmt.Errorf("%s: the count needs the value of %s", rep, count)
+	}
+	if count.Sign() <= 0 || count.int().Cmp(big.NewInt(maxReplicate)) > 0 {
+		return Value{}, fmt.Errorf("%s: invalid replication count %s", rep, count)
+	}
+
+	val, err := e.evalConcat(rep.Value)
+	if err != nil {
+		return Value{}, err
+	}
+	if val.sym != nil {
+		return symReplicate(val, count.Int64()), nil
+	}
+
+	ret := new(big.Int)
+	for i := int64(0); i < count.Int64(); i++ {
+		ret.Lsh(ret, val.Type.Bits)
+		ret.Or(ret, val.int())
+	}
+	return newValue(ret, Type{Bits: val.Type.Bits * uint(count.Int64())}), nil
+}
+
+// evalSliceAssign sets the bits of the slice of the variable,
+// the variable keeps its type. The value must fit the slice.
+func (e *interp) evalSliceAssign(assign SliceAssign) (Value, error) {
+	old, err := e.evalVar(Var{Name: assign.Varname})
+	if err != nil {
+		return Value{}, err
+	}
+
+	hi, lo, err := e.sliceBounds(old, assign.Hi, assign.Lo)
+	if err != nil {
+		return Value{}, fmt.Errorf("%s: %s", assign, err)
+	}
+
+	val, err := e.Eval(assign.Expr)
+	if err != nil {
+		return Value{}, err
+	}
+	if old.sym != nil || val.sym != nil {
+		ret, err := symSliceAssign(assign, old, val, hi, lo)
+		if err != nil {
+			return Value{}, err
+		}
+		e.vars()[assign.Varname] = ret
+		return ret, nil
+	}
+	if val.Sign() < 0 || uint(val.int().BitLen()) > hi-lo+1 {
+		return Value{}, fmt.Errorf("%s: %s does not fit in %d bits",
+			assign, val, hi-lo+1)
+	}
+
+	x := new(big.Int).AndNot(old.int(), sliceMask(hi, lo))
+	x.Or(x, new(big.Int).Lsh(val.int(), lo))
+
+	ret := newValue(x, old.Type)
+	e.vars()[assign.Varname] = ret
+	return ret, nil
+}
+
+// evalStmtList evaluates the statements in order, the result
+// is the result of the last statement.
+func (e *interp) evalStmtList(list StmtList) (Value, error) {
+	var (
+		ret Value
+		err error
+	)
+	for _, stmt := range list.Stmts {
+		ret, err = e.Eval(stmt)
+		if err != nil {
+			return Value{}, err
+		}
 	}
-	e.environ[assign.Varname] = ret
 	return ret, nil
 }
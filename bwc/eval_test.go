@@ -2,15 +2,26 @@ package bwc
 
 import (
 	"fmt"
+	"math/big"
+	"strings"
 	"testing"
 )
 
 var format = fmt.Sprintf
 
-func desc(n Node, res Int) string {
+// exec the code and returns the result of the last statement.
+func exec(interp *interp, code string) (Value, error) {
+	res, err := interp.Exec(code)
+	if err != nil {
+		return Value{}, err
+	}
+	return res[len(res)-1], nil
+}
+
+func desc(n Node, res int64) string {
 	if n.Type() == NodeBinExpr {
 		a := n.(BinExpr)
-		return format("%s %s %s = %s",
+		return format("%s %s %s = %d",
 			a.Lhs, a.Rhs, a.Op, res)
 	} else if n.Type() == NodeUnaryExpr {
 		a := n.(UnaryExpr)
@@ -18,7 +29,7 @@ func desc(n Node, res Int) string {
 	}
 
 	a := n.(Int)
-	return format("%d", a)
+	return a.String()
 }
 
 func TestEvalGrammar(t *testing.T) {
@@ -26,7 +37,7 @@ func TestEvalGrammar(t *testing.T) {
 
 	for _, tc := range []struct {
 		code string
-		res  Int
+		res  int64
 	}{
 		{
 			code: "0",
@@ -96,6 +107,50 @@ func TestEvalGrammar(t *testing.T) {
 			code: "1<<10|0xff",
 			res:  0x4ff,
 		},
+		{
+			code: "4|2&1",
+			res:  4,
+		},
+		{
+			code: "6^3|8",
+			res:  13,
+		},
+		{
+			code: "1|1<<4",
+			res:  0x11,
+		},
+		{
+			code: "x = 0b101100",
+			res:  0x2c,
+		},
+		{
+			code: "x & (x - 1)",
+			res:  0x28,
+		},
+		{
+			code: "x & -x",
+			res:  0x4,
+		},
+		{
+			code: "(x + 7) & ~7",
+			res:  0x30,
+		},
+		{
+			code: "0x0203 * 0x0101010101010101 >> 56",
+			res:  5,
+		},
+		{
+			code: "-7 / 2",
+			res:  -3,
+		},
+		{
+			code: "-7 % 2",
+			res:  -1,
+		},
+		{
+			code: "1 + 2 * 3 - 4",
+			res:  3,
+		},
 		{
 			code: "b = 0b01010101",
 			res:  0x55,
@@ -106,59 +161,87 @@ func TestEvalGrammar(t *testing.T) {
 		},
 	} {
 
-		got, err := interp.Exec(tc.code)
+		got, err := exec(interp, tc.code)
 		if err != nil {
 			t.Fatal(err)
 		}
 
-		if got != tc.res {
-			t.Fatalf("got(%s) != expected(%s)", got, tc.res)
+		if got.Int64() != int64(tc.res) {
+			t.Fatalf("got(%s) != expected(%d)", got, tc.res)
 		}
 	}
 }
 
+func TestEvalLeftToRight(t *testing.T) {
+	interp := NewInterp(LeftToRight())
+
+	got, err := exec(interp, "0|1&2|3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Int64() != 3 {
+		t.Fatalf("got(%s) != expected(3)", got)
+	}
+
+	got, err = exec(interp, "1|2&1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Int64() != 1 {
+		t.Fatalf("got(%s) != expected(1)", got)
+	}
+
+	got, err = exec(interp, "1|1<<4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Int64() != 0x10 {
+		t.Fatalf("got(%s) != expected(16)", got)
+	}
+}
+
 func TestEval(t *testing.T) {
 	for _, tc := range []struct {
 		expr Node
-		res  Int
+		res  int64
 	}{
 		{
 			expr: BinExpr{
 				Op:  OpAND,
-				Lhs: Int(0),
-				Rhs: Int(0),
+				Lhs: NewInt(0),
+				Rhs: NewInt(0),
 			},
 			res: 0,
 		},
 		{
 			expr: BinExpr{
 				Op:  OpAND,
-				Lhs: Int(0),
-				Rhs: Int(1),
+				Lhs: NewInt(0),
+				Rhs: NewInt(1),
 			},
 			res: 0,
 		},
 		{
 			expr: BinExpr{
 				Op:  OpAND,
-				Lhs: Int(1),
-				Rhs: Int(1),
+				Lhs: NewInt(1),
+				Rhs: NewInt(1),
 			},
 			res: 1,
 		},
 		{
 			expr: BinExpr{
 				Op:  OpAND,
-				Lhs: Int(0xffff0000),
-				Rhs: Int(0x0000ffff),
+				Lhs: NewInt(0xffff0000),
+				Rhs: NewInt(0x0000ffff),
 			},
 			res: 0,
 		},
 		{
 			expr: BinExpr{
 				Op:  OpOR,
-				Lhs: Int(0xffff0000),
-				Rhs: Int(0x0000ffff),
+				Lhs: NewInt(0xffff0000),
+				Rhs: NewInt(0x0000ffff),
 			},
 			res: 0xffffffff,
 		},
@@ -169,10 +252,10 @@ func TestEval(t *testing.T) {
 				Op: OpAND,
 				Lhs: BinExpr{
 					Op:  OpOR,
-					Lhs: Int(0xffff0000),
-					Rhs: Int(0x0000ffff),
+					Lhs: NewInt(0xffff0000),
+					Rhs: NewInt(0x0000ffff),
 				},
-				Rhs: Int(0x000000ff),
+				Rhs: NewInt(0x000000ff),
 			},
 			res: 0x000000ff,
 		},
@@ -181,13 +264,13 @@ func TestEval(t *testing.T) {
 				Op: OpOR,
 				Lhs: BinExpr{
 					Op:  OpOR,
-					Lhs: Int(0x000000ff),
-					Rhs: Int(0xff000000),
+					Lhs: NewInt(0x000000ff),
+					Rhs: NewInt(0xff000000),
 				},
 				Rhs: BinExpr{
 					Op:  OpOR,
-					Lhs: Int(0x00ff0000),
-					Rhs: Int(0x0000ff00),
+					Lhs: NewInt(0x00ff0000),
+					Rhs: NewInt(0x0000ff00),
 				},
 			},
 			res: 0xffffffff,
@@ -195,7 +278,7 @@ func TestEval(t *testing.T) {
 		{
 			expr: UnaryExpr{
 				Op:    OpNOT,
-				Value: Int(7),
+				Value: NewInt(7),
 			},
 			res: -8,
 		},
@@ -207,9 +290,719 @@ func TestEval(t *testing.T) {
 			if err != nil {
 				t.Fatal(err)
 			}
-			if got != tc.res {
-				t.Fatalf("Fail: %d != %d", got, tc.res)
+			if got.Int64() != int64(tc.res) {
+				t.Fatalf("Fail: %s != %d", got, tc.res)
 			}
 		})
 	}
 }
+
+func TestEvalDialects(t *testing.T) {
+	for _, tc := range []struct {
+		code    string
+		dialect Dialect
+		res     int64
+	}{
+		{code: "6&2<<1", dialect: LangC, res: 4},
+		{code: "6&2<<1", dialect: LangGo, res: 4},
+		{code: "7&2<<1", dialect: LangGo, res: 4},
+		{code: "7&1<<1", dialect: LangC, res: 2},
+		{code: "7&1<<1", dialect: LangGo, res: 2},
+		{code: "1|2^3", dialect: LangC, res: 1},
+		{code: "1|2^3", dialect: LangGo, res: 0},
+		{code: "0xff&^0xf", dialect: LangGo, res: 0xf0},
+		{code: "!0", dialect: LangRust, res: -1},
+		{code: "~0>>>60", dialect: LangJava, res: 0xf},
+		{code: "1<<65", dialect: LangJava, res: 2},
+		{code: "1<<31", dialect: LangJS, res: -2147483648},
+		{code: "1<<33", dialect: LangJS, res: 2},
+		{code: "~0>>>28", dialect: LangJS, res: 0xf},
+		{code: "0x1ffffffff|0", dialect: LangJS, res: -1},
+		{code: "~0xffffffff", dialect: LangJS, res: 0},
+		{code: "2+3&3", dialect: LangC, res: 1},
+		{code: "2+3&3", dialect: LangGo, res: 5},
+		{code: "-7/2", dialect: LangJS, res: -3},
+		{code: "-7//2", dialect: LangPython, res: -4},
+		{code: "-7%2", dialect: LangPython, res: 1},
+		{code: "7%-2", dialect: LangPython, res: -1},
+		{code: "not 0", dialect: LangPython, res: 1},
+		{code: "not 1|2", dialect: LangPython, res: 0},
+		{code: "~~0xff", dialect: LangC, res: 0xff},
+		{code: "-~5", dialect: LangC, res: 6},
+		{code: "~-5", dialect: LangC, res: 4},
+		{code: "!5", dialect: LangC, res: 0},
+		{code: "!!5", dialect: LangJS, res: 1},
+		{code: "!0+1", dialect: LangJava, res: 2},
+		{code: "+-5", dialect: LangPython, res: -5},
+		{code: "^0x0f&0xff", dialect: LangGo, res: 0xf0},
+		{code: "!!0", dialect: LangRust, res: 0},
+		{code: "a = 5; !a", dialect: LangGo, res: 0},
+		{code: "x = 1\n  | 2\n  | 4\nx", dialect: LangC, res: 7},
+		{code: "x = 0xff\n\t& 0xf0\n\t>> 4\nx", dialect: LangC, res: 0xf},
+		{code: "x = 3 /* a\n b */ & 1\nx", dialect: LangC, res: 1},
+		{code: "x = 1\n-1", dialect: LangC, res: -1},
+		{code: "x = 8\n  >>> 1\nx", dialect: LangJS, res: 4},
+		{code: "a = 0; !a", dialect: LangGo, res: 1},
+	} {
+		interp := NewInterp(Lang(tc.dialect))
+		got, err := exec(interp, tc.code)
+		if err != nil {
+			t.Fatalf("%s: %s: %s", tc.dialect, tc.code, err)
+		}
+
+		if got.Int64() != int64(tc.res) {
+			t.Fatalf("%s: %s: got(%s) != expected(%d)",
+				tc.dialect, tc.code, got, tc.res)
+		}
+	}
+}
+
+func TestEvalLogical(t *testing.T) {
+	for _, tc := range []struct {
+		code    string
+		dialect Dialect
+		res     int64
+		typ     Type
+	}{
+		{code: "x = 0xf3; (x & 0x3) == 0x3", dialect: LangC, res: 1, typ: I32},
+		{code: "x = 0xf3; x & 0x3 == 0x3", dialect: LangC, res: 1, typ: I32},
+		{code: "x = 0xf2; x & 0x3 == 0x3", dialect: LangC, res: 0, typ: I32},
+		{code: "x = 0xf2; x & 0x3 == 0x3", dialect: LangRust, res: 0, typ: I32},
+		{code: "x = 0xf2; x & 0x3 == 0x2", dialect: LangGo, res: 1, typ: I64},
+		{code: "5 != 0", dialect: LangC, res: 1, typ: I32},
+		{code: "-1 < 0", dialect: LangC, res: 1, typ: I32},
+		{code: "-1 < 0u", dialect: LangC, res: 0, typ: I32},
+		{code: "-1 < 0", dialect: LangJS, res: 1, typ: Unbounded},
+		{code: "2 <= 2 && 3 >= 4", dialect: LangC, res: 0, typ: I32},
+		{code: "2 > 1 || 1/0", dialect: LangC, res: 1, typ: I32},
+		{code: "0 && 1/0", dialect: LangC, res: 0, typ: I32},
+		{code: "3 && 5", dialect: LangC, res: 1, typ: I32},
+		{code: "3 && 5", dialect: LangJS, res: 5, typ: Unbounded},
+		{code: "0 || 7", dialect: LangJS, res: 7, typ: Unbounded},
+		{code: "0 or 7", dialect: LangPython, res: 7, typ: Unbounded},
+		{code: "3 and 0", dialect: LangPython, res: 0, typ: Unbounded},
+		{code: "1 < 2 < 3", dialect: LangPython, res: 1, typ: Unbounded},
+		{code: "3 > 2 > 2", dialect: LangPython, res: 0, typ: Unbounded},
+		{code: "3 > 2 > 2", dialect: LangC, res: 0, typ: I32},
+		{code: "not 2 == 3", dialect: LangPython, res: 1, typ: Unbounded},
+		{code: "x := uint8(3); !(x == 3)", dialect: LangGo, res: 0, typ: I64},
+		{code: "x = 0; x ? 100/x : -1", dialect: LangC, res: -1, typ: I32},
+		{code: "x = 4; x ? 100/x : -1", dialect: LangC, res: 25, typ: I32},
+		{code: "a = 5; b = 9; a < b ? a : b", dialect: LangJava, res: 5, typ: I32},
+		{code: "0 ? 1 : 0 ? 2 : 3", dialect: LangC, res: 3, typ: I32},
+		{code: "x = 0; 100//x if x else -1", dialect: LangPython, res: -1, typ: Unbounded},
+		{code: "x = 7; x ^= x > 5; x", dialect: LangC, res: 6, typ: I32},
+	} {
+		interp := NewInterp(Lang(tc.dialect))
+		got, err := exec(interp, tc.code)
+		if err != nil {
+			t.Fatalf("%s: %s: %s", tc.dialect, tc.code, err)
+		}
+
+		if got.Int64() != tc.res || got.Type != tc.typ {
+			t.Fatalf("%s: %s: got(%s %s) != expected(%d %s)",
+				tc.dialect, tc.code, got, got.Type, tc.res, tc.typ)
+		}
+	}
+}
+
+func TestEvalErrors(t *testing.T) {
+	for _, tc := range []struct {
+		code    string
+		dialect Dialect
+	}{
+		{code: "1<<~0", dialect: LangC},
+		{code: "1<<64", dialect: LangRust},
+		{code: "1/0", dialect: LangC},
+		{code: "1%(1-1)", dialect: LangGo},
+		{code: "1//0", dialect: LangPython},
+		{code: "1/2", dialect: LangPython},
+		{code: "1<<32", dialect: LangC},
+		{code: "uint32(1)+uint64(1)", dialect: LangGo},
+		{code: "u8(1)+u16(1)", dialect: LangRust},
+		{code: "uint8(1) == uint16(1)", dialect: LangGo},
+		{code: "(1 || 0) && 1/0", dialect: LangC},
+		{code: "1 ? 1/0 : 0", dialect: LangC},
+	} {
+		interp := NewInterp(Lang(tc.dialect))
+		_, err := exec(interp, tc.code)
+		if err == nil {
+			t.Fatalf("%s: expected error evaluating %q",
+				tc.dialect, tc.code)
+		}
+	}
+}
+
+func TestShiftCount(t *testing.T) {
+	for _, tc := range []struct {
+		dialect Dialect
+		count   int64
+		typ     Type
+		res     uint
+		err     bool
+	}{
+		{dialect: LangJava, count: 65, typ: I64, res: 1},
+		{dialect: LangJava, count: -1, typ: I32, res: 31},
+		{dialect: LangJS, count: 33, typ: I32, res: 1},
+		{dialect: LangJS, count: 33, typ: Unbounded, res: 33},
+		{dialect: LangJS, count: -1, typ: Unbounded, err: true},
+		{dialect: LangJava, count: 1 << 40, typ: Unbounded, err: true},
+	} {
+		res, err := tc.dialect.shiftCount(newValue(big.NewInt(tc.count), I64), tc.typ)
+		if tc.err {
+			if err == nil {
+				t.Fatalf("%s: expected error shifting %s by %d",
+					tc.dialect, tc.typ, tc.count)
+			}
+			continue
+		}
+		if err != nil || res != tc.res {
+			t.Fatalf("%s: shifting %s by %d: got %d, %v != expected %d",
+				tc.dialect, tc.typ, tc.count, res, err, tc.res)
+		}
+	}
+}
+
+func TestEvalTypes(t *testing.T) {
+	for _, tc := range []struct {
+		code    string
+		dialect Dialect
+		res     int64
+		typ     Type
+	}{
+		{code: "1", dialect: LangC, res: 1, typ: I32},
+		{code: "0xffffffff", dialect: LangC, res: 0xffffffff, typ: U32},
+		{code: "0x100000000", dialect: LangC, res: 0x100000000, typ: I64},
+		{code: "~0xffffffff", dialect: LangC, res: 0, typ: U32},
+		{code: "1<<31", dialect: LangC, res: -0x80000000, typ: I32},
+		{code: "(uint8_t)0x1ff", dialect: LangC, res: 0xff, typ: U8},
+		{code: "(uint8_t)~0", dialect: LangC, res: 0xff, typ: U8},
+		{code: "~(uint8_t)0", dialect: LangC, res: -1, typ: I32},
+		{code: "(uint8_t)0xff+1", dialect: LangC, res: 0x100, typ: I32},
+		{code: "(int8_t)0x80", dialect: LangC, res: -128, typ: I8},
+		{code: "(uint32_t)-1", dialect: LangC, res: 0xffffffff, typ: U32},
+		{code: "(uint32_t)0xffffffff+1", dialect: LangC, res: 0, typ: U32},
+		{code: "(uint32_t)1<<31", dialect: LangC, res: 0x80000000, typ: U32},
+		{code: "(unsigned)1+(long)1", dialect: LangC, res: 2, typ: I64},
+		{code: "(unsigned)1+1", dialect: LangC, res: 2, typ: U32},
+		{code: "(unsigned long long)1<<63", dialect: LangC, res: -0x8000000000000000, typ: U64},
+		{code: "(uint32_t)0x80000000>>31", dialect: LangC, res: 1, typ: U32},
+		{code: "(int32_t)0x80000000>>31", dialect: LangC, res: -1, typ: I32},
+		{code: "1<<40", dialect: LangGo, res: 1 << 40, typ: Unbounded},
+		{code: "uint8(0xff)+1", dialect: LangGo, res: 0, typ: U8},
+		{code: "~uint8(0)", dialect: LangGo, res: 0xff, typ: U8},
+		{code: "uint32(1)<<32", dialect: LangGo, res: 0, typ: U32},
+		{code: "int8(-128)>>8", dialect: LangGo, res: -1, typ: I8},
+		{code: "uint64(1)<<63>>63", dialect: LangGo, res: 1, typ: U64},
+		{code: "!u8(0)", dialect: LangRust, res: 0xff, typ: U8},
+		{code: "u8(0xff)&0xf", dialect: LangRust, res: 0xf, typ: U8},
+		{code: "(byte)0x80", dialect: LangJava, res: -128, typ: I8},
+		{code: "(char)-1>>>1", dialect: LangJava, res: 0x7fff, typ: I32},
+		{code: "-1>>>1", dialect: LangJava, res: 0x7fffffff, typ: I32},
+		{code: "(long)-1>>>1", dialect: LangJava, res: 0x7fffffffffffffff, typ: I64},
+		{code: "(2147483647|0)+1", dialect: LangJS, res: 0x80000000, typ: Unbounded},
+		{code: "-1>>>0", dialect: LangJS, res: 0xffffffff, typ: Unbounded},
+		{code: "~0", dialect: LangJS, res: -1, typ: Unbounded},
+		{code: "1<<100>>99", dialect: LangPython, res: 2, typ: Unbounded},
+		{code: "0xffu8+1", dialect: LangRust, res: 0, typ: U8},
+		{code: "!0u16", dialect: LangRust, res: 0xffff, typ: U16},
+		{code: "~0UL", dialect: LangC, res: -1, typ: U64},
+		{code: "3000000000 > -1", dialect: LangC, res: 1, typ: I32},
+		{code: "4294967295 + 1", dialect: LangC, res: 4294967296, typ: I64},
+		{code: "-2147483648", dialect: LangC, res: -2147483648, typ: I64},
+		{code: "0xffffffff + 1", dialect: LangC, res: 0, typ: U32},
+		{code: "037777777777 + 1", dialect: LangC, res: 0, typ: U32},
+		{code: "+(uint8_t)0xff", dialect: LangC, res: 0xff, typ: I32},
+		{code: "^uint8(0x0f)", dialect: LangGo, res: 0xf0, typ: U8},
+		{code: "1ULL<<63>>63", dialect: LangC, res: 1, typ: U64},
+		{code: "~0xffu", dialect: LangC, res: 0xffffff00, typ: U32},
+		{code: "0x1F|0X20", dialect: LangC, res: 0x3f, typ: I32},
+		{code: "0o17", dialect: LangPython, res: 15, typ: Unbounded},
+		{code: "0755&0o700", dialect: LangGo, res: 0700, typ: Unbounded},
+		{code: "8'hF0|8'h0F", dialect: LangRust, res: 0xff, typ: U8},
+	} {
+		interp := NewInterp(Lang(tc.dialect))
+		got, err := exec(interp, tc.code)
+		if err != nil {
+			t.Fatalf("%s: %s: %s", tc.dialect, tc.code, err)
+		}
+
+		if got.Int64() != tc.res || got.Type != tc.typ {
+			t.Fatalf("%s: %s: got(%s %s) != expected(%d %s)",
+				tc.dialect, tc.code, got, got.Type, tc.res, tc.typ)
+		}
+	}
+}
+
+func TestEvalTypedVars(t *testing.T) {
+	interp := NewInterp(Lang(LangGo))
+	for _, code := range []string{
+		"X = uint64(0xff)",
+		"X = (X | (X << 16)) & 0x0000ffff0000ffff",
+		"X = (X | (X << 8)) & 0x00ff00ff00ff00ff",
+		"X = (X | (X << 4)) & 0x0f0f0f0f0f0f0f0f",
+		"X = (X | (X << 2)) & 0x3333333333333333",
+		"X = (X | (X << 1)) & 0x5555555555555555",
+	} {
+		_, err := exec(interp, code)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, err := exec(interp, "X")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Int64() != 0x5555 || got.Type != U64 {
+		t.Fatalf("got(%s %s) != expected(0x5555 u64)", got, got.Type)
+	}
+
+	got, err = exec(interp, "a = 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Type != I64 {
+		t.Fatalf("untyped constant stored as %s", got.Type)
+	}
+}
+
+func TestEvalWideValues(t *testing.T) {
+	for _, tc := range []struct {
+		code    string
+		dialect Dialect
+		res     string // hex
+		typ     Type
+	}{
+		{code: "0xffffffffffffffff", dialect: LangC, res: "ffffffffffffffff", typ: U64},
+		{code: "0x8000000000000000", dialect: LangC, res: "8000000000000000", typ: U64},
+		{code: "~0x8000000000000000", dialect: LangC, res: "7fffffffffffffff", typ: U64},
+		{code: "0xffffffffffffffff+1", dialect: LangC, res: "0", typ: U64},
+		{code: "18446744073709551615", dialect: LangC, res: "ffffffffffffffff", typ: U64},
+		{code: "0x10000000000000000", dialect: LangC, res: "10000000000000000", typ: U128},
+		{code: "(__uint128_t)1<<127", dialect: LangC, res: "80000000000000000000000000000000", typ: U128},
+		{code: "(unsigned __int128)~0", dialect: LangC, res: "ffffffffffffffffffffffffffffffff", typ: U128},
+		{code: "(__int128)-1>>100", dialect: LangC, res: "ffffffffffffffffffffffffffffffff", typ: I128},
+		{code: "u256(1)<<255", dialect: LangC, res: "8000000000000000000000000000000000000000000000000000000000000000", typ: U256},
+		{code: "uint64(0xffffffffffffffff)>>63", dialect: LangGo, res: "1", typ: U64},
+		{code: "u128(1)<<64|1", dialect: LangRust, res: "10000000000000001", typ: U128},
+		{code: "1<<200", dialect: LangPython, res: "1" + strings.Repeat("0", 50), typ: Unbounded},
+		{code: "-(1<<64)", dialect: LangPython, res: "-10000000000000000", typ: Unbounded},
+		{code: "int(0xffffffffffffffffffff)+1", dialect: LangPython, res: "100000000000000000000", typ: Unbounded},
+	} {
+		interp := NewInterp(Lang(tc.dialect))
+		got, err := exec(interp, tc.code)
+		if err != nil {
+			t.Fatalf("%s: %s: %s", tc.dialect, tc.code, err)
+		}
+
+		if got.Text(16) != tc.res || got.Type != tc.typ {
+			t.Fatalf("%s: %s: got(%s %s) != expected(%s %s)",
+				tc.dialect, tc.code, got.Text(16), got.Type,
+				tc.res, tc.typ)
+		}
+	}
+}
+
+func TestExecStatements(t *testing.T) {
+	interp := NewInterp(Lang(LangGo))
+	res, err := interp.Exec(`
+		X := uint64(0xff)
+		X = (X | (X << 16)) & 0x0000ffff0000ffff
+		X = (X | (X << 8)) & 0x00ff00ff00ff00ff
+		X = (X | (X << 4)) & 0x0f0f0f0f0f0f0f0f
+		X = (X | (X << 2)) & 0x3333333333333333
+		X = (X | (X << 1)) & 0x5555555555555555
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []int64{0xff, 0xff, 0xff, 0xf0f, 0x3333, 0x5555}
+	if len(res) != len(expected) {
+		t.Fatalf("expected %d results but got %d", len(expected), len(res))
+	}
+	for i, val := range res {
+		if val.Int64() != expected[i] || val.Type != U64 {
+			t.Fatalf("stmt %d: got(%s %s) != expected(%d u64)",
+				i, val, val.Type, expected[i])
+		}
+	}
+
+	res, err = interp.Exec("a = 1; b = a + 1; c = 0 / 0; d = 1")
+	if err == nil {
+		t.Fatal("expected division by zero")
+	}
+	if len(res) != 2 {
+		t.Fatalf("expected the 2 results before the error but got %v", res)
+	}
+}
+
+func TestEvalCompoundAssign(t *testing.T) {
+	for _, tc := range []struct {
+		code    string
+		dialect Dialect
+		res     int64
+		typ     Type
+	}{
+		{code: "x = (uint8_t)0xff; x += 1", dialect: LangC, res: 0, typ: U8},
+		{code: "x = (uint8_t)0x0f; x <<= 4", dialect: LangC, res: 0xf0, typ: U8},
+		{code: "x = (uint8_t)0xf0; x = x << 4", dialect: LangC, res: 0xf00, typ: I32},
+		{code: "x = 6; x |= 1; x &= 3; x ^= 1", dialect: LangC, res: 2, typ: I32},
+		{code: "x = 7; x -= 9; x *= 3; x /= 2; x %= 2", dialect: LangC, res: -1, typ: I32},
+		{code: "x := uint16(0xffff); x &^= 0xff", dialect: LangGo, res: 0xff00, typ: U16},
+		{code: "x = (byte)-1; x >>>= 4", dialect: LangJava, res: -1, typ: I8},
+		{code: "x = -1; x >>>= 28", dialect: LangJS, res: 15, typ: Unbounded},
+		{code: "x = -7; x //= 2", dialect: LangPython, res: -4, typ: Unbounded},
+	} {
+		interp := NewInterp(Lang(tc.dialect))
+		got, err := exec(interp, tc.code)
+		if err != nil {
+			t.Fatalf("%s: %s: %s", tc.dialect, tc.code, err)
+		}
+
+		if got.Int64() != tc.res || got.Type != tc.typ {
+			t.Fatalf("%s: %s: got(%s %s) != expected(%d %s)",
+				tc.dialect, tc.code, got, got.Type, tc.res, tc.typ)
+		}
+	}
+
+	interp := NewInterp()
+	if _, err := interp.Exec("undef |= 1"); err == nil {
+		t.Fatal("expected undefined variable error")
+	}
+}
+
+func TestExecComments(t *testing.T) {
+	for _, tc := range []struct {
+		code    string
+		dialect Dialect
+		res     int64
+	}{
+		{code: "x = 0xf0 // high nibble\nx >> 4", dialect: LangC, res: 0xf},
+		{code: "x = 0xf0 /* high\nnibble */ x >> 4", dialect: LangC, res: 0xf},
+		{code: "x = 0xf0 | /* low nibble */ 0x0f", dialect: LangC, res: 0xff},
+		{code: "x := 0xf0 // high nibble\nx &^ 0x30", dialect: LangGo, res: 0xc0},
+		{code: "x = 0xf0 # high nibble\nx // 16", dialect: LangPython, res: 0xf},
+		{code: "x = 0xf0 # high nibble\nx >>> 4", dialect: LangJS, res: 0xf},
+	} {
+		interp := NewInterp(Lang(tc.dialect))
+		val, err := exec(interp, tc.code)
+		if err != nil {
+			t.Fatalf("%s: %s", tc.code, err)
+		}
+		if val.Int64() != tc.res {
+			t.Fatalf("%s: got %s != expected %d", tc.code, val, tc.res)
+		}
+	}
+}
+
+func TestEvalFuncs(t *testing.T) {
+	for _, tc := range []struct {
+		code    string
+		dialect Dialect
+		res     int64
+		typ     Type
+	}{
+		{code: "fn sq(x) = x * x; sq(3) + sq(4)", dialect: LangC, res: 25, typ: I32},
+		{code: "fn f() = 7; f()", dialect: LangC, res: 7, typ: I32},
+		{code: "fn add(a, b) = a + b; add(1, 2)", dialect: LangC, res: 3, typ: I32},
+		{code: `
+			fn stripe(X) {
+				X = (X | (X << 16)) & 0x0000ffff0000ffff
+				X = (X | (X << 8)) & 0x00ff00ff00ff00ff
+				X = (X | (X << 4)) & 0x0f0f0f0f0f0f0f0f
+				X = (X | (X << 2)) & 0x3333333333333333
+				X = (X | (X << 1)) & 0x5555555555555555
+			}
+			stripe(uint64(0x0f)) | stripe(uint64(0xf0)) << 1
+		`, dialect: LangGo, res: 0xaa55, typ: U64},
+		{code: "fn fact(n) = n ? n * fact(n - 1) : 1; fact(10)", dialect: LangC, res: 3628800, typ: I32},
+		{code: "m = 0xf; fn low(x) = x & m; low(0xab)", dialect: LangC, res: 0xb, typ: I32},
+		{code: "x = 1; fn f(y) { x = y; x + 1 }; f(5); x", dialect: LangC, res: 1, typ: I32},
+		{code: "x = 1; fn f(x) = x; f(5) + x", dialect: LangC, res: 6, typ: I32},
+		{code: "fn popcount(x) = 42; popcount(1)", dialect: LangC, res: 42, typ: I32},
+		{code: "fn f(x) = x; f(1)", dialect: LangGo, res: 1, typ: I64},
+		{code: "fn f(x) = x; f(1)", dialect: LangPython, res: 1, typ: Unbounded},
+		{code: "fn f(x) = x; fn f(x) = -x; f(1)", dialect: LangC, res: -1, typ: I32},
+	} {
+		interp := NewInterp(Lang(tc.dialect))
+		got, err := exec(interp, tc.code)
+		if err != nil {
+			t.Fatalf("%s: %s: %s", tc.dialect, tc.code, err)
+		}
+
+		if got.Int64() != tc.res || got.Type != tc.typ {
+			t.Fatalf("%s: %s: got(%s %s) != expected(%d %s)",
+				tc.dialect, tc.code, got, got.Type, tc.res, tc.typ)
+		}
+	}
+}
+
+func TestEvalFuncErrors(t *testing.T) {
+	for _, code := range []string{
+		"fn f(x) = x; f()",
+		"fn f(x) = x; f(1, 2)",
+		"fn f(x) = f(x); f(1)",
+		"fn f(x) = y; f(1)",
+		"fn f(x) { y = x }; f(1); y",
+		"fn uint32_t(x) = x",
+	} {
+		interp := NewInterp()
+		_, err := exec(interp, code)
+		if err == nil {
+			t.Fatalf("expected error evaluating %q", code)
+		}
+	}
+}
+
+func TestInterpFuncs(t *testing.T) {
+	interp := NewInterp()
+	res, err := interp.Exec("fn b(x) = x; fn a(x, y) { x; y }; 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res) != 1 {
+		t.Fatalf("definitions must have no result: %v", res)
+	}
+
+	var names []string
+	for _, fn := range interp.Funcs() {
+		names = append(names, fn.String())
+	}
+	got := strings.Join(names, "\n")
+	expected := "fn a(x, y) { x; y }\nfn b(x) = x"
+	if got != expected {
+		t.Fatalf("got %q != expected %q", got, expected)
+	}
+
+	if err := interp.Undef("a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := interp.Undef("a"); err == nil {
+		t.Fatal("expected error removing an undefined function")
+	}
+	if _, err := exec(interp, "a(1, 2)"); err == nil {
+		t.Fatal("expected error calling a removed function")
+	}
+	if len(interp.Funcs()) != 1 {
+		t.Fatalf("expected only b but got %v", interp.Funcs())
+	}
+}
+
+func TestEvalMacros(t *testing.T) {
+	for _, tc := range []struct {
+		code string
+		res  int64
+		typ  Type
+	}{
+		{
+			code: `
+				#define PAGE_SHIFT 12
+				#define PAGE_SIZE (1UL << PAGE_SHIFT)
+				#define PAGE_MASK (~(PAGE_SIZE-1))
+				0x12345 & PAGE_MASK
+			`,
+			res: 0x12000,
+			typ: U64,
+		},
+		{
+			code: "#define FIELD_GET(m, v) (((v) & (m)) >> __builtin_ctz(m))\n" +
+				"FIELD_GET(0xf0, 0xab)",
+			res: 0xa,
+			typ: I32,
+		},
+		{
+			code: "#define SQ(x) ((x) * (x))\nSQ(SQ(2) + 1)",
+			res:  25,
+			typ:  I32,
+		},
+		{
+			code: "#define foo foo\nfoo = 3; foo",
+			res:  3,
+			typ:  I32,
+		},
+		{
+			code: "#define A B\n#define B A\nA = 1; A",
+			res:  1,
+			typ:  I32,
+		},
+		{
+			code: "enum { A = 1 << 0, B = 1 << 1, C = A | B }; C",
+			res:  3,
+			typ:  I32,
+		},
+		{
+			code: "enum color { RED, GREEN = 5, BLUE }\nRED + BLUE",
+			res:  6,
+			typ:  I32,
+		},
+		{
+			code: "static const uint16_t MASK = 0x1ff; MASK",
+			res:  0x1ff,
+			typ:  U16,
+		},
+		{
+			code: "const uint8_t b = 0x1ff; b",
+			res:  0xff,
+			typ:  U8,
+		},
+		{
+			code: "unsigned long long x; x",
+			res:  0,
+			typ:  U64,
+		},
+		{
+			code: "__builtin_popcountll(-1) + __builtin_clz(1)",
+			res:  95,
+			typ:  I32,
+		},
+		{
+			code: "__builtin_bswap16(0x11223344)",
+			res:  0x4433,
+			typ:  U16,
+		},
+	} {
+		interp := NewInterp()
+		got, err := exec(interp, tc.code)
+		if err != nil {
+			t.Fatalf("%s: %s", tc.code, err)
+		}
+
+		if got.Int64() != tc.res || got.Type != tc.typ {
+			t.Fatalf("%s: got(%s %s) != expected(%d %s)",
+				tc.code, got, got.Type, tc.res, tc.typ)
+		}
+	}
+}
+
+func TestInterpMacros(t *testing.T) {
+	interp := NewInterp()
+	res, err := interp.Exec("#define A 2\nenum { B = 1 }\nA + B")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res) != 1 {
+		t.Fatalf("definitions must have no result: %v", res)
+	}
+
+	// the macros are kept between executions
+	val, err := exec(interp, "A * 3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if val.Int64() != 6 {
+		t.Fatalf("got %s != expected 6", val)
+	}
+
+	if _, err := interp.Exec("#undef A"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := exec(interp, "A"); err == nil {
+		t.Fatal("expected error using an undefined macro")
+	}
+}
+
+func TestEvalSlices(t *testing.T) {
+	for _, tc := range []struct {
+		code    string
+		dialect Dialect
+		res     int64
+		typ     Type
+	}{
+		{code: "X = 0x12345; X[19:12]", dialect: LangC, res: 0x12, typ: U32},
+		{code: "X = 0x12345; X[2]", dialect: LangC, res: 1, typ: U32},
+		{code: "X = 0x12345; X[3]", dialect: LangC, res: 0, typ: U32},
+		{code: "X = -1; X[31:0]", dialect: LangC, res: 0xffffffff, typ: U32},
+		{code: "uint8_t(0x80)[7]", dialect: LangC, res: 1, typ: U8},
+		{code: "X = 0xff; X[7:4] = 0b1010; X", dialect: LangC, res: 0xaf, typ: I32},
+		{code: "X = 0; X[31] = 1; X", dialect: LangC, res: -0x80000000, typ: I32},
+		{code: "X := uint16(0); X[3:0] = 0xf; X[15:12] = X[3:0]; X", dialect: LangGo, res: 0xf00f, typ: U16},
+		{code: "x = 1 << 100; x[100]", dialect: LangPython, res: 1, typ: Unbounded},
+		{code: "x = 0xf0; x[7:4]", dialect: LangJS, res: 0xf, typ: Unbounded},
+		{code: "n = 4; X = 0xabcd; X[n+3:n]", dialect: LangC, res: 0xc, typ: U32},
+		{code: "fn field(x, hi, lo) = x[hi:lo]; field(0xabcd, 15, 8)", dialect: LangC, res: 0xab, typ: U32},
+	} {
+		interp := NewInterp(Lang(tc.dialect))
+		got, err := exec(interp, tc.code)
+		if err != nil {
+			t.Fatalf("%s: %s: %s", tc.dialect, tc.code, err)
+		}
+
+		if got.Int64() != tc.res || got.Type != tc.typ {
+			t.Fatalf("%s: %s: got(%s %s) != expected(%d %s)",
+				tc.dialect, tc.code, got, got.Type, tc.res, tc.typ)
+		}
+	}
+}
+
+func TestEvalSliceErrors(t *testing.T) {
+	for _, code := range []string{
+		"X = 1; X[32]",
+		"X = 1; X[3:4]",
+		"X = 1; X[-1]",
+		"uint8_t(1)[8:0]",
+		"X = 1; X[32] = 1",
+		"X = 0; X[3:0] = 0x10",
+		"X = 0; X[3:0] = -1",
+		"Y[3] = 1",
+	} {
+		interp := NewInterp()
+		_, err := exec(interp, code)
+		if err == nil {
+			t.Fatalf("expected error evaluating %q", code)
+		}
+	}
+}
+
+func TestEvalConcat(t *testing.T) {
+	for _, tc := range []struct {
+		code    string
+		dialect Dialect
+		res     int64
+		typ     Type
+	}{
+		{code: "{4'ha, 4'h5}", dialect: LangC, res: 0xa5, typ: Type{Bits: 8}},
+		{code: "{4{2'b10}}", dialect: LangC, res: 0xaa, typ: Type{Bits: 8}},
+		{code: "hi = uint8_t(0x12); lo = uint8_t(0x34); {hi, lo}", dialect: LangC, res: 0x1234, typ: U16},
+		{code: "{uint8_t(-1), 1'b0}", dialect: LangC, res: 0x1fe, typ: Type{Bits: 9}},
+		{code: "{1'b1, {2{3'b000, 1'b1}}}", dialect: LangC, res: 0x111, typ: Type{Bits: 9}},
+		{code: "{1'b1, 0u8}", dialect: LangRust, res: 0x100, typ: Type{Bits: 9}},
+		{code: "{2'b11, 6'd0} >> 6", dialect: LangC, res: 3, typ: I32},
+		{code: "{32{1'b1}} == 0xffffffff", dialect: LangC, res: 1, typ: I32},
+		{code: "n = 3; {n{2'b01}}", dialect: LangC, res: 0x15, typ: Type{Bits: 6}},
+		{code: "{u8(1), u8(2)}", dialect: LangPython, res: 0x102, typ: U16},
+	} {
+		interp := NewInterp(Lang(tc.dialect))
+		got, err := exec(interp, tc.code)
+		if err != nil {
+			t.Fatalf("%s: %s: %s", tc.dialect, tc.code, err)
+		}
+
+		if got.Int64() != tc.res || got.Type != tc.typ {
+			t.Fatalf("%s: %s: got(%s %s) != expected(%d %s)",
+				tc.dialect, tc.code, got, got.Type, tc.res, tc.typ)
+		}
+	}
+}
+
+func TestEvalConcatErrors(t *testing.T) {
+	for _, tc := range []struct {
+		code    string
+		dialect Dialect
+	}{
+		{code: "{1, 2}", dialect: LangC},
+		{code: "{0xff, 4'h0}", dialect: LangC},
+		{code: "x = 1; {x, y}", dialect: LangC},
+		{code: "{0{1'b1}}", dialect: LangC},
+		{code: "{-1{1'b1}}", dialect: LangC},
+		{code: "x = 1; {x, 1'b0}", dialect: LangPython},
+		{code: "x = 1; {x, 1'b0}", dialect: LangJS},
+	} {
+		interp := NewInterp(Lang(tc.dialect))
+		_, err := exec(interp, tc.code)
+		if err == nil {
+			t.Fatalf("%s: expected error evaluating %q", tc.dialect, tc.code)
+		}
+	}
+}
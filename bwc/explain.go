@@ -0,0 +1,139 @@
+package bwc
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Explain executes the code, as Exec does, and returns how the
+// code was read: every statement with all the operations in
+// parenthesis, followed by its sub-expressions, indented under
+// their parents, and their values:
+//
+//	(1 | (2 & 3)) = 3 (0x3, i32)
+//	  1 = 1 (0x1, i32)
+//	  (2 & 3) = 2 (0x2, i32)
+//	    2 = 2 (0x2, i32)
+//	    3 = 3 (0x3, i32)
+//
+// The values are the ones of the execution, the expressions
+// not evaluated, like the branch not taken of a conditional,
+// are marked as such. The expressions evaluated many times,
+// in loops, have the value of the first time. The explanation
+// of the statements executed before an error is returned with
+// the error.
+func (e *interp) Explain(code string) (string, error) {
+	n, err := parse(code, e.cfg, e.macros)
+	if err != nil {
+		return "", err
+	}
+
+	f := formatter{
+		dialect:  e.cfg.dialect,
+		prec:     e.cfg.precedence(),
+		explicit: true,
+	}
+
+	var b strings.Builder
+	for _, stmt := range statements(n) {
+		t := &trace{evals: map[traceKey][]*evaluation{}}
+		e.trace = t
+		_, err := e.Eval(stmt)
+		e.trace = nil
+
+		t.explain(&b, f, stmt, "")
+		if err != nil {
+			return b.String(), err
+		}
+	}
+	return b.String(), nil
+}
+
+// trace records the evaluations of the nodes. The nodes are
+// identified by their type and span, the ones with the same
+// key, like the nodes of a macro expansion, are kept in the
+// order they were evaluated.
+type trace struct {
+	evals map[traceKey][]*evaluation
+}
+
+type traceKey struct {
+	typ  Nodetype
+	span Span
+}
+
+// evaluation is the result of evaluating a node.
+type evaluation struct {
+	val Value
+	err error
+}
+
+// enter records the evaluation of n, its result is set when
+// the evaluation ends.
+func (t *trace) enter(n Node) *evaluation {
+	key := traceKey{n.Type(), n.Loc()}
+	ev := &evaluation{}
+	t.evals[key] = append(t.evals[key], ev)
+	return ev
+}
+
+// next returns the evaluation of n, in the order they were
+// made, and false if n was not evaluated.
+func (t *trace) next(n Node) (*evaluation, bool) {
+	key := traceKey{n.Type(), n.Loc()}
+	evals := t.evals[key]
+	if len(evals) == 0 {
+		return nil, false
+	}
+	t.evals[key] = evals[1:]
+	return evals[0], true
+}
+
+// explain writes the node n and its sub-expressions, with the
+// values they had when evaluated.
+func (t *trace) explain(b *strings.Builder, f formatter, n Node, indent string) {
+	b.WriteString(indent)
+	b.WriteString(f.format(n))
+
+	ev, evaluated := t.next(n)
+	switch {
+	case !evaluated:
+		b.WriteString(" not evaluated\n")
+		return
+	case isStmt(n):
+		// statements have no value
+	case ev.err != nil:
+		fmt.Fprintf(b, " = error: %s", ev.err)
+	default:
+		fmt.Fprintf(b, " = %s (%s, %s)", ev.val, hex(ev.val), ev.val.Type)
+	}
+	b.WriteString("\n")
+
+	if isDecl(n) {
+		// the bodies of functions are not evaluated by
+		// their definition.
+		return
+	}
+	for _, child := range children(n) {
+		t.explain(b, f, child, indent+"  ")
+	}
+}
+
+// isStmt tells if n is a statement, they have no value.
+func isStmt(n Node) bool {
+	switch n.Type() {
+	case NodeAssign, NodeSliceAssign, NodeFuncDecl, NodeMacroDecl,
+		NodeEnum, NodeStmtList, NodeReturn, NodeIf, NodeFor:
+		return true
+	}
+	return false
+}
+
+// hex returns the value in hexadecimal, with the 0x prefix.
+func hex(v Value) string {
+	text := v.Text(16)
+	if strings.HasPrefix(text, "-") {
+		return "-0x" + text[1:]
+	}
+	return "0x" + text
+}
@@ -0,0 +1,109 @@
+package bwc
+
+import (
+	"testing"
+)
+
+func TestExplain(t *testing.T) {
+	for _, tc := range []struct {
+		defs    string // executed before the code explained
+		code    string
+		dialect Dialect
+		out     string
+		err     string
+	}{
+		{
+			code: "1 | 2 & 3",
+			out: "(1 | (2 & 3)) = 3 (0x3, i32)\n" +
+				"  1 = 1 (0x1, i32)\n" +
+				"  (2 & 3) = 2 (0x2, i32)\n" +
+				"    2 = 2 (0x2, i32)\n" +
+				"    3 = 3 (0x3, i32)\n",
+		},
+		{
+			code: "x = 0xf0; ~x[7:4]",
+			out: "x = 0xf0\n" +
+				"  0xf0 = 240 (0xf0, i32)\n" +
+				"(~(x[7:4])) = 4294967280 (0xfffffff0, u32)\n" +
+				"  (x[7:4]) = 15 (0xf, u32)\n" +
+				"    x = 240 (0xf0, i32)\n" +
+				"    7 = 7 (0x7, i32)\n" +
+				"    4 = 4 (0x4, i32)\n",
+		},
+		{
+			code:    "not -a == b",
+			dialect: LangPython,
+			out: "(not ((-a) == b)) = error: undefined variable a\n" +
+				"  ((-a) == b) = error: undefined variable a\n" +
+				"    (-a) = error: undefined variable a\n" +
+				"      a = error: undefined variable a\n" +
+				"    b not evaluated\n",
+			err: "undefined variable a",
+		},
+		{
+			code:    "-5 // 2 if 1 else 0",
+			dialect: LangPython,
+			out: "(((-5) // 2) if 1 else 0) = -3 (-0x3, unbounded)\n" +
+				"  1 = 1 (0x1, unbounded)\n" +
+				"  ((-5) // 2) = -3 (-0x3, unbounded)\n" +
+				"    (-5) = -5 (-0x5, unbounded)\n" +
+				"      5 = 5 (0x5, unbounded)\n" +
+				"    2 = 2 (0x2, unbounded)\n" +
+				"  0 not evaluated\n",
+		},
+		{
+			code: "x = 0; x ? 1/x : 0",
+			out: "x = 0\n" +
+				"  0 = 0 (0x0, i32)\n" +
+				"(x ? (1 / x) : 0) = 0 (0x0, i32)\n" +
+				"  x = 0 (0x0, i32)\n" +
+				"  (1 / x) not evaluated\n" +
+				"  0 = 0 (0x0, i32)\n",
+		},
+		{
+			code: "0 && 1/0 || 2",
+			out: "((0 && (1 / 0)) || 2) = 1 (0x1, i32)\n" +
+				"  (0 && (1 / 0)) = 0 (0x0, i32)\n" +
+				"    0 = 0 (0x0, i32)\n" +
+				"    (1 / 0) not evaluated\n" +
+				"  2 = 2 (0x2, i32)\n",
+		},
+		{
+			code: "fn f(a) = a << 1; f(2) + 1/0",
+			out: "fn f(a) = (a << 1)\n" +
+				"(f(2) + (1 / 0)) = error: division by zero: 1 / 0\n" +
+				"  f(2) = 4 (0x4, i32)\n" +
+				"    2 = 2 (0x2, i32)\n" +
+				"  (1 / 0) = error: division by zero: 1 / 0\n" +
+				"    1 = 1 (0x1, i32)\n" +
+				"    0 = 0 (0x0, i32)\n",
+			err: "division by zero: 1 / 0",
+		},
+		{
+			// the body of f has the same spans of 2 and 3
+			defs: "fn f(x) = x + 1",
+			code: "f(9) + (  2 + 3)",
+			out: "(f(9) + (2 + 3)) = 15 (0xf, i32)\n" +
+				"  f(9) = 10 (0xa, i32)\n" +
+				"    9 = 9 (0x9, i32)\n" +
+				"  (2 + 3) = 5 (0x5, i32)\n" +
+				"    2 = 2 (0x2, i32)\n" +
+				"    3 = 3 (0x3, i32)\n",
+		},
+	} {
+		e := NewInterp(Lang(tc.dialect))
+		if _, err := e.Exec(tc.defs); tc.defs != "" && err != nil {
+			t.Fatalf("%q: %s", tc.defs, err)
+		}
+		got, err := e.Explain(tc.code)
+		if tc.err == "" && err != nil {
+			t.Fatalf("%q: %s", tc.code, err)
+		}
+		if tc.err != "" && (err == nil || err.Error() != tc.err) {
+			t.Fatalf("%q: expected error %q but got %v", tc.code, tc.err, err)
+		}
+		if got != tc.out {
+			t.Fatalf("%q: got:\n%s\nexpected:\n%s", tc.code, got, tc.out)
+		}
+	}
+}
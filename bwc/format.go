@@ -0,0 +1,441 @@
+package bwc
+
+import (
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+)
+
+// formatter prints nodes in the syntax of a dialect.
+type formatter struct {
+	dialect Dialect
+	prec    precedence
+
+	// explicit puts every operation in parenthesis, even
+	// the ones not needed by the precedence rules.
+	explicit bool
+}
+
+// primary is the level of the nodes that never need
+// parenthesis, like numbers and calls.
+const primary = 1 << 30
+
+// Format returns the code of n in the dialect of opts. The
+// parenthesis are only the ones needed by the precedence
+// rules of opts, so the code is parsed back to n, and the
+// integers keep the radix and suffixes of their literals.
+// The integers with a type not given by their literal, like
+// the constants folded by Simplify, are converted to it.
+// Statements are printed one per line and blocks are indented
+// with tabs. Comments and uses of macros are not in the AST,
+// so they are lost, Lossless tells if the code has them.
+func Format(n Node, opts ...Option) string {
+	c := newConfig(opts)
+	f := formatter{
+		dialect: c.dialect,
+		prec:    c.precedence(),
+	}
+	return f.format(n)
+}
+
+// Lossless tells if Format keeps all the code, once parsed
+// with opts. The code with comments or uses of the macros it
+// defines is formatted without the comments and with the
+// macros expanded.
+func Lossless(code string, opts ...Option) bool {
+	c := newConfig(opts)
+	n, err := parse(code, c, map[string]MacroDecl{})
+	if err != nil {
+		return false
+	}
+
+	macros := map[string]bool{}
+	Inspect(n, func(n Node) bool {
+		if m, ok := n.(MacroDecl); ok {
+			macros[m.Name] = true
+		}
+		return true
+	})
+
+	l := lex(code, c)
+	for tok := l.NextToken(); tok.Type != EOF; tok = l.NextToken() {
+		if tok.Type == Ident && macros[tok.Value] {
+			return false
+		}
+	}
+	return l.comments == 0
+}
+
+func (f formatter) format(n Node) string {
+	if f.explicit && compound(n) {
+		return "(" + f.node(n) + ")"
+	}
+	return f.node(n)
+}
+
+// compound tells if n is an operation on other expressions.
+func compound(n Node) bool {
+	switch n.Type() {
+	case NodeUnaryExpr, NodeBinExpr, NodeCondExpr, NodeSlice:
+		return true
+	}
+	return false
+}
+
+func (f formatter) node(n Node) string {
+	switch n := n.(type) {
+	case Int:
+		return f.integer(n)
+	case Var:
+		return n.Name
+	case UnaryExpr:
+		return f.unary(n)
+	case BinExpr:
+		return f.binary(n)
+	case CondExpr:
+		return f.cond(n)
+	case Call:
+		return fmt.Sprintf("%s(%s)", n.Name, f.list(n.Args))
+	case Cast:
+		return fmt.Sprintf("%s(%s)", f.typeName(n.To), f.format(n.Value))
+	case Slice:
+		val := f.format(n.Value)
+		if !f.explicit && compound(n.Value) && n.Value.Type() != NodeSlice {
+			// ~X[3] is ~(X[3])
+			val = "(" + val + ")"
+		}
+		return fmt.Sprintf("%s[%s]", val, f.sliceRange(n.Hi, n.Lo))
+	case Concat:
+		return "{" + f.list(n.Parts) + "}"
+	case Replicate:
+		return fmt.Sprintf("{%s%s}", f.format(n.Count), f.format(n.Value))
+	case Assign:
+		return f.assign(n)
+	case SliceAssign:
+		return fmt.Sprintf("%s[%s] = %s", n.Varname, f.sliceRange(n.Hi, n.Lo),
+			f.format(n.Expr))
+	case StmtList:
+		stmts := make([]string, len(n.Stmts))
+		for i, stmt := range n.Stmts {
+			stmts[i] = f.format(stmt)
+		}
+		return strings.Join(stmts, "\n")
+	case FuncDecl:
+		return f.funcDecl(n)
+	case Return:
+		return "return " + f.format(n.Value)
+	case If:
+		s := fmt.Sprintf("if %s %s", f.format(n.Cond), f.block(n.Then))
+		switch {
+		case n.Else == nil:
+		case n.Else.Type() == NodeIf:
+			s += " else " + f.format(n.Else)
+		default:
+			s += " else " + f.block(n.Else)
+		}
+		return s
+	case For:
+		return f.loop(n)
+	case MacroDecl:
+		return n.String()
+	case Enum:
+		return f.enum(n)
+	}
+	panic(fmt.Sprintf("invalid node: %T", n))
+}
+
+// level returns how tight n binds, nodes binding looser than
+// an operator need parenthesis to be its operands. The levels
+// are twice the precedences, so the Python not, which binds
+// looser than comparisons but tighter than and, fits between
+// them.
+func (f formatter) level(n Node) int {
+	switch n := n.(type) {
+	case BinExpr:
+		return 2 * f.prec[n.Op]
+	case CondExpr:
+		return 0
+	case UnaryExpr:
+		if f.pythonNot(n) {
+			return 2*f.prec[OpEQ] - 1
+		}
+	}
+	return primary
+}
+
+// pythonNot tells if n is the not of Python, a keyword that
+// binds looser than the comparisons.
+func (f formatter) pythonNot(n UnaryExpr) bool {
+	return f.dialect == LangPython && n.Op == OpLNOT
+}
+
+// paren formats n, with parenthesis if it binds looser than
+// the level.
+func (f formatter) paren(n Node, level int) string {
+	if !f.explicit && f.level(n) < level {
+		return "(" + f.format(n) + ")"
+	}
+	return f.format(n)
+}
+
+func (f formatter) unary(n UnaryExpr) string {
+	if f.pythonNot(n) {
+		if val, ok := n.Value.(UnaryExpr); ok && f.pythonNot(val) {
+			return "not " + f.format(val)
+		}
+		return "not " + f.paren(n.Value, 2*f.prec[OpEQ])
+	}
+
+	op := n.Op.String()
+	switch {
+	case n.Op == OpNOT && f.dialect == LangGo:
+		op = "^"
+	case n.Op == OpNOT && f.dialect == LangRust:
+		op = "!"
+	}
+
+	val := f.paren(n.Value, primary)
+	if strings.HasPrefix(val, op) {
+		// - -x, not --x
+		op += " "
+	}
+	return op + val
+}
+
+func (f formatter) binary(n BinExpr) string {
+	prec := 2 * f.prec[n.Op]
+
+	lhs := f.paren(n.Lhs, prec)
+	if !f.explicit && f.chains(n.Op, n.Lhs) {
+		lhs = "(" + f.format(n.Lhs) + ")"
+	}
+
+	// the operators are left associative, so the rhs needs
+	// parenthesis even at the same precedence.
+	rhs := f.paren(n.Rhs, prec+1)
+
+	op := n.Op.String()
+	if f.dialect == LangPython {
+		switch n.Op {
+		case OpLAND:
+			op = "and"
+		case OpLOR:
+			op = "or"
+		}
+	}
+	return fmt.Sprintf("%s %s %s", lhs, op, rhs)
+}
+
+// chains tells if the comparison lhs op rhs would be read as
+// a chain of comparisons, as in Python and Rust.
+func (f formatter) chains(op Optype, lhs Node) bool {
+	if f.dialect != LangPython && f.dialect != LangRust {
+		return false
+	}
+	bin, ok := lhs.(BinExpr)
+	return ok && isComparison(op) && isComparison(bin.Op)
+}
+
+func (f formatter) cond(n CondExpr) string {
+	if f.dialect == LangPython {
+		return fmt.Sprintf("%s if %s else %s", f.paren(n.Then, 1),
+			f.paren(n.Cond, 1), f.format(n.Else))
+	}
+	return fmt.Sprintf("%s ? %s : %s", f.paren(n.Cond, 1),
+		f.format(n.Then), f.format(n.Else))
+}
+
+func (f formatter) assign(n Assign) string {
+	op := "="
+	if n.Define {
+		op = ":="
+	} else if n.Op != 0 {
+		op = n.Op.String() + "="
+	}
+	return fmt.Sprintf("%s %s %s", n.Varname, op, f.format(n.Expr))
+}
+
+func (f formatter) list(nodes []Node) string {
+	items := make([]string, len(nodes))
+	for i, n := range nodes {
+		items[i] = f.format(n)
+	}
+	return strings.Join(items, ", ")
+}
+
+func (f formatter) sliceRange(hi, lo Node) string {
+	if lo == nil {
+		return f.format(hi)
+	}
+	return fmt.Sprintf("%s:%s", f.format(hi), f.format(lo))
+}
+
+// goNames are the names of the types in Go, int is the type
+// of the untyped integers.
+var goNames = map[Type]string{
+	U8:  "uint8",
+	U16: "uint16",
+	U32: "uint32",
+	U64: "uint64",
+	I8:  "int8",
+	I16: "int16",
+	I32: "int32",
+	I64: "int",
+}
+
+// typeName returns the name of t for casts and signatures.
+// The bwc names, like u32, work in every dialect but there is
+// no name for the unbounded integers, they use the names of
+// the dialect. Go uses its own names, so the Go functions are
+// valid Go code.
+func (f formatter) typeName(t Type) string {
+	if name, ok := goNames[t]; ok && f.dialect == LangGo {
+		return name
+	}
+	if t != Unbounded {
+		return t.String()
+	}
+
+	var names []string
+	for name, typ := range f.dialect.types() {
+		if typ == t {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return t.String()
+	}
+	sort.Strings(names)
+	return names[0]
+}
+
+// integer formats the literal of n. The integers made by
+// Simplify have the types of the values folded, they are
+// converted to them when the literal has another type.
+func (f formatter) integer(n Int) string {
+	lit := n.Lit
+	if lit == "" {
+		lit = new(big.Int).Abs(n.Val).String()
+	}
+	neg := n.Lit == "" && n.Val.Sign() < 0
+	if n.Typ == (Type{}) {
+		if neg {
+			return "-" + lit
+		}
+		return lit
+	}
+
+	cast := func(t Type, code string) string {
+		return fmt.Sprintf("%s(%s)", f.typeName(t), code)
+	}
+	i, err := parseInt(lit, f.dialect)
+	if err != nil {
+		return cast(n.Typ, n.Val.String())
+	}
+	typ := i.Typ
+	if typ == (Type{}) {
+		i.Lit = lit
+		typ = f.dialect.literalType(i)
+	}
+	if !typ.fits(i.Val) {
+		// the literal wraps, like the decimals of Java out
+		// of the range of int, the unsigned type keeps it.
+		typ = typ.Unsigned()
+		lit = cast(typ, lit)
+		if neg && typ != n.Typ {
+			typ, lit = n.Typ, cast(n.Typ, lit)
+		}
+	}
+	if neg {
+		// the negative numbers are negations of literals
+		lit = "-" + lit
+		typ = f.dialect.promote(typ)
+		if f.dialect == LangJS {
+			typ = Unbounded
+		}
+	}
+	if typ != n.Typ {
+		return cast(n.Typ, lit)
+	}
+	return lit
+}
+
+// block formats the statements of n between braces, with one
+// statement per line.
+func (f formatter) block(n Node) string {
+	if list, ok := n.(StmtList); ok && len(list.Stmts) == 0 {
+		return "{\n}"
+	}
+	return "{\n" + indent(f.format(n)) + "\n}"
+}
+
+// indent the lines of code with a tab.
+func indent(code string) string {
+	return "\t" + strings.ReplaceAll(code, "\n", "\n\t")
+}
+
+// funcDecl formats the functions in the bwc syntax, the
+// functions with typed signatures are Go functions, with the
+// Go names of the types.
+func (f formatter) funcDecl(n FuncDecl) string {
+	if n.Result != (Type{}) {
+		params := make([]string, len(n.Params))
+		for i, param := range n.Params {
+			params[i] = param + " " + f.typeName(n.Types[i])
+		}
+		return fmt.Sprintf("func %s(%s) %s %s", n.Name,
+			strings.Join(params, ", "), f.typeName(n.Result), f.block(n.Body))
+	}
+
+	head := fmt.Sprintf("fn %s(%s)", n.Name, strings.Join(n.Params, ", "))
+	switch n.Body.Type() {
+	case NodeStmtList, NodeReturn, NodeIf, NodeFor:
+		return head + " " + f.block(n.Body)
+	}
+	return fmt.Sprintf("%s = %s", head, f.format(n.Body))
+}
+
+func (f formatter) loop(n For) string {
+	var init, cond, post string
+	if n.Init != nil {
+		init = f.format(n.Init)
+	}
+	if n.Cond != nil {
+		cond = f.format(n.Cond)
+	}
+	if n.Post != nil {
+		post = f.format(n.Post)
+	}
+
+	switch {
+	case init == "" && post == "" && cond == "":
+		return "for " + f.block(n.Body)
+	case init == "" && post == "":
+		return fmt.Sprintf("for %s %s", cond, f.block(n.Body))
+	}
+	return fmt.Sprintf("for %s; %s; %s %s", init, cond, post, f.block(n.Body))
+}
+
+// enum formats the C enums. The constants are converted to
+// int by the parser, and the ones without value are the
+// previous plus an integer made by the parser, with no
+// literal.
+func (f formatter) enum(n Enum) string {
+	consts := make([]string, len(n.Consts))
+	for i, c := range n.Consts {
+		val := c.Expr
+		if cast, ok := val.(Cast); ok {
+			val = cast.Value
+		}
+
+		consts[i] = c.Varname
+		if bin, ok := val.(BinExpr); ok && bin.Op == OpADD {
+			if one, ok := bin.Rhs.(Int); ok && one.Lit == "" {
+				continue
+			}
+		}
+		consts[i] += " = " + f.format(val)
+	}
+	return fmt.Sprintf("enum { %s }", strings.Join(consts, ", "))
+}
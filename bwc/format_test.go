@@ -0,0 +1,336 @@
+package bwc
+
+import (
+	"fmt"
+	goast "go/ast"
+	goparser "go/parser"
+	gotoken "go/token"
+	gotypes "go/types"
+	"math/big"
+	"math/rand"
+	"testing"
+)
+
+func TestFormat(t *testing.T) {
+	for _, tc := range []struct {
+		code    string
+		dialect Dialect
+		ltr     bool
+		out     string
+	}{
+		{code: "(1|2)&3", out: "(1 | 2) & 3"},
+		{code: "1|(2&3)", out: "1 | 2 & 3"},
+		{code: "(a-b)-c", out: "a - b - c"},
+		{code: "a-(b-c)", out: "a - (b - c)"},
+		{code: "0xFF & 0b1010 | 0o17 | 1ULL", out: "0xFF & 0b1010 | 0o17 | 1ULL"},
+		{code: "~(x>>2)", out: "~(x >> 2)"},
+		{code: "-(-x)", out: "- -x"},
+		{code: "~x[7:4]", out: "~x[7:4]"},
+		{code: "(~x)[3]", out: "(~x)[3]"},
+		{code: "(a+b)[3]", out: "(a + b)[3]"},
+		{code: "{4{1'b1}} | {x[3:0], 4'hf}", out: "{4{1'b1}} | {x[3:0], 4'hf}"},
+		{code: "(uint8_t)x + popcount(x,y)", out: "u8(x) + popcount(x, y)"},
+		{code: "(a ? b : c) ? d : e ? f : g", out: "(a ? b : c) ? d : e ? f : g"},
+		{code: "x = (a ? b : c) | 1", out: "x = (a ? b : c) | 1"},
+		{code: "x <<= 1;y=2", out: "x <<= 1\ny = 2"},
+		{code: "x[3:0] = 0xf", out: "x[3:0] = 0xf"},
+		{code: "fn f(a, b) = a|b", out: "fn f(a, b) = a | b"},
+		{code: "fn f(a) { x = a; x*2 }", out: "fn f(a) {\n\tx = a\n\tx * 2\n}"},
+		{code: "enum { A, B = 4, C }", out: "enum { A, B = 4, C }"},
+		{code: "static const uint32_t M = 0xff", out: "M = u32(0xff)"},
+		{code: "#define SQ(x) ((x)*(x))", out: "#define SQ(x) ((x)*(x))"},
+		{code: "x &^ y | z", dialect: LangGo, out: "x &^ y | z"},
+		{code: "(x | y) & z", dialect: LangGo, out: "(x | y) & z"},
+		{code: "^(x + 1)", dialect: LangGo, out: "^(x + 1)"},
+		{code: "x & m == m", dialect: LangRust, out: "x & m == m"},
+		{code: "(a < b) == c", dialect: LangRust, out: "(a < b) == c"},
+		{code: "!x", dialect: LangRust, out: "!x"},
+		{code: "(u8(x) | 1) & y", out: "(u8(x) | 1) & y"},
+		{code: "x >>> 1 >> 2", dialect: LangJava, out: "x >>> 1 >> 2"},
+		{code: "a < b < c", dialect: LangPython, out: "a < b and b < c"},
+		{code: "not (a and b)", dialect: LangPython, out: "not (a and b)"},
+		{code: "(not a) | b", dialect: LangPython, out: "(not a) | b"},
+		{code: "not a == b or c", dialect: LangPython, out: "not a == b or c"},
+		{code: "x if a else y if b else z", dialect: LangPython,
+			out: "x if a else y if b else z"},
+		{code: "int(x // 2)", dialect: LangPython, out: "int(x // 2)"},
+		{code: "a | b & c", ltr: true, out: "a | b & c"},
+		{code: "a | (b & c)", ltr: true, out: "a | (b & c)"},
+		{
+			code: "func f(x uint8) uint8 {\n\tif x > 0 { x-- } else if x == 0 { return 1 }\n" +
+				"for i := 0; i < 2; i++ { x |= x >> 1 }\n\treturn ^x\n}",
+			dialect: LangGo,
+			out: "func f(x uint8) uint8 {\n" +
+				"\tif x > 0 {\n\t\tx -= 1\n\t} else if x == 0 {\n\t\treturn 1\n\t}\n" +
+				"\tfor i := 0; i < 2; i += 1 {\n\t\tx |= x >> 1\n\t}\n" +
+				"\treturn ^x\n}",
+		},
+	} {
+		opts := []Option{Lang(tc.dialect)}
+		if tc.ltr {
+			opts = append(opts, LeftToRight())
+		}
+
+		n, err := Parse(tc.code, opts...)
+		if err != nil {
+			t.Fatalf("%q: %s", tc.code, err)
+		}
+		got := Format(n, opts...)
+		if got != tc.out {
+			t.Fatalf("%q: got:\n%s\nexpected:\n%s", tc.code, got, tc.out)
+		}
+
+		again, err := Parse(got, opts...)
+		if err != nil {
+			t.Fatalf("%q: formatted code %q: %s", tc.code, got, err)
+		}
+		if !EqualNodes(n, again) {
+			t.Fatalf("%q: formatted code %q is (%s) != (%s)", tc.code, got, again, n)
+		}
+	}
+}
+
+func TestFormatTypedInt(t *testing.T) {
+	for _, tc := range []struct {
+		val     int64
+		typ     Type
+		lit     string
+		dialect Dialect
+		out     string
+	}{
+		{val: 1, typ: I32, out: "1"},
+		{val: 1, typ: U64, out: "u64(1)"},
+		{val: 0xff, typ: U32, lit: "0xff", out: "u32(0xff)"},
+		{val: 0xffffffff, typ: U32, lit: "0xffffffff", out: "0xffffffff"},
+		{val: 3000000000, typ: U32, out: "u32(3000000000)"},
+		{val: -1, typ: I32, out: "-1"},
+		{val: -1, typ: I64, out: "i64(-1)"},
+		{val: -2147483648, typ: I32, out: "i32(-2147483648)"},
+		{val: 1, typ: I64, dialect: LangGo, out: "int(1)"},
+		{val: 1, typ: Unbounded, dialect: LangGo, out: "1"},
+		{val: -1, typ: I32, dialect: LangRust, out: "-1"},
+		{val: 3000000000, typ: I64, dialect: LangJava, out: "i64(u32(3000000000))"},
+		{val: -3000000000, typ: I64, dialect: LangJava, out: "-i64(u32(3000000000))"},
+	} {
+		n := Int{Val: big.NewInt(tc.val), Typ: tc.typ, Lit: tc.lit}
+		got := Format(n, Lang(tc.dialect))
+		if got != tc.out {
+			t.Fatalf("%s: %d (%s): got %q, expected %q", tc.dialect, tc.val, tc.typ,
+				got, tc.out)
+		}
+
+		val, err := NewInterp(Lang(tc.dialect)).Exec(got)
+		if err != nil {
+			t.Fatalf("%s: %q: %s", tc.dialect, got, err)
+		}
+		if val[0].Type != tc.typ || val[0].int().Cmp(n.Val) != 0 {
+			t.Fatalf("%s: %q = %s (%s), expected %d (%s)", tc.dialect, got,
+				val[0], val[0].Type, tc.val, tc.typ)
+		}
+	}
+}
+
+func TestLossless(t *testing.T) {
+	for _, tc := range []struct {
+		code     string
+		dialect  Dialect
+		lossless bool
+	}{
+		{code: "x = 1\ny = x | 2", lossless: true},
+		{code: "x = 1 // one", lossless: false},
+		{code: "x = /* one */ 1", lossless: false},
+		{code: "#define M 3\ny = 1 | 2", lossless: true},
+		{code: "#define M 3\ny = M | 2", lossless: false},
+		{code: "x = 1  # one", dialect: LangPython, lossless: false},
+		{code: "x = 1 // 2", dialect: LangPython, lossless: true},
+		{code: "func f(x int) int {\n\t// twice\n\treturn x << 1\n}", dialect: LangGo,
+			lossless: false},
+		{code: "x = (1", lossless: false},
+	} {
+		if got := Lossless(tc.code, Lang(tc.dialect)); got != tc.lossless {
+			t.Fatalf("%s: %q: got %t, expected %t", tc.dialect, tc.code, got, tc.lossless)
+		}
+	}
+}
+
+// nodegen generates random nodes valid in a dialect.
+type nodegen struct {
+	rnd     *rand.Rand
+	dialect Dialect
+	prec    precedence
+	binops  []Optype
+	unops   []Optype
+}
+
+func newNodegen(seed int64, d Dialect, opts ...Option) *nodegen {
+	c := newConfig(append([]Option{Lang(d)}, opts...))
+	g := &nodegen{
+		rnd:     rand.New(rand.NewSource(seed)),
+		dialect: d,
+		prec:    c.precedence(),
+	}
+	for op := binaryOPbegin + 1; op < binaryOPend; op++ {
+		if _, ok := g.prec[op]; ok {
+			g.binops = append(g.binops, op)
+		}
+	}
+
+	g.unops = []Optype{OpNOT, OpNEG, OpPOS}
+	if d != LangRust {
+		// the ! of rust is the bitwise not
+		g.unops = append(g.unops, OpLNOT)
+	}
+	return g
+}
+
+func (g *nodegen) expr(depth int) Node {
+	if depth == 0 {
+		return g.leaf()
+	}
+
+	switch g.rnd.Intn(10) {
+	case 0:
+		return g.leaf()
+	case 1:
+		return UnaryExpr{
+			Op:    g.unops[g.rnd.Intn(len(g.unops))],
+			Value: g.expr(depth - 1),
+		}
+	case 2:
+		if g.dialect.ternary() || g.dialect == LangPython {
+			return CondExpr{
+				Cond: g.expr(depth - 1),
+				Then: g.expr(depth - 1),
+				Else: g.expr(depth - 1),
+			}
+		}
+	case 3:
+		slice := Slice{
+			Value: g.expr(depth - 1),
+			Hi:    g.expr(depth - 1),
+		}
+		if g.rnd.Intn(2) == 0 {
+			slice.Lo = g.expr(depth - 1)
+		}
+		return slice
+	case 4:
+		return Call{
+			Name: "f",
+			Args: []Node{g.expr(depth - 1), g.expr(depth - 1)},
+		}
+	case 5:
+		return Cast{
+			To:    []Type{U8, I32, U64}[g.rnd.Intn(3)],
+			Value: g.expr(depth - 1),
+		}
+	case 6:
+		concat := Concat{
+			Parts: []Node{g.expr(depth - 1), g.expr(depth - 1)},
+		}
+		if g.rnd.Intn(3) == 0 {
+			return Replicate{
+				Count: g.leaf(),
+				Value: concat,
+			}
+		}
+		return concat
+	}
+
+	return BinExpr{
+		Op:  g.binops[g.rnd.Intn(len(g.binops))],
+		Lhs: g.expr(depth - 1),
+		Rhs: g.expr(depth - 1),
+	}
+}
+
+func (g *nodegen) leaf() Node {
+	if g.rnd.Intn(2) == 0 {
+		return Var{Name: []string{"a", "b", "x", "y"}[g.rnd.Intn(4)]}
+	}
+
+	val := g.rnd.Int63n(1 << 16)
+	lit := []string{"%d", "0x%x", "0b%b"}[g.rnd.Intn(3)]
+	return Int{
+		Val: big.NewInt(val),
+		Lit: fmt.Sprintf(lit, val),
+	}
+}
+
+func TestFormatRoundTrip(t *testing.T) {
+	dialects := []Dialect{LangC, LangGo, LangRust, LangJava, LangJS, LangPython}
+	for _, d := range dialects {
+		for _, ltr := range []bool{false, true} {
+			var opts []Option
+			if ltr {
+				opts = append(opts, LeftToRight())
+			}
+			g := newNodegen(int64(d), d, opts...)
+			opts = append(opts, Lang(d))
+
+			for i := 0; i < 500; i++ {
+				n := g.expr(5)
+				code := Format(n, opts...)
+
+				got, err := Parse(code, opts...)
+				if err != nil {
+					t.Fatalf("%s: parsing %q: %s", d, code, err)
+				}
+				if !EqualNodes(got, n) {
+					t.Fatalf("%s: %q is (%s) != (%s)", d, code, got, n)
+				}
+				if again := Format(got, opts...); again != code {
+					t.Fatalf("%s: %q formatted again is %q", d, code, again)
+				}
+			}
+		}
+	}
+}
+
+func TestFormatGoParser(t *testing.T) {
+	code := `func stripe(val uint32) uint64 {
+	X := uint64(val)
+	X = (X | (X << 16)) & 0x0000ffff0000ffff
+	X = (X | X<<1) & 0x5555555555555555
+	return X
+}
+
+func f(x uint8, n int) int {
+	for i := 0; i < n; i++ {
+		if x > 3 && x != 7 {
+			return int(x) % 3
+		} else if x == 1 {
+			x ^= 1
+		} else {
+			x++
+		}
+	}
+	var y int32 = 3
+	y &^= 1
+	return -int(y) + int(^x)
+}`
+
+	n, err := Parse(code, Lang(LangGo))
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := Format(n, Lang(LangGo))
+
+	// the types are checked too, as any name is a type for
+	// the parser
+	fset := gotoken.NewFileSet()
+	file, err := goparser.ParseFile(fset, "p.go", "package p\n\n"+out, 0)
+	if err == nil {
+		_, err = new(gotypes.Config).Check("p", fset, []*goast.File{file}, nil)
+	}
+	if err != nil {
+		t.Fatalf("invalid Go code:\n%s\n%s", out, err)
+	}
+
+	again, err := Parse(out, Lang(LangGo))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !EqualNodes(again, n) {
+		t.Fatalf("%q is (%s) != (%s)", out, again, n)
+	}
+}
@@ -0,0 +1,253 @@
+package bwc
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+type formulaOp int
+
+const (
+	formulaFalse formulaOp = iota
+	formulaTrue
+	formulaVar
+	formulaNot
+	formulaAnd
+	formulaOr
+	formulaXor
+)
+
+// Formula is a boolean formula on the bits of variables, like
+// X[3] ^ Y[0]. The formulas are simplified when built, and
+// the equal formulas made by the same evaluation are the same
+// pointer.
+type Formula struct {
+	op   formulaOp
+	name string // name of the variable
+	bit  uint   // bit of the variable
+	args []*Formula
+	id   int
+}
+
+// Const returns the value of the formula, if it is constant.
+func (f *Formula) Const() (val bool, ok bool) {
+	switch f.op {
+	case formulaFalse:
+		return false, true
+	case formulaTrue:
+		return true, true
+	}
+	return false, false
+}
+
+// Var returns the variable and its bit, if the formula is
+// the bit of a variable.
+func (f *Formula) Var() (name string, bit uint, ok bool) {
+	return f.name, f.bit, f.op == formulaVar
+}
+
+func (f *Formula) String() string {
+	switch f.op {
+	case formulaFalse:
+		return "0"
+	case formulaTrue:
+		return "1"
+	case formulaVar:
+		return fmt.Sprintf("%s[%d]", f.name, f.bit)
+	case formulaNot:
+		arg := f.args[0].String()
+		if len(f.args[0].args) > 1 {
+			arg = "(" + arg + ")"
+		}
+		return "~" + arg
+	}
+
+	op := map[formulaOp]string{
+		formulaAnd: " & ",
+		formulaOr:  " | ",
+		formulaXor: " ^ ",
+	}[f.op]
+
+	args := make([]string, len(f.args))
+	for i, arg := range f.args {
+		args[i] = arg.String()
+		if len(arg.args) > 1 {
+			args[i] = "(" + args[i] + ")"
+		}
+	}
+	return strings.Join(args, op)
+}
+
+// falseFormula and trueFormula are the constants, they are
+// the same in all the tables.
+var (
+	falseFormula = &Formula{op: formulaFalse, id: 0}
+	trueFormula  = &Formula{op: formulaTrue, id: 1}
+)
+
+// constFormula returns the formula of the constant b.
+func constFormula(b bool) *Formula {
+	if b {
+		return trueFormula
+	}
+	return falseFormula
+}
+
+// formulas builds the formulas of an evaluation. The table
+// has all the formulas made, so the equal ones are reused.
+type formulas struct {
+	table map[string]*Formula
+}
+
+func newFormulas() *formulas {
+	f := &formulas{table: map[string]*Formula{}}
+	for _, c := range []*Formula{falseFormula, trueFormula} {
+		f.table[formulaKey(c.op, "", 0, nil)] = c
+	}
+	return f
+}
+
+// formulaKey identifies the formula in the table.
+func formulaKey(op formulaOp, name string, bit uint, args []*Formula) string {
+	var key strings.Builder
+	fmt.Fprintf(&key, "%d %s %d", op, name, bit)
+	for _, arg := range args {
+		fmt.Fprintf(&key, " %d", arg.id)
+	}
+	return key.String()
+}
+
+// make returns the formula, creating it if it is new.
+func (f *formulas) make(op formulaOp, name string, bit uint, args []*Formula) *Formula {
+	key := formulaKey(op, name, bit, args)
+	if formula, ok := f.table[key]; ok {
+		return formula
+	}
+	formula := &Formula{
+		op:   op,
+		name: name,
+		bit:  bit,
+		args: args,
+		id:   len(f.table),
+	}
+	f.table[key] = formula
+	return formula
+}
+
+func (f *formulas) variable(name string, bit uint) *Formula {
+	return f.make(formulaVar, name, bit, nil)
+}
+
+func (f *formulas) not(a *Formula) *Formula {
+	switch a.op {
+	case formulaFalse:
+		return trueFormula
+	case formulaTrue:
+		return falseFormula
+	case formulaNot:
+		return a.args[0]
+	}
+	return f.make(formulaNot, "", 0, []*Formula{a})
+}
+
+func (f *formulas) and(a, b *Formula) *Formula {
+	return f.nary(formulaAnd, a, b)
+}
+
+func (f *formulas) or(a, b *Formula) *Formula {
+	return f.nary(formulaOr, a, b)
+}
+
+func (f *formulas) xor(a, b *Formula) *Formula {
+	return f.nary(formulaXor, a, b)
+}
+
+// nary makes the and, or and xor of a and b. The operations
+// are associative, so the arguments of the same operation are
+// merged, and the ones repeated are removed: x & x is x and
+// x ^ x is false.
+func (f *formulas) nary(op formulaOp, a, b *Formula) *Formula {
+	// the identity and the absorbing constants of op
+	identity, absorbing := trueFormula, falseFormula
+	switch op {
+	case formulaOr:
+		identity, absorbing = falseFormula, trueFormula
+	case formulaXor:
+		identity, absorbing = falseFormula, nil
+	}
+
+	negated := false
+	var args []*Formula
+	for _, x := range []*Formula{a, b} {
+		if op == formulaXor {
+			// ~x ^ y is ~(x ^ y)
+			switch x.op {
+			case formulaTrue:
+				negated = !negated
+				continue
+			case formulaNot:
+				negated = !negated
+				x = x.args[0]
+			}
+		}
+
+		switch {
+		case x == absorbing:
+			return absorbing
+		case x == identity:
+		case x.op == op:
+			args = append(args, x.args...)
+		default:
+			args = append(args, x)
+		}
+	}
+
+	sort.Slice(args, func(i, j int) bool {
+		return args[i].id < args[j].id
+	})
+
+	var uniq []*Formula
+	for _, x := range args {
+		last := len(uniq) - 1
+		switch {
+		case last >= 0 && uniq[last] == x && op == formulaXor:
+			uniq = uniq[:last]
+		case last >= 0 && uniq[last] == x:
+		default:
+			uniq = append(uniq, x)
+		}
+	}
+
+	if op != formulaXor {
+		// x & ~x is false and x | ~x is true
+		for _, x := range uniq {
+			if x.op == formulaNot && contains(uniq, x.args[0]) {
+				return f.not(identity)
+			}
+		}
+	}
+
+	var ret *Formula
+	switch len(uniq) {
+	case 0:
+		ret = identity
+	case 1:
+		ret = uniq[0]
+	default:
+		ret = f.make(op, "", 0, uniq)
+	}
+	if negated {
+		return f.not(ret)
+	}
+	return ret
+}
+
+func contains(formulas []*Formula, x *Formula) bool {
+	for _, f := range formulas {
+		if f == x {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,575 @@
+package bwc
+
+import (
+	"fmt"
+	"go/ast"
+	goparser "go/parser"
+	"go/printer"
+	"go/scanner"
+	"go/token"
+	"strings"
+)
+
+// isGoSource tells if the code is Go source with function
+// declarations, like a pasted func or a whole file.
+func isGoSource(code string) bool {
+	code = strings.TrimSpace(code)
+	return strings.HasPrefix(code, "func ") ||
+		strings.HasPrefix(code, "package ")
+}
+
+// parseGo parses Go function declarations. The functions are
+// translated to bwc functions, so they can be called later.
+// Only the subset of Go used by bit tricks is supported:
+// integer types, :=, =, op=, ++, --, var, return, if and for.
+func parseGo(code string) (Node, error) {
+	src := code
+	if !strings.HasPrefix(strings.TrimSpace(code), "package ") {
+		src = "package bwc\n" + code
+	}
+
+	g := &goSource{
+		fset:   token.NewFileSet(),
+		lines:  newLineTable(code),
+		prefix: len(src) - len(code),
+	}
+
+	file, err := goparser.ParseFile(g.fset, "", src, 0)
+	if list, ok := err.(scanner.ErrorList); ok && len(list) > 0 {
+		errs := make(ErrorList, len(list))
+		for i, e := range list {
+			errs[i] = &ParseError{
+				Pos: e.Pos.Offset - g.prefix,
+				Msg: e.Msg,
+			}
+		}
+		return nil, errs
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var decls []Node
+	for _, decl := range file.Decls {
+		switch decl := decl.(type) {
+		case *ast.FuncDecl:
+			fn, err := g.goFuncDecl(decl)
+			if err != nil {
+				return nil, err
+			}
+			decls = append(decls, fn)
+		case *ast.GenDecl:
+			if decl.Tok != token.IMPORT {
+				return nil, g.errorf(decl, "only functions are supported")
+			}
+		}
+	}
+
+	switch len(decls) {
+	case 0:
+		return nil, fmt.Errorf("no functions found")
+	case 1:
+		return decls[0], nil
+	}
+	return stmtList(decls), nil
+}
+
+// goSource is the Go code being translated.
+type goSource struct {
+	fset  *token.FileSet
+	lines lineTable
+
+	// prefix is the size of the package clause added to
+	// the code, the positions are offsets in the code.
+	prefix int
+}
+
+// offset returns the offset of pos in the code.
+func (g *goSource) offset(pos token.Pos) int {
+	return g.fset.Position(pos).Offset - g.prefix
+}
+
+// span returns the span of the Go node n in the code.
+func (g *goSource) span(n ast.Node) Span {
+	return g.lines.span(g.offset(n.Pos()), g.offset(n.End()))
+}
+
+// errorf returns the error of translating the Go node n, at
+// its position.
+func (g *goSource) errorf(n ast.Node, format string, args ...interface{}) error {
+	return &ParseError{
+		Pos: g.offset(n.Pos()),
+		Msg: fmt.Sprintf(format, args...),
+	}
+}
+
+func (g *goSource) goFuncDecl(decl *ast.FuncDecl) (FuncDecl, error) {
+	fn := FuncDecl{
+		Name: decl.Name.Name,
+	}
+
+	if decl.Recv != nil {
+		return fn, g.errorf(decl.Recv, "methods are not supported")
+	}
+
+	for _, field := range decl.Type.Params.List {
+		typ, err := g.goType(field.Type)
+		if err != nil {
+			return fn, err
+		}
+		for _, name := range field.Names {
+			fn.Params = append(fn.Params, name.Name)
+			fn.Types = append(fn.Types, typ)
+		}
+	}
+
+	results := decl.Type.Results
+	if results == nil || len(results.List) != 1 || len(results.List[0].Names) > 0 {
+		var n ast.Node = decl.Type
+		if results != nil {
+			n = results
+		}
+		return fn, g.errorf(n, "function %s must have a single unnamed result",
+			fn.Name)
+	}
+
+	typ, err := g.goType(results.List[0].Type)
+	if err != nil {
+		return fn, err
+	}
+	fn.Result = typ
+
+	fn.Body, err = g.goBlock(decl.Body.List)
+	fn.Span = g.span(decl)
+	return fn, err
+}
+
+func (g *goSource) goType(expr ast.Expr) (Type, error) {
+	if id, ok := expr.(*ast.Ident); ok {
+		if typ, ok := LangGo.typeByName(id.Name); ok {
+			return typ, nil
+		}
+	}
+	return Type{}, g.errorf(expr, "unsupported type %s", goString(expr))
+}
+
+func (g *goSource) goBlock(stmts []ast.Stmt) (Node, error) {
+	list := StmtList{}
+	for _, stmt := range stmts {
+		n, err := g.goStmt(stmt)
+		if err != nil {
+			return nil, err
+		}
+		list.Stmts = append(list.Stmts, n)
+	}
+
+	switch len(list.Stmts) {
+	case 0:
+		return list, nil
+	case 1:
+		return list.Stmts[0], nil
+	}
+	return stmtList(list.Stmts), nil
+}
+
+// goAssignOps maps the Go assignment tokens to operations.
+var goAssignOps = map[token.Token]Optype{
+	token.ADD_ASSIGN:     OpADD,
+	token.SUB_ASSIGN:     OpSUB,
+	token.MUL_ASSIGN:     OpMUL,
+	token.QUO_ASSIGN:     OpDIV,
+	token.REM_ASSIGN:     OpMOD,
+	token.AND_ASSIGN:     OpAND,
+	token.OR_ASSIGN:      OpOR,
+	token.XOR_ASSIGN:     OpXOR,
+	token.SHL_ASSIGN:     OpSHL,
+	token.SHR_ASSIGN:     OpSHR,
+	token.AND_NOT_ASSIGN: OpANDNOT,
+}
+
+// goStmt translates the statement, with its span.
+func (g *goSource) goStmt(stmt ast.Stmt) (Node, error) {
+	n, err := g.goStmtNode(stmt)
+	if err != nil {
+		return nil, err
+	}
+	return withSpan(n, g.span(stmt)), nil
+}
+
+func (g *goSource) goStmtNode(stmt ast.Stmt) (Node, error) {
+	switch stmt := stmt.(type) {
+	case *ast.ExprStmt:
+		return g.goExpr(stmt.X)
+	case *ast.AssignStmt:
+		return g.goAssign(stmt)
+	case *ast.IncDecStmt:
+		id, ok := stmt.X.(*ast.Ident)
+		if !ok {
+			break
+		}
+		op := OpADD
+		if stmt.Tok == token.DEC {
+			op = OpSUB
+		}
+		return Assign{
+			Varname: id.Name,
+			Op:      op,
+			Expr:    NewInt(1),
+		}, nil
+	case *ast.DeclStmt:
+		return g.goVarDecl(stmt)
+	case *ast.ReturnStmt:
+		if len(stmt.Results) != 1 {
+			return nil, g.errorf(stmt, "return must have a single value")
+		}
+		val, err := g.goExpr(stmt.Results[0])
+		if err != nil {
+			return nil, err
+		}
+		return Return{
+			Value: val,
+		}, nil
+	case *ast.BlockStmt:
+		return g.goBlock(stmt.List)
+	case *ast.IfStmt:
+		return g.goIf(stmt)
+	case *ast.ForStmt:
+		return g.goFor(stmt)
+	}
+	return nil, g.errorf(stmt, "unsupported statement %s", goString(stmt))
+}
+
+func (g *goSource) goAssign(stmt *ast.AssignStmt) (Node, error) {
+	if len(stmt.Lhs) != 1 || len(stmt.Rhs) != 1 {
+		return nil, g.errorf(stmt, "multiple assignment not supported: %s",
+			goString(stmt))
+	}
+
+	id, ok := stmt.Lhs[0].(*ast.Ident)
+	if !ok {
+		return nil, g.errorf(stmt.Lhs[0], "unsupported assignment to %s",
+			goString(stmt.Lhs[0]))
+	}
+
+	expr, err := g.goExpr(stmt.Rhs[0])
+	if err != nil {
+		return nil, err
+	}
+
+	assign := Assign{
+		Varname: id.Name,
+		Expr:    expr,
+	}
+
+	switch stmt.Tok {
+	case token.ASSIGN:
+	case token.DEFINE:
+		assign.Define = true
+	default:
+		op, ok := goAssignOps[stmt.Tok]
+		if !ok {
+			return nil, g.errorf(stmt, "unsupported assignment %s", stmt.Tok)
+		}
+		assign.Op = op
+	}
+	return assign, nil
+}
+
+// goVarDecl translates var and const declarations to
+// definitions. Typed declarations convert the value to the
+// type, the zero value is used if missing.
+func (g *goSource) goVarDecl(stmt *ast.DeclStmt) (Node, error) {
+	decl := stmt.Decl.(*ast.GenDecl)
+	if decl.Tok != token.VAR && decl.Tok != token.CONST {
+		return nil, g.errorf(stmt, "unsupported declaration %s", goString(stmt))
+	}
+
+	list := StmtList{}
+	for _, spec := range decl.Specs {
+		spec := spec.(*ast.ValueSpec)
+		if len(spec.Values) > 1 {
+			return nil, g.errorf(spec, "multiple assignment not supported: %s",
+				goString(stmt))
+		}
+
+		var typ Type
+		if spec.Type != nil {
+			t, err := g.goType(spec.Type)
+			if err != nil {
+				return nil, err
+			}
+			typ = t
+		}
+
+		for _, name := range spec.Names {
+			var (
+				val Node = NewInt(0)
+				err error
+			)
+			if len(spec.Values) > 0 {
+				val, err = g.goExpr(spec.Values[0])
+				if err != nil {
+					return nil, err
+				}
+			}
+			if typ != (Type{}) {
+				val = Cast{
+					To:    typ,
+					Value: val,
+				}
+			}
+
+			list.Stmts = append(list.Stmts, Assign{
+				Varname: name.Name,
+				Expr:    val,
+				Define:  true,
+			})
+		}
+	}
+
+	if len(list.Stmts) == 1 {
+		return list.Stmts[0], nil
+	}
+	return list, nil
+}
+
+func (g *goSource) goIf(stmt *ast.IfStmt) (Node, error) {
+	cond, err := g.goExpr(stmt.Cond)
+	if err != nil {
+		return nil, err
+	}
+
+	then, err := g.goBlock(stmt.Body.List)
+	if err != nil {
+		return nil, err
+	}
+
+	n := If{
+		Cond: cond,
+		Then: then,
+	}
+	if stmt.Else != nil {
+		n.Else, err = g.goStmt(stmt.Else)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if stmt.Init == nil {
+		return n, nil
+	}
+
+	init, err := g.goStmt(stmt.Init)
+	if err != nil {
+		return nil, err
+	}
+	return StmtList{
+		Stmts: []Node{init, n},
+	}, nil
+}
+
+func (g *goSource) goFor(stmt *ast.ForStmt) (Node, error) {
+	var (
+		loop For
+		err  error
+	)
+
+	if stmt.Init != nil {
+		loop.Init, err = g.goStmt(stmt.Init)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if stmt.Cond != nil {
+		loop.Cond, err = g.goExpr(stmt.Cond)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if stmt.Post != nil {
+		loop.Post, err = g.goStmt(stmt.Post)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	loop.Body, err = g.goBlock(stmt.Body.List)
+	if err != nil {
+		return nil, err
+	}
+	return loop, nil
+}
+
+// goBinaryOps maps the Go operators to operations.
+var goBinaryOps = map[token.Token]Optype{
+	token.ADD:     OpADD,
+	token.SUB:     OpSUB,
+	token.MUL:     OpMUL,
+	token.QUO:     OpDIV,
+	token.REM:     OpMOD,
+	token.AND:     OpAND,
+	token.OR:      OpOR,
+	token.XOR:     OpXOR,
+	token.SHL:     OpSHL,
+	token.SHR:     OpSHR,
+	token.AND_NOT: OpANDNOT,
+	token.LAND:    OpLAND,
+	token.LOR:     OpLOR,
+	token.EQL:     OpEQ,
+	token.NEQ:     OpNEQ,
+	token.LSS:     OpLT,
+	token.LEQ:     OpLE,
+	token.GTR:     OpGT,
+	token.GEQ:     OpGE,
+}
+
+var goUnaryOps = map[token.Token]Optype{
+	token.XOR: OpNOT,
+	token.SUB: OpNEG,
+	token.ADD: OpPOS,
+	token.NOT: OpLNOT,
+}
+
+// goExpr translates the expression, with its span.
+func (g *goSource) goExpr(expr ast.Expr) (Node, error) {
+	n, err := g.goExprNode(expr)
+	if err != nil {
+		return nil, err
+	}
+	return withSpan(n, g.span(expr)), nil
+}
+
+func (g *goSource) goExprNode(expr ast.Expr) (Node, error) {
+	switch expr := expr.(type) {
+	case *ast.BasicLit:
+		if expr.Kind == token.INT {
+			n, err := parseInt(expr.Value, LangGo)
+			if err != nil {
+				return nil, g.errorf(expr, "%s", err)
+			}
+			n.Lit = expr.Value
+			return n, nil
+		}
+	case *ast.Ident:
+		return Var{Name: expr.Name}, nil
+	case *ast.ParenExpr:
+		return g.goExpr(expr.X)
+	case *ast.UnaryExpr:
+		op, ok := goUnaryOps[expr.Op]
+		if !ok {
+			break
+		}
+		val, err := g.goExpr(expr.X)
+		if err != nil {
+			return nil, err
+		}
+		return UnaryExpr{
+			Op:    op,
+			Value: val,
+		}, nil
+	case *ast.BinaryExpr:
+		op, ok := goBinaryOps[expr.Op]
+		if !ok {
+			break
+		}
+		lhs, err := g.goExpr(expr.X)
+		if err != nil {
+			return nil, err
+		}
+		rhs, err := g.goExpr(expr.Y)
+		if err != nil {
+			return nil, err
+		}
+		return BinExpr{
+			Op:  op,
+			Lhs: lhs,
+			Rhs: rhs,
+		}, nil
+	case *ast.CallExpr:
+		return g.goCall(expr)
+	}
+	return nil, g.errorf(expr, "unsupported expression %s", goString(expr))
+}
+
+// goBitsFuncs maps the math/bits functions to the builtins.
+// The functions with the width in the name, like OnesCount32,
+// convert the argument to the width, the others to uint.
+var goBitsFuncs = map[string]string{
+	"OnesCount":     "popcount",
+	"LeadingZeros":  "clz",
+	"TrailingZeros": "ctz",
+	"Len":           "bitlen",
+	"Reverse":       "bitrev",
+	"ReverseBytes":  "bswap",
+	"RotateLeft":    "rotl",
+}
+
+func (g *goSource) goCall(expr *ast.CallExpr) (Node, error) {
+	args := make([]Node, len(expr.Args))
+	for i, arg := range expr.Args {
+		n, err := g.goExpr(arg)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = n
+	}
+
+	switch fun := expr.Fun.(type) {
+	case *ast.Ident:
+		if typ, ok := LangGo.typeByName(fun.Name); ok {
+			// conversion, like uint64(val)
+			if len(args) != 1 {
+				return nil, g.errorf(expr, "conversion to %s needs one argument",
+					fun.Name)
+			}
+			return Cast{
+				To:    typ,
+				Value: args[0],
+			}, nil
+		}
+		return Call{
+			Name: fun.Name,
+			Args: args,
+		}, nil
+	case *ast.SelectorExpr:
+		if pkg, ok := fun.X.(*ast.Ident); ok && pkg.Name == "bits" {
+			return g.goBitsCall(expr, fun.Sel.Name, args)
+		}
+	}
+	return nil, g.errorf(expr, "unsupported call %s", goString(expr))
+}
+
+func (g *goSource) goBitsCall(expr *ast.CallExpr, name string, args []Node) (Node, error) {
+	typ := U64
+	for _, t := range []Type{U8, U16, U32, U64} {
+		suffix := fmt.Sprint(t.Bits)
+		if strings.HasSuffix(name, suffix) {
+			name = strings.TrimSuffix(name, suffix)
+			typ = t
+			break
+		}
+	}
+
+	builtin, ok := goBitsFuncs[name]
+	if !ok || len(args) == 0 {
+		return nil, g.errorf(expr, "unsupported function bits.%s", name)
+	}
+
+	args[0] = Cast{
+		To:    typ,
+		Value: args[0],
+	}
+	return Call{
+		Name: builtin,
+		Args: args,
+	}, nil
+}
+
+// goString returns the Go source of n, for errors.
+func goString(n ast.Node) string {
+	var b strings.Builder
+	if err := printer.Fprint(&b, token.NewFileSet(), n); err != nil {
+		return fmt.Sprintf("%T", n)
+	}
+	return b.String()
+}
@@ -0,0 +1,254 @@
+package bwc
+
+import (
+	"errors"
+	"testing"
+)
+
+const stripeSrc = `
+func stripe(val uint32) uint64 {
+	X := uint64(val)
+	X = (X | (X << 16)) & 0x0000ffff0000ffff
+	X = (X | (X << 8)) & 0x00ff00ff00ff00ff
+	X = (X | (X << 4)) & 0x0f0f0f0f0f0f0f0f
+	X = (X | (X << 2)) & 0x3333333333333333
+	X = (X | (X << 1)) & 0x5555555555555555
+	return X
+}
+`
+
+func TestGoFuncs(t *testing.T) {
+	for _, tc := range []struct {
+		src  string
+		code string
+		res  int64
+		typ  Type
+	}{
+		{
+			src:  stripeSrc,
+			code: "stripe(0xff)",
+			res:  0x5555,
+			typ:  U64,
+		},
+		{
+			src:  stripeSrc,
+			code: "stripe(0x0f) | stripe(0xf0) << 1",
+			res:  0xaa55,
+			typ:  U64,
+		},
+		{
+			src: `
+			func popcount(x uint64) int {
+				n := 0
+				for ; x != 0; x &= x - 1 {
+					n++
+				}
+				return n
+			}`,
+			code: "popcount(0xf0f0)",
+			res:  8,
+			typ:  I64,
+		},
+		{
+			src: `
+			func sign(x int32) int32 {
+				if x < 0 {
+					return -1
+				} else if x == 0 {
+					return 0
+				}
+				return 1
+			}`,
+			code: "sign(-5) + sign(0) * 10 + sign(7) * 100",
+			res:  99,
+			typ:  I32,
+		},
+		{
+			src: `
+			func rev(x uint8) uint8 {
+				var r uint8
+				for i := 0; i < 8; i++ {
+					r = r<<1 | x&1
+					x >>= 1
+				}
+				return r
+			}`,
+			code: "rev(0x0f)",
+			res:  0xf0,
+			typ:  U8,
+		},
+		{
+			src: `package main
+
+			import "math/bits"
+
+			func ones(x uint32) int {
+				return bits.OnesCount32(x)
+			}
+
+			func rot(x uint8) uint8 {
+				return bits.RotateLeft8(x, -1)
+			}`,
+			code: "ones(0xffff) + int(rot(1))",
+			res:  16 + 0x80,
+			typ:  I64,
+		},
+		{
+			src: `
+			func fact(n uint64) uint64 {
+				if n == 0 {
+					return 1
+				}
+				return n * fact(n-1)
+			}`,
+			code: "fact(20)",
+			res:  2432902008176640000,
+			typ:  U64,
+		},
+		{
+			src: `
+			func mask(n uint) uint64 {
+				const all = ^uint64(0)
+				return all >> (64 - n)
+			}`,
+			code: "mask(4)",
+			res:  0xf,
+			typ:  U64,
+		},
+	} {
+		interp := NewInterp(Lang(LangGo))
+		res, err := interp.Exec(tc.src)
+		if err != nil {
+			t.Fatalf("%s: %s", tc.src, err)
+		}
+		if len(res) != 0 {
+			t.Fatalf("definitions must have no result: %v", res)
+		}
+
+		got, err := exec(interp, tc.code)
+		if err != nil {
+			t.Fatalf("%s: %s", tc.code, err)
+		}
+		if got.Int64() != tc.res || got.Type != tc.typ {
+			t.Fatalf("%s: got(%s %s) != expected(%d %s)",
+				tc.code, got, got.Type, tc.res, tc.typ)
+		}
+	}
+}
+
+func TestGoFuncErrors(t *testing.T) {
+	for _, tc := range []struct {
+		src  string
+		code string
+	}{
+		{src: "func f(x uint8) uint8 { return x }", code: "f(256)"},
+		{src: "func f(x uint8) uint8 { return x }", code: "f(uint16(1))"},
+		{src: "func f(x uint8) uint8 { return x }", code: "f()"},
+		{src: "func f(x uint8) uint8 { x = uint16(1); return x }", code: "f(1)"},
+		{src: "func f(x uint8) uint8 { if x > 0 { return x } }", code: "f(0)"},
+		{src: "func f(x uint8) uint16 { return x }", code: "f(1)"},
+		{src: "func f(x uint8) uint8 { for { x++ } }", code: "f(1)"},
+		{src: "func f(x uint8) { }"},
+		{src: "func f(x float64) float64 { return x }"},
+		{src: "func f(x uint8) (a, b uint8) { return x, x }"},
+		{src: "func f(x uint8) uint8 { a, b := x, x; return a + b }"},
+		{src: "func f(x uint8) uint8 { switch x {}; return x }"},
+		{src: "func f(x uint8) uint8 { return x"},
+		{src: "func (t T) f(x uint8) uint8 { return x }"},
+		{src: "package main\nvar x = 1"},
+	} {
+		interp := NewInterp(Lang(LangGo))
+		_, err := interp.Exec(tc.src)
+		if err == nil && tc.code != "" {
+			_, err = exec(interp, tc.code)
+		}
+		if err == nil {
+			t.Fatalf("expected error evaluating %q: %q", tc.src, tc.code)
+		}
+	}
+}
+
+func TestGoTranslateErrors(t *testing.T) {
+	for _, tc := range []struct {
+		src string
+		pos int
+		msg string
+	}{
+		{
+			src: "func f(x float64) float64 { return x }",
+			pos: 9,
+			msg: "unsupported type float64",
+		},
+		{
+			src: "func f(x uint8) uint8 {\n\tswitch x {\n\t}\n\treturn x\n}",
+			pos: 25,
+			msg: "unsupported statement switch x {\n}",
+		},
+		{
+			src: "func f(x uint8) uint8 {\n\ta, b := x, x\n\treturn a + b\n}",
+			pos: 25,
+			msg: "multiple assignment not supported: a, b := x, x",
+		},
+		{
+			src: "func f(x uint8) uint8 {\n\treturn x + os.Getpid()\n}",
+			pos: 36,
+			msg: "unsupported call os.Getpid()",
+		},
+		{
+			src: "func f(x uint8) uint8 {\n\treturn x + bits.Add(x)\n}",
+			pos: 36,
+			msg: "unsupported function bits.Add",
+		},
+		{
+			src: "func f(x uint8) (a, b uint8) { return x, x }",
+			pos: 16,
+			msg: "function f must have a single unnamed result",
+		},
+		{
+			src: "func (t T) f(x uint8) uint8 { return x }",
+			pos: 5,
+			msg: "methods are not supported",
+		},
+		{
+			src: "package main\nvar x = 1",
+			pos: 13,
+			msg: "only functions are supported",
+		},
+	} {
+		_, err := Parse(tc.src, Lang(LangGo))
+
+		var perr *ParseError
+		if !errors.As(err, &perr) {
+			t.Fatalf("%q: expected a *ParseError but got %#v", tc.src, err)
+		}
+		if perr.Pos != tc.pos || perr.Msg != tc.msg {
+			t.Fatalf("%q: got %q at %d, expected %q at %d", tc.src, perr.Msg,
+				perr.Pos, tc.msg, tc.pos)
+		}
+	}
+}
+
+func TestEvalGoAssign(t *testing.T) {
+	interp := NewInterp(Lang(LangGo))
+	got, err := exec(interp, "x := uint8(1); x = 0xff; x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Int64() != 0xff || got.Type != U8 {
+		t.Fatalf("got(%s %s) != expected(255 u8)", got, got.Type)
+	}
+
+	for _, code := range []string{"x = 0x100", "x = uint16(1)"} {
+		if _, err := exec(interp, code); err == nil {
+			t.Fatalf("expected error evaluating %q", code)
+		}
+	}
+
+	got, err = exec(interp, "x := uint16(1); x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Type != U16 {
+		t.Fatalf(":= must redefine the variable but got %s", got.Type)
+	}
+}
@@ -9,11 +9,19 @@ import (
 
 type (
 	lexer struct {
-		input  string // expression being lex'ed
-		start  int    // start position of token
-		pos    int    // pos in the input
-		width  int    // width of last rune
-		tokens chan Tokval
+		input string // expression being lex'ed
+		start int    // start position of token
+		pos   int    // pos in the input
+		width int    // width of last rune
+
+		state   stateFn
+		pending []Tokval // tokens emitted but not read yet
+
+		last     Token // type of the last emitted token
+		parens   int   // depth of open parenthesis and brackets
+		comments int   // number of comments skipped
+
+		dialect Dialect
 	}
 
 	stateFn func(*lexer) stateFn
@@ -34,45 +42,120 @@ func (t Tokval) String() string {
 	return fmt.Sprintf("Token(%s, %s)", t.Type, t.Value)
 }
 
-// Lex creates a concurrent lexer and returns a
-// channel of tokens processed from input.
-func Lex(input string) <-chan Tokval {
-	l := &lexer{
-		input:  input,
-		tokens: make(chan Tokval),
+// Lex returns a channel of the tokens of input, it is closed
+// after the last token. It is kept for compatibility, the
+// tokens are lexed by NextToken before returning, so the
+// channel can be abandoned before reading all of them.
+// The opts select the dialect, which defines the comments.
+func Lex(input string, opts ...Option) <-chan Tokval {
+	var toks []Tokval
+	l := lex(input, newConfig(opts))
+	for tok := l.NextToken(); tok.Type != EOF; tok = l.NextToken() {
+		toks = append(toks, tok)
 	}
 
-	go l.run()
-	return l.tokens
+	tokens := make(chan Tokval, len(toks))
+	for _, tok := range toks {
+		tokens <- tok
+	}
+	close(tokens)
+	return tokens
 }
 
-// run the state machine
-func (l *lexer) run() {
-	for state := lexStart; state != nil; {
-		state = state(l)
+// NewLexer creates a lexer of input, the tokens are read with
+// NextToken. The opts select the dialect, which defines the
+// comments.
+func NewLexer(input string, opts ...Option) *lexer {
+	return lex(input, newConfig(opts))
+}
+
+func lex(input string, c *config) *lexer {
+	return &lexer{
+		input:   input,
+		state:   lexStart,
+		pending: make([]Tokval, 0, 2),
+		dialect: c.dialect,
 	}
-	close(l.tokens)
+}
+
+// NextToken returns the next token of the input. After the
+// last token it always returns EOF. The errors are Illegal
+// tokens and the lexing goes on after them, so all the errors
+// can be reported.
+func (l *lexer) NextToken() Tokval {
+	// run the state machine until it emits a token
+	for len(l.pending) == 0 {
+		if l.state == nil {
+			return Tokval{
+				Type: EOF,
+				Pos:  len(l.input),
+			}
+		}
+		l.state = l.state(l)
+	}
+
+	tok := l.pending[0]
+	l.pending = append(l.pending[:0], l.pending[1:]...)
+	return tok
+}
+
+// clone returns a copy of the lexer, reading the tokens of
+// the copy does not change l.
+func (l *lexer) clone() *lexer {
+	c := *l
+	c.pending = append([]Tokval(nil), l.pending...)
+	return &c
 }
 
 // emit a token.
 func (l *lexer) emit(tok Token) {
-	l.tokens <- Tokval{
+	l.pending = append(l.pending, Tokval{
 		Type:  tok,
 		Value: l.input[l.start:l.pos],
 		Pos:   l.start,
-	}
+	})
 	l.start = l.pos
+	l.last = tok
 }
 
-// errorf emits an illegal token. This token carries
-// the lexer error.
+// emitOp emits the operator tok or, if it is followed by =,
+// the compound assignment AssignOp.
+func (l *lexer) emitOp(tok Token) stateFn {
+	if l.peek() == '=' {
+		l.next()
+		tok = AssignOp
+	}
+	l.emit(tok)
+	return lexStart
+}
+
+// errorf emits an illegal token in place of the text lexed
+// since the start of the token. This token carries the lexer
+// error, the lexing goes on after it.
 func (l *lexer) errorf(msg string, args ...interface{}) stateFn {
-	l.tokens <- Tokval{
+	l.pending = append(l.pending, Tokval{
 		Type:  Illegal,
 		Value: fmt.Sprintf(msg, args...),
 		Pos:   l.start,
+	})
+	l.ignore()
+	return lexStart
+}
+
+// malformed emits the error of a malformed number, skipping
+// the rest of it.
+func (l *lexer) malformed(msg string, args ...interface{}) stateFn {
+	l.acceptRunfn(isAlphaNumeric)
+	return l.errorf(msg, args...)
+}
+
+// closeParen closes a parenthesis or bracket. Unbalanced
+// closings are ignored, so the newlines after them still end
+// the statements.
+func (l *lexer) closeParen() {
+	if l.parens > 0 {
+		l.parens--
 	}
-	return nil
 }
 
 // peek looks for the next rune from the input
@@ -149,91 +232,356 @@ func lexStart(l *lexer) stateFn {
 		})
 		l.emit(Ident)
 		return lexStart
+	case r == '\n':
+		// as in Go, newlines end the statement if the line
+		// could be a complete statement.
+		if l.parens == 0 && endsStmt(l.last) && !l.continues() {
+			l.emit(Semicolon)
+			return lexStart
+		}
+		l.ignore()
+		return lexStart
 	case unicode.IsSpace(r):
-		l.acceptRunfn(unicode.IsSpace)
+		l.acceptRunfn(isBlank)
 		l.ignore()
 		return lexStart
 	case r == eof:
 		return nil
-	case r >= '0' && r <= '9':
+	case r >= '0' && r <= '9', r == '\'':
 		l.backup()
 		return lexNumber
 	case r == '|':
-		l.emit(OR)
-		return lexStart
+		if l.peek() == '|' {
+			l.next()
+			l.emit(LOR)
+			return lexStart
+		}
+		return l.emitOp(OR)
 	case r == '&':
-		l.emit(AND)
-		return lexStart
+		switch l.peek() {
+		case '&':
+			l.next()
+			l.emit(LAND)
+			return lexStart
+		case '^':
+			l.next()
+			return l.emitOp(ANDNOT)
+		}
+		return l.emitOp(AND)
 	case r == '^':
-		l.emit(XOR)
-		return lexStart
+		return l.emitOp(XOR)
 	case r == '~':
 		l.emit(NOT)
 		return lexStart
+	case r == '!':
+		if l.peek() == '=' {
+			l.next()
+			l.emit(NEQ)
+			return lexStart
+		}
+		l.emit(BANG)
+		return lexStart
 	case r == '<':
-		next := l.next()
-		if next != '<' {
-			return l.errorf("unexpected %q", next)
+		switch l.peek() {
+		case '<':
+			l.next()
+			return l.emitOp(SHL)
+		case '=':
+			l.next()
+			l.emit(LE)
+			return lexStart
 		}
-		l.emit(SHL)
+		l.emit(LT)
 		return lexStart
 	case r == '>':
-		next := l.next()
-		if next != '>' {
-			return l.errorf("unexpected %q", next)
+		switch l.peek() {
+		case '>':
+			l.next()
+			if l.peek() == '>' {
+				l.next()
+				return l.emitOp(USHR)
+			}
+			return l.emitOp(SHR)
+		case '=':
+			l.next()
+			l.emit(GE)
+			return lexStart
 		}
-		l.emit(SHR)
+		l.emit(GT)
 		return lexStart
+	case r == '+':
+		return l.emitOp(PLUS)
+	case r == '-':
+		return l.emitOp(MINUS)
+	case r == '*':
+		return l.emitOp(MUL)
+	case r == '/':
+		if l.dialect.cComments() {
+			switch l.peek() {
+			case '/':
+				return lexLineComment
+			case '*':
+				return lexBlockComment
+			}
+		}
+		if l.peek() == '/' {
+			l.next()
+			return l.emitOp(FDIV)
+		}
+		return l.emitOp(DIV)
+	case r == '#' && l.dialect.hashComments():
+		return lexLineComment
+	case r == '#' && l.dialect.directives():
+		return lexDirective
+	case r == '%':
+		return l.emitOp(MOD)
 	case r == '(':
+		l.parens++
 		l.emit(LParen)
 		return lexStart
 	case r == ')':
+		l.closeParen()
 		l.emit(RParen)
 		return lexStart
 	case r == '=':
+		if l.peek() == '=' {
+			l.next()
+			l.emit(EQ)
+			return lexStart
+		}
 		l.emit(Equal)
 		return lexStart
+	case r == ':':
+		if l.peek() == '=' {
+			l.next()
+			l.emit(Define)
+			return lexStart
+		}
+		l.emit(COLON)
+		return lexStart
+	case r == '?':
+		l.emit(QUESTION)
+		return lexStart
+	case r == ';':
+		l.emit(Semicolon)
+		return lexStart
+	case r == ',':
+		l.emit(Comma)
+		return lexStart
+	case r == '[':
+		l.parens++
+		l.emit(LBracket)
+		return lexStart
+	case r == ']':
+		l.closeParen()
+		l.emit(RBracket)
+		return lexStart
+	case r == '{':
+		l.emit(LBrace)
+		return lexStart
+	case r == '}':
+		l.emit(RBrace)
+		return lexStart
 	default:
 		return l.errorf("Unexpected %q at %d", r, l.pos)
 	}
-	return nil
 }
 
-// lexer of dec, hex and bin numbers.
+// lexLineComment skips the comment until the end of line.
+// The newline is kept as it could end the statement.
+func lexLineComment(l *lexer) stateFn {
+	l.acceptRunfn(func(r rune) bool {
+		return r != '\n' && r != eof
+	})
+	l.ignore()
+	l.comments++
+	return lexStart
+}
+
+// lexDirective emits the preprocessor directive until the end
+// of line. Lines ending with \ continue in the next line.
+func lexDirective(l *lexer) stateFn {
+	for {
+		r := l.next()
+		if r == eof {
+			break
+		}
+		if r == '\n' && !strings.HasSuffix(l.input[:l.pos-1], "\\") {
+			l.backup()
+			break
+		}
+	}
+	l.emit(Directive)
+	return lexStart
+}
+
+// lexBlockComment skips /* comments */. As in Go, comments
+// with newlines act like a newline.
+func lexBlockComment(l *lexer) stateFn {
+	end := strings.Index(l.input[l.pos:], "*/")
+	if end < 0 {
+		// the comment takes the rest of the input
+		l.pos = len(l.input)
+		return l.errorf("comment not terminated")
+	}
+
+	comment := l.input[l.pos : l.pos+end]
+	l.pos += end + len("*/")
+	l.ignore()
+	l.comments++
+
+	if strings.Contains(comment, "\n") && l.parens == 0 &&
+		endsStmt(l.last) && !l.continues() {
+		l.pending = append(l.pending, Tokval{
+			Type:  Semicolon,
+			Value: "\n",
+			Pos:   l.start,
+		})
+		l.last = Semicolon
+	}
+	return lexStart
+}
+
+const (
+	decDigits = "0123456789_"
+	hexDigits = "0123456789abcdefABCDEF_"
+	octDigits = "01234567_"
+	binDigits = "01_"
+)
+
+// lexer of dec, hex, oct and bin numbers. The digits could be
+// separated by _ and followed by a C or Rust type suffix, like
+// 1ULL or 0xffu64. Verilog sized numbers, like 8'hff, are
+// also supported.
 func lexNumber(l *lexer) stateFn {
 	r := l.next()
-	next := l.peek()
-	if r == '0' && next == 'b' {
-		// 0bnnnnnnnn
-		l.next()
-		digits := "01"
-		if !l.accept(digits) {
-			return l.errorf("malformed binary number")
+	if r == '\'' {
+		return lexVerilogNumber
+	}
+
+	digits, kind := decDigits, ""
+	if r == '0' {
+		switch l.peek() {
+		case 'x', 'X':
+			digits, kind = hexDigits, "hex"
+		case 'b', 'B':
+			digits, kind = binDigits, "binary"
+		case 'o', 'O':
+			digits, kind = octDigits, "octal"
 		}
+	}
 
-		l.acceptRun(digits)
-	} else if r == '0' && next == 'x' {
+	if kind != "" {
 		// 0xnnnnnnnn
 		l.next()
-		digits := "0123456789abcdef"
 		if !l.accept(digits) {
-			return l.errorf("malformed hex number")
+			return l.malformed("malformed %s number", kind)
 		}
+	}
 
-		l.acceptRun(digits)
-	} else {
-		// decimal
-		l.acceptRun("0123456789")
+	l.acceptRun(digits)
+
+	if kind == "" && l.peek() == '\'' {
+		// 8'hff
+		l.next()
+		return lexVerilogNumber
+	}
+
+	suffix := l.pos
+	l.acceptRunfn(isAlphaNumeric)
+	if !isNumberSuffix(l.input[suffix:l.pos]) {
+		return l.malformed("malformed number")
 	}
 
+	l.emit(Number)
+	return lexStart
+}
+
+// lexVerilogNumber lexes the part after the ' of Verilog
+// numbers: an optional s (signed), the base and the digits.
+func lexVerilogNumber(l *lexer) stateFn {
+	if l.accept("sS") {
+		l.next()
+	}
+
+	var digits string
+	switch l.next() {
+	case 'h', 'H':
+		digits = hexDigits
+	case 'd', 'D':
+		digits = decDigits
+	case 'o', 'O':
+		digits = octDigits
+	case 'b', 'B':
+		digits = binDigits
+	default:
+		return l.malformed("malformed verilog number")
+	}
+
+	if !l.accept(digits) {
+		return l.malformed("malformed verilog number")
+	}
+
+	l.acceptRun(digits)
 	if isAlphaNumeric(l.peek()) {
-		return l.errorf("malformed number")
+		return l.malformed("malformed number")
 	}
 
 	l.emit(Number)
 	return lexStart
 }
 
+// isBlank tells if r is a space but not a newline.
+func isBlank(r rune) bool {
+	return r != '\n' && unicode.IsSpace(r)
+}
+
+// continuation are the binary operators continuing the
+// statement of the previous line, like the | of
+//
+//	x = a
+//	  | b
+//
+// The + and - are not, they could start the next statement.
+var continuation = []string{
+	"|", "&", "^", "<", ">", "=", "!=", "*", "%", "?", ":",
+}
+
+// continues tells if the next line continues the statement,
+// starting with a binary operator. Only in the languages
+// where the newlines do not end the statements, not in Go and
+// Python.
+func (l *lexer) continues() bool {
+	switch l.dialect {
+	case LangGo, LangPython:
+		return false
+	}
+	if l.last == Directive {
+		return false
+	}
+
+	next := strings.TrimLeft(l.input[l.pos:], " \t\r\n")
+	if strings.HasPrefix(next, "/") {
+		// a division, not a comment
+		return !strings.HasPrefix(next, "//") && !strings.HasPrefix(next, "/*")
+	}
+	for _, op := range continuation {
+		if strings.HasPrefix(next, op) {
+			return true
+		}
+	}
+	return false
+}
+
+// endsStmt tells if a statement could end with tok.
+func endsStmt(tok Token) bool {
+	switch tok {
+	case Ident, Number, RParen, RBracket, RBrace, Directive:
+		return true
+	}
+	return false
+}
+
 func isAlphaNumeric(r rune) bool {
 	return unicode.IsLetter(r) || unicode.IsDigit(r)
 }
@@ -1,14 +1,18 @@
 package bwc_test
 
 import (
+	"reflect"
+	"runtime"
 	"testing"
+	"time"
 
 	"github.com/madlambda/bwc/bwc"
 )
 
 type testcase struct {
-	in  string
-	out []bwc.Tokval
+	in   string
+	out  []bwc.Tokval
+	opts []bwc.Option
 }
 
 func consume(tokens <-chan bwc.Tokval) []bwc.Tokval {
@@ -19,9 +23,26 @@ func consume(tokens <-chan bwc.Tokval) []bwc.Tokval {
 	return toks
 }
 
+// lexAll reads the tokens of the lexer up to EOF.
+func lexAll(in string, opts ...bwc.Option) []bwc.Tokval {
+	var toks []bwc.Tokval
+	l := bwc.NewLexer(in, opts...)
+	for tok := l.NextToken(); tok.Type != bwc.EOF; tok = l.NextToken() {
+		toks = append(toks, tok)
+	}
+	return toks
+}
+
 func test(t *testing.T, tc testcase) {
 	t.Helper()
-	got := consume(bwc.Lex(tc.in))
+	got := lexAll(tc.in, tc.opts...)
+
+	// Lex must give the same tokens
+	compat := consume(bwc.Lex(tc.in, tc.opts...))
+	if !reflect.DeepEqual(got, compat) {
+		t.Fatalf("%q: Lex got %v != NextToken got %v", tc.in, compat, got)
+	}
+
 	if len(got) != len(tc.out) {
 		t.Logf("test data: %v", tc.in)
 		t.Logf("got: %v", got)
@@ -38,6 +59,10 @@ func test(t *testing.T, tc testcase) {
 		if e.Value != g.Value {
 			t.Fatalf("tok differs: %s != %s", e.Value, g.Value)
 		}
+		if e.Pos != 0 && e.Pos != g.Pos {
+			t.Fatalf("tok %v position differs: %d != %d",
+				e, e.Pos, g.Pos)
+		}
 	}
 }
 
@@ -237,6 +262,47 @@ func TestLexer(t *testing.T) {
 					Type:  bwc.Illegal,
 					Value: "malformed number",
 				},
+				{
+					Type:  bwc.Equal,
+					Value: "=",
+				},
+				{
+					Type:  bwc.Number,
+					Value: "0b10000",
+				},
+			},
+		},
+		{
+			in: "0xg1 | 8'q1 @ 2 /* a",
+			out: []bwc.Tokval{
+				{Type: bwc.Illegal, Value: "malformed hex number", Pos: 0},
+				{Type: bwc.OR, Value: "|", Pos: 5},
+				{Type: bwc.Illegal, Value: "malformed verilog number", Pos: 7},
+				{Type: bwc.Illegal, Value: "Unexpected '@' at 13", Pos: 12},
+				{Type: bwc.Number, Value: "2", Pos: 14},
+				{Type: bwc.Illegal, Value: "comment not terminated", Pos: 16},
+			},
+		},
+		{
+			in: "1 @ 2",
+			out: []bwc.Tokval{
+				{Type: bwc.Number, Value: "1"},
+				{
+					Type:  bwc.Illegal,
+					Value: "Unexpected '@' at 3",
+				},
+				{Type: bwc.Number, Value: "2"},
+			},
+		},
+		{
+			in: "rotl(x, 3)",
+			out: []bwc.Tokval{
+				{Type: bwc.Ident, Value: "rotl"},
+				{Type: bwc.LParen, Value: "("},
+				{Type: bwc.Ident, Value: "x"},
+				{Type: bwc.Comma, Value: ","},
+				{Type: bwc.Number, Value: "3"},
+				{Type: bwc.RParen, Value: ")"},
 			},
 		},
 	} {
@@ -315,3 +381,486 @@ func TestLexerNumbers(t *testing.T) {
 		test(t, tc)
 	}
 }
+
+func TestLexerDialectOperators(t *testing.T) {
+	for _, tc := range []testcase{
+		{
+			in: "a&^b",
+			out: []bwc.Tokval{
+				{
+					Type:  bwc.Ident,
+					Value: "a",
+				},
+				{
+					Type:  bwc.ANDNOT,
+					Value: "&^",
+				},
+				{
+					Type:  bwc.Ident,
+					Value: "b",
+				},
+			},
+		},
+		{
+			in: "a>>>1",
+			out: []bwc.Tokval{
+				{
+					Type:  bwc.Ident,
+					Value: "a",
+				},
+				{
+					Type:  bwc.USHR,
+					Value: ">>>",
+				},
+				{
+					Type:  bwc.Number,
+					Value: "1",
+				},
+			},
+		},
+		{
+			in: "!0",
+			out: []bwc.Tokval{
+				{
+					Type:  bwc.BANG,
+					Value: "!",
+				},
+				{
+					Type:  bwc.Number,
+					Value: "0",
+				},
+			},
+		},
+	} {
+		tc := tc
+		test(t, tc)
+	}
+}
+
+func TestLexerArith(t *testing.T) {
+	for _, tc := range []testcase{
+		{
+			in: "-1+2*3/4%5",
+			out: []bwc.Tokval{
+				{Type: bwc.MINUS, Value: "-"},
+				{Type: bwc.Number, Value: "1"},
+				{Type: bwc.PLUS, Value: "+"},
+				{Type: bwc.Number, Value: "2"},
+				{Type: bwc.MUL, Value: "*"},
+				{Type: bwc.Number, Value: "3"},
+				{Type: bwc.DIV, Value: "/"},
+				{Type: bwc.Number, Value: "4"},
+				{Type: bwc.MOD, Value: "%"},
+				{Type: bwc.Number, Value: "5"},
+			},
+		},
+		{
+			in:   "7//2",
+			opts: []bwc.Option{bwc.Lang(bwc.LangPython)},
+			out: []bwc.Tokval{
+				{Type: bwc.Number, Value: "7"},
+				{Type: bwc.FDIV, Value: "//"},
+				{Type: bwc.Number, Value: "2"},
+			},
+		},
+	} {
+		tc := tc
+		test(t, tc)
+	}
+}
+
+func TestLexerDirectives(t *testing.T) {
+	for _, tc := range []testcase{
+		{
+			in: "# define",
+			out: []bwc.Tokval{
+				{Type: bwc.Directive, Value: "# define"},
+			},
+		},
+		{
+			in: "#define A 1\nA",
+			out: []bwc.Tokval{
+				{Type: bwc.Directive, Value: "#define A 1", Pos: 0},
+				{Type: bwc.Semicolon, Value: "\n", Pos: 11},
+				{Type: bwc.Ident, Value: "A", Pos: 12},
+			},
+		},
+		{
+			in: "#define A \\\n 1\nA",
+			out: []bwc.Tokval{
+				{Type: bwc.Directive, Value: "#define A \\\n 1", Pos: 0},
+				{Type: bwc.Semicolon, Value: "\n", Pos: 14},
+				{Type: bwc.Ident, Value: "A", Pos: 15},
+			},
+		},
+		{
+			in: "#define A(x) ((x) & 1) // odd",
+			out: []bwc.Tokval{
+				{Type: bwc.Directive, Value: "#define A(x) ((x) & 1) // odd"},
+			},
+		},
+	} {
+		tc := tc
+		test(t, tc)
+	}
+}
+
+func TestLexerBrackets(t *testing.T) {
+	for _, tc := range []testcase{
+		{
+			in: "X[19:12]",
+			out: []bwc.Tokval{
+				{Type: bwc.Ident, Value: "X", Pos: 0},
+				{Type: bwc.LBracket, Value: "[", Pos: 1},
+				{Type: bwc.Number, Value: "19", Pos: 2},
+				{Type: bwc.COLON, Value: ":", Pos: 4},
+				{Type: bwc.Number, Value: "12", Pos: 5},
+				{Type: bwc.RBracket, Value: "]", Pos: 7},
+			},
+		},
+		{
+			// newlines inside brackets don't end the statement
+			in: "X[3\n]\n",
+			out: []bwc.Tokval{
+				{Type: bwc.Ident, Value: "X", Pos: 0},
+				{Type: bwc.LBracket, Value: "[", Pos: 1},
+				{Type: bwc.Number, Value: "3", Pos: 2},
+				{Type: bwc.RBracket, Value: "]", Pos: 4},
+				{Type: bwc.Semicolon, Value: "\n", Pos: 5},
+			},
+		},
+	} {
+		tc := tc
+		test(t, tc)
+	}
+}
+
+func TestLexerComparison(t *testing.T) {
+	for _, tc := range []testcase{
+		{
+			in: "a==b!=c<d<=e>f>=g",
+			out: []bwc.Tokval{
+				{Type: bwc.Ident, Value: "a"},
+				{Type: bwc.EQ, Value: "=="},
+				{Type: bwc.Ident, Value: "b"},
+				{Type: bwc.NEQ, Value: "!="},
+				{Type: bwc.Ident, Value: "c"},
+				{Type: bwc.LT, Value: "<"},
+				{Type: bwc.Ident, Value: "d"},
+				{Type: bwc.LE, Value: "<="},
+				{Type: bwc.Ident, Value: "e"},
+				{Type: bwc.GT, Value: ">"},
+				{Type: bwc.Ident, Value: "f"},
+				{Type: bwc.GE, Value: ">="},
+				{Type: bwc.Ident, Value: "g"},
+			},
+		},
+		{
+			in: "a<<=1>>=2",
+			out: []bwc.Tokval{
+				{Type: bwc.Ident, Value: "a"},
+				{Type: bwc.AssignOp, Value: "<<="},
+				{Type: bwc.Number, Value: "1"},
+				{Type: bwc.AssignOp, Value: ">>="},
+				{Type: bwc.Number, Value: "2"},
+			},
+		},
+		{
+			in: "a&&b||!c&d|e",
+			out: []bwc.Tokval{
+				{Type: bwc.Ident, Value: "a"},
+				{Type: bwc.LAND, Value: "&&"},
+				{Type: bwc.Ident, Value: "b"},
+				{Type: bwc.LOR, Value: "||"},
+				{Type: bwc.BANG, Value: "!"},
+				{Type: bwc.Ident, Value: "c"},
+				{Type: bwc.AND, Value: "&"},
+				{Type: bwc.Ident, Value: "d"},
+				{Type: bwc.OR, Value: "|"},
+				{Type: bwc.Ident, Value: "e"},
+			},
+		},
+		{
+			in: "c ? a : b",
+			out: []bwc.Tokval{
+				{Type: bwc.Ident, Value: "c", Pos: 0},
+				{Type: bwc.QUESTION, Value: "?", Pos: 2},
+				{Type: bwc.Ident, Value: "a", Pos: 4},
+				{Type: bwc.COLON, Value: ":", Pos: 6},
+				{Type: bwc.Ident, Value: "b", Pos: 8},
+			},
+		},
+	} {
+		tc := tc
+		test(t, tc)
+	}
+}
+
+func TestLexerNumberSyntax(t *testing.T) {
+	for _, in := range []string{
+		"0xFF",
+		"0XfF",
+		"0B1010",
+		"0o755",
+		"0O755",
+		"0755",
+		"1_000_000",
+		"0xffff_0000",
+		"0b_1010",
+		"1ULL",
+		"1ull",
+		"0xffu",
+		"10L",
+		"10lu",
+		"0xffu64",
+		"0xff_u8",
+		"1usize",
+		"123n",
+		"8'hFF",
+		"4'b1010",
+		"16'd65535",
+		"8'sb1000_0000",
+		"'hdead",
+		"3'o7",
+	} {
+		test(t, testcase{
+			in: in,
+			out: []bwc.Tokval{
+				{
+					Type:  bwc.Number,
+					Value: in,
+				},
+			},
+		})
+	}
+
+	for _, tc := range []testcase{
+		{
+			in: "0xFFg",
+			out: []bwc.Tokval{
+				{
+					Type:  bwc.Illegal,
+					Value: "malformed number",
+				},
+			},
+		},
+		{
+			in: "1lL",
+			out: []bwc.Tokval{
+				{
+					Type:  bwc.Illegal,
+					Value: "malformed number",
+				},
+			},
+		},
+		{
+			in: "0o8",
+			out: []bwc.Tokval{
+				{
+					Type:  bwc.Illegal,
+					Value: "malformed octal number",
+				},
+			},
+		},
+		{
+			in: "8'hzz",
+			out: []bwc.Tokval{
+				{
+					Type:  bwc.Illegal,
+					Value: "malformed verilog number",
+				},
+			},
+		},
+		{
+			in: "8'q1",
+			out: []bwc.Tokval{
+				{
+					Type:  bwc.Illegal,
+					Value: "malformed verilog number",
+				},
+			},
+		},
+		{
+			in: "1u9",
+			out: []bwc.Tokval{
+				{
+					Type:  bwc.Illegal,
+					Value: "malformed number",
+				},
+			},
+		},
+	} {
+		tc := tc
+		test(t, tc)
+	}
+}
+
+func TestLexerStatements(t *testing.T) {
+	for _, tc := range []testcase{
+		{
+			in: "a := 1; a |= 2\na <<= 1\n",
+			out: []bwc.Tokval{
+				{Type: bwc.Ident, Value: "a"},
+				{Type: bwc.Define, Value: ":="},
+				{Type: bwc.Number, Value: "1"},
+				{Type: bwc.Semicolon, Value: ";"},
+				{Type: bwc.Ident, Value: "a"},
+				{Type: bwc.AssignOp, Value: "|="},
+				{Type: bwc.Number, Value: "2"},
+				{Type: bwc.Semicolon, Value: "\n"},
+				{Type: bwc.Ident, Value: "a"},
+				{Type: bwc.AssignOp, Value: "<<="},
+				{Type: bwc.Number, Value: "1"},
+				{Type: bwc.Semicolon, Value: "\n"},
+			},
+		},
+		{
+			// newlines inside parens or after operators
+			// do not end the statement
+			in: "a = (1 |\n2) &\n3\n\n",
+			out: []bwc.Tokval{
+				{Type: bwc.Ident, Value: "a"},
+				{Type: bwc.Equal, Value: "="},
+				{Type: bwc.LParen, Value: "("},
+				{Type: bwc.Number, Value: "1"},
+				{Type: bwc.OR, Value: "|"},
+				{Type: bwc.Number, Value: "2"},
+				{Type: bwc.RParen, Value: ")"},
+				{Type: bwc.AND, Value: "&"},
+				{Type: bwc.Number, Value: "3"},
+				{Type: bwc.Semicolon, Value: "\n"},
+			},
+		},
+		{
+			in: "a &^= b >>>= c",
+			out: []bwc.Tokval{
+				{Type: bwc.Ident, Value: "a"},
+				{Type: bwc.AssignOp, Value: "&^="},
+				{Type: bwc.Ident, Value: "b"},
+				{Type: bwc.AssignOp, Value: ">>>="},
+				{Type: bwc.Ident, Value: "c"},
+			},
+		},
+		{
+			in:   "c //= d",
+			opts: []bwc.Option{bwc.Lang(bwc.LangPython)},
+			out: []bwc.Tokval{
+				{Type: bwc.Ident, Value: "c"},
+				{Type: bwc.AssignOp, Value: "//="},
+				{Type: bwc.Ident, Value: "d"},
+			},
+		},
+	} {
+		tc := tc
+		test(t, tc)
+	}
+}
+
+func TestLexerComments(t *testing.T) {
+	for _, tc := range []testcase{
+		{
+			in: "a = 1 // keep low nibble",
+			out: []bwc.Tokval{
+				{Type: bwc.Ident, Value: "a", Pos: 0},
+				{Type: bwc.Equal, Value: "=", Pos: 2},
+				{Type: bwc.Number, Value: "1", Pos: 4},
+			},
+		},
+		{
+			in: "a & /* mask */ 0xf",
+			out: []bwc.Tokval{
+				{Type: bwc.Ident, Value: "a", Pos: 0},
+				{Type: bwc.AND, Value: "&", Pos: 2},
+				{Type: bwc.Number, Value: "0xf", Pos: 15},
+			},
+		},
+		{
+			in: "a // comment\nb",
+			out: []bwc.Tokval{
+				{Type: bwc.Ident, Value: "a", Pos: 0},
+				{Type: bwc.Semicolon, Value: "\n", Pos: 12},
+				{Type: bwc.Ident, Value: "b", Pos: 13},
+			},
+		},
+		{
+			in: "a /* multi\nline */ b",
+			out: []bwc.Tokval{
+				{Type: bwc.Ident, Value: "a", Pos: 0},
+				{Type: bwc.Semicolon, Value: "\n", Pos: 18},
+				{Type: bwc.Ident, Value: "b", Pos: 19},
+			},
+		},
+		{
+			in: "a | /* multi\nline */ b",
+			out: []bwc.Tokval{
+				{Type: bwc.Ident, Value: "a", Pos: 0},
+				{Type: bwc.OR, Value: "|", Pos: 2},
+				{Type: bwc.Ident, Value: "b", Pos: 21},
+			},
+		},
+		{
+			in:   "a = 1 # comment",
+			opts: []bwc.Option{bwc.Lang(bwc.LangGo)},
+			out: []bwc.Tokval{
+				{Type: bwc.Ident, Value: "a", Pos: 0},
+				{Type: bwc.Equal, Value: "=", Pos: 2},
+				{Type: bwc.Number, Value: "1", Pos: 4},
+			},
+		},
+		{
+			in:   "a // 2 # comment",
+			opts: []bwc.Option{bwc.Lang(bwc.LangPython)},
+			out: []bwc.Tokval{
+				{Type: bwc.Ident, Value: "a", Pos: 0},
+				{Type: bwc.FDIV, Value: "//", Pos: 2},
+				{Type: bwc.Number, Value: "2", Pos: 5},
+			},
+		},
+		{
+			in: "a /* unterminated",
+			out: []bwc.Tokval{
+				{Type: bwc.Ident, Value: "a"},
+				{Type: bwc.Illegal, Value: "comment not terminated"},
+			},
+		},
+	} {
+		tc := tc
+		test(t, tc)
+	}
+}
+
+func TestNextTokenEOF(t *testing.T) {
+	for _, in := range []string{"", "a | b", "1 @ 2"} {
+		l := bwc.NewLexer(in)
+		for tok := l.NextToken(); tok.Type != bwc.EOF; tok = l.NextToken() {
+		}
+
+		// EOF is sticky, at the end of the input
+		for i := 0; i < 3; i++ {
+			tok := l.NextToken()
+			if tok.Type != bwc.EOF || tok.Pos != len(in) {
+				t.Fatalf("%q: expected EOF at %d but got %v at %d",
+					in, len(in), tok, tok.Pos)
+			}
+		}
+	}
+}
+
+func TestLexAbandoned(t *testing.T) {
+	before := runtime.NumGoroutine()
+	for i := 0; i < 100; i++ {
+		tokens := bwc.Lex("a | b & c")
+		if tok := <-tokens; tok.Type != bwc.Ident {
+			t.Fatalf("expected an identifier but got %v", tok)
+		}
+	}
+
+	// give leaked goroutines, if any, the chance to show up
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if after := runtime.NumGoroutine(); after > before {
+		t.Fatalf("goroutines leaked: %d before and %d after", before, after)
+	}
+}
@@ -0,0 +1,200 @@
+package bwc
+
+import (
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// cSuffixes are the C integer suffixes (lowercased), JavaScript
+// BigInt suffix n is also accepted.
+var cSuffixes = map[string]bool{
+	"u":   true,
+	"l":   true,
+	"ul":  true,
+	"lu":  true,
+	"ll":  true,
+	"ull": true,
+	"llu": true,
+	"n":   true,
+}
+
+// rustSuffixes are the Rust integer suffixes.
+var rustSuffixes = map[string]Type{
+	"u8":    U8,
+	"u16":   U16,
+	"u32":   U32,
+	"u64":   U64,
+	"u128":  U128,
+	"usize": U64,
+	"i8":    I8,
+	"i16":   I16,
+	"i32":   I32,
+	"i64":   I64,
+	"i128":  I128,
+	"isize": I64,
+}
+
+// isDecimal tells if the literal is a decimal number, the
+// literals made by bwc, without text, are decimal.
+func isDecimal(lit string) bool {
+	if len(lit) < 2 || lit[0] != '0' {
+		return true
+	}
+	// 0x, 0b, 0o and the legacy octal, like 0755
+	return strings.IndexByte("xXbBoO01234567", lit[1]) < 0
+}
+
+// isNumberSuffix tells if s is a valid number suffix.
+func isNumberSuffix(s string) bool {
+	if s == "" {
+		return true
+	}
+	if _, ok := rustSuffixes[s]; ok {
+		return true
+	}
+
+	// C allows any case but the ll must have the same case.
+	return cSuffixes[strings.ToLower(s)] &&
+		!strings.Contains(s, "lL") && !strings.Contains(s, "Ll")
+}
+
+// parseInt parses the text of a Number token.
+// Suffixes and Verilog sizes give the type of the literal,
+// otherwise the dialect decides the type when it is evaluated.
+func parseInt(text string, d Dialect) (Int, error) {
+	if i := strings.IndexByte(text, '\''); i >= 0 {
+		return parseVerilogInt(text[:i], text[i+1:])
+	}
+
+	body, base := text, 10
+	if len(body) > 2 && body[0] == '0' {
+		switch body[1] {
+		case 'x', 'X':
+			body, base = body[2:], 16
+		case 'b', 'B':
+			body, base = body[2:], 2
+		case 'o', 'O':
+			body, base = body[2:], 8
+		}
+	}
+
+	digits := decDigits
+	if base == 16 {
+		digits = hexDigits
+	}
+
+	end := strings.IndexFunc(body, func(r rune) bool {
+		return !strings.ContainsRune(digits, r)
+	})
+	suffix := ""
+	if end >= 0 {
+		body, suffix = body[:end], body[end:]
+	}
+
+	body = strings.Replace(body, "_", "", -1)
+	if base == 10 && len(body) > 1 && body[0] == '0' {
+		// legacy octal, like 0755
+		switch d {
+		case LangRust:
+			// leading zeros are allowed in decimals
+		case LangPython:
+			if strings.Trim(body, "0") != "" {
+				return Int{}, fmt.Errorf("leading zeros in decimal integer literals are not permitted: %s",
+					text)
+			}
+		default:
+			base = 8
+		}
+	}
+
+	val, ok := new(big.Int).SetString(body, base)
+	if !ok {
+		return Int{}, fmt.Errorf("invalid number %q", text)
+	}
+
+	lit := newInt(val)
+	if suffix == "" {
+		return lit, nil
+	}
+
+	typ, err := suffixType(suffix, val)
+	if err != nil {
+		return Int{}, fmt.Errorf("%s: %s", text, err)
+	}
+	lit.Typ = typ
+	return lit, nil
+}
+
+// suffixType returns the type given by the suffix to val.
+// C suffixes only give the minimum rank and signedness, the
+// type is the first where val fits, as in C.
+func suffixType(suffix string, val *big.Int) (Type, error) {
+	if typ, ok := rustSuffixes[suffix]; ok {
+		if !typ.fits(val) {
+			return Type{}, fmt.Errorf("literal out of range for %s", typ)
+		}
+		return typ, nil
+	}
+
+	var types []Type
+	switch strings.ToLower(suffix) {
+	case "n":
+		return Unbounded, nil
+	case "u":
+		types = []Type{U32, U64}
+	case "l", "ll":
+		types = []Type{I64, U64}
+	default:
+		types = []Type{U64}
+	}
+
+	for _, typ := range types {
+		if typ.fits(val) {
+			return typ, nil
+		}
+	}
+	return Type{}, fmt.Errorf("integer literal is too large")
+}
+
+// parseVerilogInt parses Verilog numbers like 8'hff. The size
+// is optional, unsized numbers have 32 bits. Numbers bigger
+// than the size are truncated.
+func parseVerilogInt(size, number string) (Int, error) {
+	typ := U32
+	if size != "" {
+		bits, err := strconv.ParseUint(strings.Replace(size, "_", "", -1), 10, 16)
+		if err != nil || bits == 0 {
+			return Int{}, fmt.Errorf("invalid verilog size %q", size)
+		}
+		typ.Bits = uint(bits)
+	}
+
+	if number[0] == 's' || number[0] == 'S' {
+		typ.Signed = true
+		number = number[1:]
+	}
+
+	var base int
+	switch number[0] {
+	case 'h', 'H':
+		base = 16
+	case 'd', 'D':
+		base = 10
+	case 'o', 'O':
+		base = 8
+	default:
+		base = 2
+	}
+
+	digits := strings.Replace(number[1:], "_", "", -1)
+	val, ok := new(big.Int).SetString(digits, base)
+	if !ok {
+		return Int{}, fmt.Errorf("invalid number %s'%s", size, number)
+	}
+
+	lit := newInt(typ.wrap(val))
+	lit.Typ = typ
+	return lit, nil
+}
@@ -1,14 +1,52 @@
 package bwc
 
 import (
+	"errors"
 	"fmt"
-	"strconv"
+	"strings"
+	"unicode"
 )
 
-type parser struct {
-	tokens    <-chan Tokval
-	lookahead []Tokval
-}
+type (
+	parser struct {
+		lexer     *lexer
+		lookahead []Tokval
+		prec      precedence
+		dialect   Dialect
+		cfg       *config
+		end       int // end is the position of EOF
+
+		lines   lineTable
+		lastEnd int    // lastEnd is the end of the last token read
+		prevEnd int    // prevEnd is the end of the token before it
+		last    Tokval // last is the last token read
+		ntoks   int    // ntoks is the number of tokens read
+		parens  int    // open parenthesis and brackets of the statement
+
+		// errs are the errors of the statements, the parser
+		// recovers from them to report all of them at once.
+		errs ErrorList
+
+		infunc bool // parsing a function body
+
+		// macros are the C macros, they are expanded
+		// when the tokens are read.
+		macros  map[string]MacroDecl
+		pending []expanded
+	}
+
+	// expanded is a token of a macro expansion. The macros in
+	// hide are not expanded again, so recursive macros stop
+	// as in C.
+	expanded struct {
+		tok  Tokval
+		hide map[string]bool
+	}
+
+	// precedence maps binary operators to their binding
+	// power. Operators with higher values bind tighter.
+	precedence map[Optype]int
+)
 
 var TokEOF = Tokval{
 	Type:  EOF,
@@ -16,39 +54,101 @@ var TokEOF = Tokval{
 	Pos:   -1,
 }
 
-func eoferr(expect string) error {
-	return fmt.Errorf("premature eof, expects %s",
-		expect)
+// cPrecedence follows the C operator precedence:
+// multiplicative operators bind tighter than additive ones,
+// then shifts, comparisons, equality, &, ^, |, && and ||.
+var cPrecedence = precedence{
+	OpLOR:  1,
+	OpLAND: 2,
+	OpOR:   3,
+	OpXOR:  4,
+	OpAND:  5,
+	OpEQ:   6,
+	OpNEQ:  6,
+	OpLT:   7,
+	OpLE:   7,
+	OpGT:   7,
+	OpGE:   7,
+	OpSHL:  8,
+	OpSHR:  8,
+	OpADD:  9,
+	OpSUB:  9,
+	OpMUL:  10,
+	OpDIV:  10,
+	OpMOD:  10,
+}
+
+// eoferr is the error of a premature end of the code.
+func (p *parser) eoferr(expect string) error {
+	return &ParseError{
+		Pos:      p.end,
+		Expected: expect,
+		Got:      p.eof(),
+	}
 }
 
 func parserErr(expected string, tok Tokval) error {
-	return fmt.Errorf("expected %s but got %s at position %d",
-		expected, tok, tok.Pos)
+	return &ParseError{
+		Pos:      tok.Pos,
+		Expected: expected,
+		Got:      tok,
+	}
 }
 
-func Parse(code string) (Node, error) {
+// errorAt returns the error msg, caused by tok.
+func errorAt(tok Tokval, msg string, args ...interface{}) error {
+	return &ParseError{
+		Pos: tok.Pos,
+		Got: tok,
+		Msg: fmt.Sprintf(msg, args...),
+	}
+}
+
+// Parse the code using the C operator precedence.
+// The opts could change the parsing rules, see Lang and
+// LeftToRight.
+//
+// Syntax errors are returned as an ErrorList with all the
+// errors of the code. The statements without errors are
+// still parsed and returned as a partial AST.
+func Parse(code string, opts ...Option) (Node, error) {
+	return parse(code, newConfig(opts), map[string]MacroDecl{})
+}
+
+// parse the code, the macros defined by the code are added
+// to macros, as done by the preprocessor.
+func parse(code string, c *config, macros map[string]MacroDecl) (Node, error) {
+	if c.dialect == LangGo && isGoSource(code) {
+		return parseGo(code)
+	}
+
 	p := &parser{
-		tokens: Lex(code),
+		lexer:   lex(code, c),
+		prec:    c.precedence(),
+		dialect: c.dialect,
+		cfg:     c,
+		macros:  macros,
+		end:     len(code),
+		lines:   newLineTable(code),
 	}
 
 	return p.parse()
 }
 
+// maxLookahead is the lookahead needed by C casts, the longest
+// types have four words, like in (unsigned long long int)x.
+const maxLookahead = 6
+
 // scry foretell the future using a crystal ball. Amount is how much
 // of the future you want to foresee.
 func (p *parser) scry(amount int) []Tokval {
-	if amount > 2 {
-		panic("lookahead > 2")
+	if amount > maxLookahead {
+		panic(fmt.Sprintf("lookahead > %d", maxLookahead))
 	}
 
 	sz := len(p.lookahead)
 	for i := 0; i < amount-sz; i++ {
-		val, ok := <-p.tokens
-		if !ok {
-			val = TokEOF
-		}
-
-		p.lookahead = append(p.lookahead, val)
+		p.lookahead = append(p.lookahead, p.read())
 	}
 
 	return p.lookahead
@@ -57,6 +157,7 @@ func (p *parser) scry(amount int) []Tokval {
 // forget what you had foresee
 func (p *parser) forget(amount int) {
 	for i := 0; i < amount; i++ {
+		p.consumed(p.lookahead[0])
 		p.lookahead = p.lookahead[1:]
 	}
 }
@@ -69,33 +170,786 @@ func (p *parser) next() Tokval {
 		return tok
 	}
 
-	tok, ok := <-p.tokens
-	if !ok {
-		return TokEOF
+	tok := p.read()
+	p.consumed(tok)
+	return tok
+}
+
+// consumed records the end of the last token read, it is the
+// end of the nodes being parsed.
+func (p *parser) consumed(tok Tokval) {
+	p.last = tok
+	p.ntoks++
+	switch tok.Type {
+	case LParen, LBracket:
+		p.parens++
+	case RParen, RBracket:
+		if p.parens > 0 {
+			p.parens--
+		}
+	}
+	if tok.Type != EOF && tok.Type != Semicolon {
+		p.prevEnd = p.lastEnd
+		p.lastEnd = tok.Pos + len(tok.Value)
+	}
+}
+
+// span returns the span from start to the last token read.
+func (p *parser) span(start int) Span {
+	return p.lines.span(start, p.lastEnd)
+}
+
+// join returns the span from the start of a to the end of b.
+func join(a, b Node) Span {
+	return Span{
+		Start: a.Loc().Start,
+		End:   b.Loc().End,
+	}
+}
+
+// stmtList returns the StmtList of the stmts.
+func stmtList(stmts []Node) StmtList {
+	return StmtList{
+		Stmts: stmts,
+		Span:  join(stmts[0], stmts[len(stmts)-1]),
 	}
+}
+
+// read the next token, expanding the macros.
+func (p *parser) read() Tokval {
+	for {
+		tok, hide := p.readRaw()
+		if tok.Type != Ident || hide[tok.Value] {
+			return tok
+		}
+
+		m, ok := p.macros[tok.Value]
+		if !ok {
+			return tok
+		}
+
+		ok, err := p.expand(tok, m, hide)
+		if err != nil {
+			return Tokval{
+				Type:  Illegal,
+				Value: fmt.Sprintf("%s at position %d", err, tok.Pos),
+				Pos:   tok.Pos,
+			}
+		}
+		if !ok {
+			return tok
+		}
+	}
+}
+
+// readRaw reads the next token without expanding macros.
+func (p *parser) readRaw() (Tokval, map[string]bool) {
+	if len(p.pending) > 0 {
+		e := p.pending[0]
+		p.pending = p.pending[1:]
+		return e.tok, e.hide
+	}
+
+	tok := p.lexer.NextToken()
+	if tok.Type == EOF {
+		return p.eof(), nil
+	}
+	return tok, nil
+}
+
+// eof returns the EOF token, at the end of the code.
+func (p *parser) eof() Tokval {
+	tok := TokEOF
+	tok.Pos = p.end
 	return tok
 }
 
+// expand the macro m used by tok. The tokens of the expansion
+// are read next, they all have the position of tok. Function
+// like macros are only expanded if followed by arguments.
+func (p *parser) expand(tok Tokval, m MacroDecl, hide map[string]bool) (bool, error) {
+	var args [][]expanded
+	if m.Func {
+		next, nexthide := p.readRaw()
+		if next.Type != LParen {
+			p.pending = append([]expanded{{next, nexthide}}, p.pending...)
+			return false, nil
+		}
+
+		var err error
+		args, err = p.readArgs(m.Name)
+		if err != nil {
+			return false, err
+		}
+		if len(args) == 1 && len(args[0]) == 0 && len(m.Params) == 0 {
+			args = nil
+		}
+		if len(args) != len(m.Params) {
+			return false, fmt.Errorf("macro %s expects %d arguments but got %d",
+				m.Name, len(m.Params), len(args))
+		}
+	}
+
+	body, err := lexAll(m.Body, p.cfg)
+	if err != nil {
+		return false, fmt.Errorf("macro %s: %s", m.Name, err)
+	}
+
+	newhide := map[string]bool{m.Name: true}
+	for name := range hide {
+		newhide[name] = true
+	}
+
+	var exp []expanded
+	for _, t := range body {
+		if i := indexOf(m.Params, t.Value); t.Type == Ident && i >= 0 {
+			exp = append(exp, args[i]...)
+			continue
+		}
+		t.Pos = tok.Pos
+		exp = append(exp, expanded{t, newhide})
+	}
+
+	p.pending = append(exp, p.pending...)
+	return true, nil
+}
+
+// readArgs reads the arguments of a function like macro, up
+// to the closing parenthesis. The arguments are separated by
+// commas outside parenthesis.
+func (p *parser) readArgs(name string) ([][]expanded, error) {
+	args := [][]expanded{nil}
+	depth := 0
+	for {
+		tok, hide := p.readRaw()
+		switch tok.Type {
+		case EOF, Illegal:
+			return nil, fmt.Errorf("unterminated call of macro %s", name)
+		case LParen:
+			depth++
+		case RParen:
+			if depth == 0 {
+				return args, nil
+			}
+			depth--
+		case Comma:
+			if depth == 0 {
+				args = append(args, nil)
+				continue
+			}
+		}
+
+		last := len(args) - 1
+		args[last] = append(args[last], expanded{tok, hide})
+	}
+}
+
+// lexAll returns all the tokens of code.
+func lexAll(code string, c *config) ([]Tokval, error) {
+	var toks []Tokval
+	l := lex(code, c)
+	for tok := l.NextToken(); tok.Type != EOF; tok = l.NextToken() {
+		if tok.Type == Illegal {
+			return nil, fmt.Errorf("%s", tok.Value)
+		}
+		toks = append(toks, tok)
+	}
+	return toks, nil
+}
+
+func indexOf(names []string, name string) int {
+	for i, n := range names {
+		if n == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// compound assignment operators
+var assignOps = map[string]Optype{
+	"|=":   OpOR,
+	"&=":   OpAND,
+	"&^=":  OpANDNOT,
+	"^=":   OpXOR,
+	"<<=":  OpSHL,
+	">>=":  OpSHR,
+	">>>=": OpUSHR,
+	"+=":   OpADD,
+	"-=":   OpSUB,
+	"*=":   OpMUL,
+	"/=":   OpDIV,
+	"//=":  OpFDIV,
+	"%=":   OpMOD,
+}
+
+// parse a list of statements. If there is only one statement
+// it is returned alone, otherwise a StmtList is returned.
 func (p *parser) parse() (Node, error) {
-	toks := p.scry(2)
-	if toks[0].Type == EOF {
-		return nil, eoferr("assign || expr")
+	stmts, err := p.parseStmts(EOF)
+	if err != nil {
+		p.record(err)
+	}
+
+	var n Node
+	switch len(stmts) {
+	case 0:
+		if len(p.errs) == 0 {
+			return nil, p.eoferr("assign || expr")
+		}
+	case 1:
+		n = stmts[0]
+	default:
+		n = stmtList(stmts)
+	}
+
+	if len(p.errs) > 0 {
+		return n, p.errs
+	}
+	return n, nil
+}
+
+// parseStmts parses statements until the end token.
+func (p *parser) parseStmts(end Token) ([]Node, error) {
+	var stmts []Node
+
+	// the blocks are statements of the enclosing statement
+	parens := p.parens
+	defer func() {
+		p.parens = parens
+	}()
+
+	for {
+		tok := p.scry(1)[0]
+		if tok.Type == Semicolon {
+			p.forget(1)
+			continue
+		}
+		if tok.Type == end {
+			p.forget(1)
+			return stmts, nil
+		}
+		if tok.Type == EOF {
+			return nil, p.eoferr(end.String())
+		}
+
+		start := p.ntoks
+		p.parens = 0
+		stmt, err := p.parseStmt()
+		if err != nil {
+			p.record(err)
+			p.sync(end, start)
+			continue
+		}
+		stmts = append(stmts, stmt)
+
+		tok = p.next()
+		switch tok.Type {
+		case end:
+			return stmts, nil
+		case Semicolon:
+		case EOF:
+			return nil, p.eoferr(end.String())
+		default:
+			// the statement has trailing tokens
+			stmts = stmts[:len(stmts)-1]
+			p.record(parserErr("OPERATION", tok))
+			p.sync(end, start)
+		}
+	}
+}
+
+// record the error of a statement. Only the first error at
+// each position is kept, as the errors of nested blocks
+// reaching the end of the code are seen by every block.
+func (p *parser) record(err error) {
+	var perr *ParseError
+	if !errors.As(err, &perr) {
+		perr = &ParseError{Pos: p.lastEnd, Msg: err.Error()}
+	}
+	if n := len(p.errs); n > 0 && p.errs[n-1].Pos == perr.Pos {
+		return
+	}
+	p.errs = append(p.errs, perr)
+}
+
+// sync skips the tokens of the statement that failed, which
+// started after start tokens, up to the statement boundary:
+// a semicolon or newline outside of parenthesis, brackets and
+// braces, or the brace closing the block being parsed. When
+// the parenthesis of the statement are never closed, it ends
+// at the next semicolon or newline, so the errors of the next
+// statements are still reported.
+func (p *parser) sync(end Token, start int) {
+	if p.ntoks > start && p.last.Type == Semicolon {
+		// the statement failed at its end
+		if p.parens > 0 && p.unclosed() {
+			p.dropParens()
+		}
+		return
+	}
+	if p.ntoks > start+1 && p.parens > 0 && p.last.Type != EOF &&
+		p.lines.position(p.last.Pos).Line > p.lines.position(p.prevEnd).Line {
+		// the statement failed at the first token of a line,
+		// it starts the next statement if the parenthesis
+		// are never closed.
+		p.unread()
+		if p.unclosed() {
+			p.dropParens()
+			return
+		}
+		p.forget(1)
+	}
+
+	depth := 0 // depth of the braces
+	checked, unclosed := false, false
+	for {
+		tok := p.scry(1)[0]
+		if depth == 0 && p.parens > 0 && tok.Type != EOF &&
+			(tok.Type == Semicolon || p.newline(tok)) {
+			if !checked {
+				checked, unclosed = true, p.unclosed()
+			}
+			if unclosed {
+				if tok.Type == Semicolon {
+					p.forget(1)
+				}
+				p.dropParens()
+				return
+			}
+		}
+
+		switch tok.Type {
+		case EOF:
+			return
+		case LBrace:
+			depth++
+		case RBrace:
+			if depth == 0 && end == RBrace {
+				return
+			}
+			if depth > 0 {
+				depth--
+			}
+		case Semicolon:
+			if depth == 0 && p.parens == 0 {
+				p.forget(1)
+				return
+			}
+		}
+		p.forget(1)
 	}
+}
+
+// unread puts the last token read back in the lookahead.
+func (p *parser) unread() {
+	p.lookahead = append([]Tokval{p.last}, p.lookahead...)
+	p.ntoks--
+	switch p.last.Type {
+	case LParen, LBracket:
+		p.parens--
+	case RParen, RBracket:
+		p.parens++
+	}
+}
+
+// dropParens drops the parenthesis of the failed statement
+// that are never closed, so the lexer ends the next statements
+// at the newlines again.
+func (p *parser) dropParens() {
+	p.lexer.parens -= p.parens
+	if p.lexer.parens < 0 {
+		p.lexer.parens = 0
+	}
+	p.parens = 0
+}
 
+// newline tells if tok is in a line after the last token read.
+func (p *parser) newline(tok Tokval) bool {
+	return p.lines.position(tok.Pos).Line > p.lines.position(p.lastEnd).Line
+}
+
+// unclosed tells if the open parenthesis and brackets of the
+// statement are never closed, looking ahead up to the end of
+// the code with a copy of the lexer.
+func (p *parser) unclosed() bool {
+	open := p.parens
+	closes := func(tok Tokval) bool {
+		switch tok.Type {
+		case LParen, LBracket:
+			open++
+		case RParen, RBracket:
+			open--
+		}
+		return open == 0
+	}
+
+	for _, tok := range p.lookahead {
+		if closes(tok) {
+			return false
+		}
+	}
+	l := p.lexer.clone()
+	for tok := l.NextToken(); tok.Type != EOF; tok = l.NextToken() {
+		if closes(tok) {
+			return false
+		}
+	}
+	return true
+}
+
+// parseStmt parses a statement, its span starts at the
+// first token.
+func (p *parser) parseStmt() (Node, error) {
+	start := p.scry(1)[0].Pos
+	n, err := p.parseStmtNode()
+	if err != nil {
+		return nil, err
+	}
+	return withSpan(n, p.span(start)), nil
+}
+
+func (p *parser) parseStmtNode() (Node, error) {
 	// <ident>
 	// <ident> = <expr>
+	// <ident> := <expr>
+	// <ident> <op>= <expr>
 	// <ident> <op> <expr>
 	// requires one lookahead
 
-	ident := toks[0]
-	next := toks[1]
-	if ident.Type == Ident && next.Type == Equal {
-		return p.parseAssign()
+	toks := p.scry(2)
+	if toks[0].Type == Directive {
+		return p.parseDirective()
+	}
+	if toks[0].Type == Ident {
+		switch toks[1].Type {
+		case Equal, Define, AssignOp:
+			return p.parseAssign()
+		case LBracket:
+			return p.parseSliceStmt()
+		case Ident:
+			if toks[0].Value == "fn" {
+				return p.parseFuncDecl()
+			}
+			if p.dialect == LangC && toks[0].Value == "enum" {
+				return p.parseEnum()
+			}
+			if p.dialect == LangC && isDeclWord(toks[0].Value) {
+				return p.parseDecl()
+			}
+		case LBrace:
+			if p.dialect == LangC && toks[0].Value == "enum" {
+				return p.parseEnum()
+			}
+		}
 	}
 
 	return p.parseExpr()
 }
 
+// parseDirective parses the C preprocessor directives:
+// #define NAME body
+// #define NAME(a, b) body
+// #undef NAME
+// The macros are defined as they are parsed, so the next
+// statements can use them.
+func (p *parser) parseDirective() (Node, error) {
+	tok := p.next()
+	text := strings.ReplaceAll(tok.Value[1:], "\\\n", " ")
+	text = strings.TrimLeftFunc(text, unicode.IsSpace)
+
+	directive := text
+	if i := strings.IndexFunc(text, unicode.IsSpace); i >= 0 {
+		directive, text = text[:i], strings.TrimLeftFunc(text[i:], unicode.IsSpace)
+	} else {
+		text = ""
+	}
+
+	name := text
+	if i := strings.IndexFunc(text, func(r rune) bool {
+		return !isAlphaNumeric(r) && r != '_'
+	}); i >= 0 {
+		name, text = text[:i], text[i:]
+	} else {
+		text = ""
+	}
+	if !isIdent(name) {
+		return nil, errorAt(tok, "missing macro name in #%s", directive)
+	}
+
+	switch directive {
+	case "define":
+	case "undef":
+		if strings.TrimSpace(text) != "" {
+			return nil, errorAt(tok, "extra tokens after #undef %s", name)
+		}
+		delete(p.macros, name)
+		return MacroDecl{
+			Name:  name,
+			Undef: true,
+		}, nil
+	default:
+		return nil, errorAt(tok, "unsupported directive #%s", directive)
+	}
+
+	macro := MacroDecl{
+		Name: name,
+	}
+
+	// the parameters only if the parenthesis follows the name
+	if strings.HasPrefix(text, "(") {
+		end := strings.Index(text, ")")
+		if end < 0 {
+			return nil, errorAt(tok, "missing ) in parameters of macro %s", name)
+		}
+
+		macro.Func = true
+		for _, param := range strings.Split(text[1:end], ",") {
+			param = strings.TrimSpace(param)
+			if param == "" && len(macro.Params) == 0 && end == 1 {
+				break
+			}
+			if !isIdent(param) || indexOf(macro.Params, param) >= 0 {
+				return nil, errorAt(tok, "invalid parameter %q of macro %s",
+					param, name)
+			}
+			macro.Params = append(macro.Params, param)
+		}
+		text = text[end+1:]
+	}
+
+	macro.Body = strings.TrimSpace(text)
+	p.macros[name] = macro
+	return macro, nil
+}
+
+// isIdent tells if s is a valid identifier.
+func isIdent(s string) bool {
+	for i, r := range s {
+		if !isIdentBegin(r) && (i == 0 || !unicode.IsDigit(r)) {
+			return false
+		}
+	}
+	return s != ""
+}
+
+// declWords are the qualifiers that start a C declaration,
+// they don't change the value.
+var declWords = map[string]bool{
+	"static":   true,
+	"const":    true,
+	"volatile": true,
+	"extern":   true,
+	"register": true,
+}
+
+// isDeclWord tells if word starts a C declaration.
+func isDeclWord(word string) bool {
+	return declWords[word] || LangC.isTypeWord(word)
+}
+
+// parseDecl parses C variable declarations, like:
+// static const uint32_t MASK = 0xff
+// The value is converted to the declared type, variables
+// without value are zero.
+func (p *parser) parseDecl() (Node, error) {
+	var words []Tokval
+	for p.scry(1)[0].Type == Ident {
+		words = append(words, p.next())
+	}
+
+	name := words[len(words)-1]
+	var typwords []string
+	for _, w := range words[:len(words)-1] {
+		if !declWords[w.Value] {
+			typwords = append(typwords, w.Value)
+		}
+	}
+
+	// as in old C, the type defaults to int
+	typname := strings.Join(typwords, " ")
+	if typname == "" {
+		typname = "int"
+	}
+	typ, ok := p.dialect.typeByName(typname)
+	if !ok {
+		return nil, errorAt(words[0], "unknown type %q", typname)
+	}
+
+	var val Node = withSpan(NewInt(0), p.span(name.Pos))
+	if p.scry(1)[0].Type == Equal {
+		p.forget(1)
+
+		var err error
+		val, err = p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return Assign{
+		Varname: name.Value,
+		Expr: Cast{
+			To:    typ,
+			Value: val,
+			Span:  val.Loc(),
+		},
+	}, nil
+}
+
+// parseEnum parses C enums, the constants have the int type:
+// enum [name] { A, B = <expr>, C }
+// Constants without value are the previous one plus one.
+func (p *parser) parseEnum() (Node, error) {
+	p.forget(1)
+	if p.scry(1)[0].Type == Ident {
+		p.forget(1)
+	}
+
+	tok := p.next()
+	if tok.Type != LBrace {
+		return nil, parserErr("LBRACE", tok)
+	}
+
+	var enum Enum
+	var prev Node = NewInt(-1)
+	for {
+		tok = p.next()
+		for tok.Type == Semicolon {
+			tok = p.next()
+		}
+		if tok.Type == RBrace {
+			break
+		}
+		if tok.Type != Ident {
+			return nil, parserErr("IDENT", tok)
+		}
+
+		// the implicit values are in the name of the constant
+		namespan := p.span(tok.Pos)
+		val := Node(BinExpr{
+			Op:   OpADD,
+			Lhs:  prev,
+			Rhs:  withSpan(NewInt(1), namespan),
+			Span: namespan,
+		})
+		if p.scry(1)[0].Type == Equal {
+			p.forget(1)
+
+			var err error
+			val, err = p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		enum.Consts = append(enum.Consts, Assign{
+			Varname: tok.Value,
+			Expr: Cast{
+				To:    I32,
+				Value: val,
+				Span:  val.Loc(),
+			},
+			Span: p.span(tok.Pos),
+		})
+		prev = Var{Name: tok.Value, Span: namespan}
+
+		tok = p.next()
+		for tok.Type == Semicolon {
+			tok = p.next()
+		}
+		if tok.Type == RBrace {
+			break
+		}
+		if tok.Type != Comma {
+			return nil, parserErr("COMMA or RBRACE", tok)
+		}
+	}
+
+	if len(enum.Consts) == 0 {
+		return nil, errorAt(tok, "empty enum")
+	}
+	return enum, nil
+}
+
+// parseFuncDecl parses function definitions:
+// fn name(a, b) = <expr>
+// fn name(a, b) { <stmts> }
+func (p *parser) parseFuncDecl() (Node, error) {
+	fn := p.next()
+	if p.infunc {
+		return nil, errorAt(fn, "nested functions are not supported")
+	}
+
+	decl := FuncDecl{
+		Name: p.next().Value,
+	}
+
+	tok := p.next()
+	if tok.Type != LParen {
+		return nil, parserErr("LPAREN", tok)
+	}
+
+	params := map[string]bool{}
+	for p.scry(1)[0].Type != RParen {
+		if len(decl.Params) > 0 {
+			tok = p.next()
+			if tok.Type != Comma {
+				return nil, parserErr("COMMA or RPAREN", tok)
+			}
+		}
+
+		tok = p.next()
+		if tok.Type != Ident {
+			return nil, parserErr("IDENT", tok)
+		}
+		if params[tok.Value] {
+			return nil, errorAt(tok, "duplicated parameter %s", tok.Value)
+		}
+		params[tok.Value] = true
+		decl.Params = append(decl.Params, tok.Value)
+	}
+	p.forget(1)
+
+	p.infunc = true
+	defer func() {
+		p.infunc = false
+	}()
+
+	tok = p.next()
+	switch tok.Type {
+	case Equal:
+		body, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		decl.Body = body
+	case LBrace:
+		errs := len(p.errs)
+		stmts, err := p.parseStmts(RBrace)
+		if err != nil {
+			return nil, err
+		}
+
+		switch len(stmts) {
+		case 0:
+			if len(p.errs) > errs {
+				// the body has statements, they failed
+				// with the errors already recorded
+				return nil, p.errs[len(p.errs)-1]
+			}
+			return nil, errorAt(tok, "function %s has no body", decl.Name)
+		case 1:
+			decl.Body = stmts[0]
+		default:
+			decl.Body = stmtList(stmts)
+		}
+	default:
+		return nil, parserErr("EQUAL or LBRACE", tok)
+	}
+	return decl, nil
+}
+
 func (p *parser) parseAssign() (Node, error) {
 	p.scry(2)
 
@@ -105,7 +959,26 @@ func (p *parser) parseAssign() (Node, error) {
 	if id.Type != Ident {
 		return nil, parserErr("IDENT", id)
 	}
-	if eq.Type != Equal {
+
+	assign := Assign{
+		Varname: id.Value,
+	}
+
+	switch eq.Type {
+	case Equal:
+	case Define:
+		assign.Define = true
+	case AssignOp:
+		op, ok := assignOps[eq.Value]
+		if !ok {
+			return nil, parserErr("ASSIGNMENT", eq)
+		}
+		if _, ok := p.prec[op]; !ok {
+			return nil, errorAt(eq, "operator %s not supported in %s",
+				eq.Value, p.dialect)
+		}
+		assign.Op = op
+	default:
 		return nil, parserErr("EQUAL", eq)
 	}
 
@@ -116,196 +989,498 @@ func (p *parser) parseAssign() (Node, error) {
 		return nil, err
 	}
 
-	return Assign{
-		Varname: id.Value,
-		Expr:    expr,
-	}, nil
+	assign.Expr = expr
+	return assign, nil
 }
 
-func (p *parser) parseOperand() (n Node, eof bool, err error) {
-	var hasparens bool
-
+func (p *parser) parseOperand() (n Node, err error) {
 	p.scry(1)
 
 	tok := p.lookahead[0]
 	if tok.Type == EOF {
-		return nil, true, eoferr("expr || number || ident || unary")
+		return nil, p.eoferr("expr || number || ident || unary")
 	}
 
-	if tok.Type == LParen {
-		hasparens = true
-		p.forget(1)
-		n, err = p.parseExpr()
-	} else if tok.Type == NOT {
+	if _, ok := p.dialect.unaryOP(tok); ok {
 		n, err = p.parseUnary()
-	} else if tok.Type == Ident {
-		n = Var(tok.Value)
-		p.forget(1)
-	} else {
-		n, eof, err = p.parseNum()
+		if err != nil {
+			return nil, err
+		}
+		return withSpan(n, p.span(tok.Pos)), nil
 	}
 
-	if hasparens {
-		tok = p.next()
-		if tok.Type != RParen {
-			return nil, tok.Type == EOF, parserErr("RPAREN", tok)
+	n, err = p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	n = withSpan(n, p.span(tok.Pos))
+
+	// the slices bind tighter than the unary operators,
+	// ~X[3] is ~(X[3])
+	for p.scry(1)[0].Type == LBracket {
+		n, err = p.parseSlice(n)
+		if err != nil {
+			return nil, err
 		}
+		n = withSpan(n, p.span(tok.Pos))
 	}
+	return n, nil
+}
 
+// parseSlice parses the bit slices of val:
+// <val>[<hi>:<lo>]
+// <val>[<bit>]
+func (p *parser) parseSlice(val Node) (Node, error) {
+	p.forget(1)
+
+	hi, err := p.parseExpr()
 	if err != nil {
-		return nil, false, err
+		return nil, err
 	}
-	if eof {
-		return n, true, nil
+
+	slice := Slice{
+		Value: val,
+		Hi:    hi,
 	}
 
-	return n, false, nil
+	tok := p.next()
+	if tok.Type == COLON {
+		slice.Lo, err = p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		tok = p.next()
+	}
+
+	if tok.Type != RBracket {
+		return nil, parserErr("RBRACKET", tok)
+	}
+	return slice, nil
 }
 
-func (p *parser) parseExpr() (Node, error) {
-	// left hand side of expr
-	lhs, eof, err := p.parseOperand()
+// parseSliceStmt parses the statements starting with a slice
+// of a variable, they are expressions or slice assignments:
+// <ident>[<hi>:<lo>] = <expr>
+func (p *parser) parseSliceStmt() (Node, error) {
+	n, err := p.parseExpr()
 	if err != nil {
 		return nil, err
 	}
 
-	if eof {
-		return lhs, nil
+	eq := p.scry(1)[0]
+	if eq.Type != Equal {
+		return n, nil
 	}
 
-	p.scry(1)
-	tok := p.lookahead[0]
-	if tok.Type == RParen {
-		return lhs, nil
+	slice, ok := n.(Slice)
+	if !ok || slice.Value.Type() != NodeVar {
+		return nil, errorAt(eq, "cannot assign to %s", n)
 	}
+	p.forget(1)
 
-	op, eof, err := p.parseBinOP()
+	expr, err := p.parseExpr()
 	if err != nil {
 		return nil, err
 	}
 
-	if eof {
-		return lhs, nil
+	return SliceAssign{
+		Varname: slice.Value.(Var).Name,
+		Hi:      slice.Hi,
+		Lo:      slice.Lo,
+		Expr:    expr,
+	}, nil
+}
+
+// parsePrimary parses numbers, variables, casts and
+// parenthesized expressions.
+func (p *parser) parsePrimary() (n Node, err error) {
+	toks := p.scry(2)
+	tok := toks[0]
+
+	switch tok.Type {
+	case LBrace:
+		// {hi, lo} or {4{2'b10}}
+		return p.parseConcat()
+	case LParen:
+		// (uint32_t)x
+		if p.isCast() {
+			return p.parseCast()
+		}
+		return p.parseParenExpr()
+	case Ident:
+		if toks[1].Type == LParen {
+			// uint32(x)
+			if typ, ok := p.dialect.typeByName(tok.Value); ok {
+				p.forget(1)
+
+				n, err = p.parseParenExpr()
+				if err != nil {
+					return nil, err
+				}
+				return Cast{
+					To:    typ,
+					Value: n,
+				}, nil
+			}
+
+			// popcount(x)
+			return p.parseCall()
+		}
+
+		p.forget(1)
+		return Var{Name: tok.Value}, nil
 	}
 
-	// right hand side of expr
-	rhs, eof, err := p.parseOperand()
+	return p.parseNum()
+}
+
+// parseConcat parses the Verilog concatenations and
+// replications:
+// {<expr>, <expr>, ...}
+// {<count>{<expr>, <expr>, ...}}
+func (p *parser) parseConcat() (Node, error) {
+	start := p.next()
+	if start.Type != LBrace {
+		return nil, parserErr("LBRACE", start)
+	}
+
+	var tok Tokval
+	var concat Concat
+	for {
+		part, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+
+		if len(concat.Parts) == 0 && p.scry(1)[0].Type == LBrace {
+			value, err := p.parseConcat()
+			if err != nil {
+				return nil, err
+			}
+			if value.Type() != NodeConcat {
+				return nil, errorAt(tok, "replication of %s must be a concatenation",
+					value)
+			}
+
+			tok = p.next()
+			if tok.Type != RBrace {
+				return nil, parserErr("RBRACE", tok)
+			}
+			return Replicate{
+				Count: part,
+				Value: value.(Concat),
+			}, nil
+		}
+		concat.Parts = append(concat.Parts, part)
+
+		tok = p.next()
+		switch tok.Type {
+		case RBrace:
+			concat.Span = p.span(start.Pos)
+			return concat, nil
+		case Comma:
+		default:
+			return nil, parserErr("COMMA or RBRACE", tok)
+		}
+	}
+}
+
+func (p *parser) parseParenExpr() (Node, error) {
+	tok := p.next()
+	if tok.Type != LParen {
+		return nil, parserErr("LPAREN", tok)
+	}
+
+	n, err := p.parseExpr()
 	if err != nil {
 		return nil, err
 	}
 
-	expr := BinExpr{
-		Op:  op,
-		Lhs: lhs,
-		Rhs: rhs,
+	tok = p.next()
+	if tok.Type != RParen {
+		return nil, parserErr("RPAREN", tok)
 	}
+	return n, nil
+}
 
-	if eof {
-		return expr, nil
+// parseCall parses function calls. The arguments are
+// separated by commas.
+func (p *parser) parseCall() (Node, error) {
+	call := Call{
+		Name: p.next().Value,
 	}
+	p.forget(1)
 
-	// additional, non grouped, expressions
-	for !eof {
-		op, eof, err = p.parseBinOP()
-		if eof || err != nil {
-			return expr, nil
-		}
+	if p.scry(1)[0].Type == RParen {
+		p.forget(1)
+		return call, nil
+	}
 
-		rhs, err = p.parseExpr()
+	for {
+		arg, err := p.parseExpr()
 		if err != nil {
 			return nil, err
 		}
+		call.Args = append(call.Args, arg)
 
-		// 0|1&2|3 == (((0|1)&2)|3)
-		// operation order is left to right
-		expr = BinExpr{
-			Op:  op,
-			Lhs: expr,
-			Rhs: rhs,
+		tok := p.next()
+		switch tok.Type {
+		case RParen:
+			return call, nil
+		case Comma:
+		default:
+			return nil, parserErr("COMMA or RPAREN", tok)
 		}
 	}
-	return expr, nil
 }
 
-func (p *parser) parseNum() (a Int, eof bool, err error) {
+// isCast tells if the parenthesis starts a C cast, like
+// (unsigned long)x, and not an expression starting with a
+// conversion, like (uint32(x) | 1).
+func (p *parser) isCast() bool {
+	toks := p.scry(2)
+	if toks[1].Type != Ident || !p.dialect.isTypeWord(toks[1].Value) {
+		return false
+	}
+
+	for i := 2; i < maxLookahead; i++ {
+		switch p.scry(i + 1)[i].Type {
+		case RParen:
+			return true
+		case Ident:
+		default:
+			return false
+		}
+	}
+	return false
+}
+
+// parseCast parses C casts. The type could have many words,
+// like (unsigned long long).
+func (p *parser) parseCast() (Node, error) {
+	p.forget(1)
+
+	var words []string
+	for p.scry(1)[0].Type == Ident {
+		words = append(words, p.next().Value)
+	}
+
 	tok := p.next()
-	if tok.Type == EOF {
-		return 0, true, eoferr("expected number")
+	if tok.Type != RParen {
+		return nil, parserErr("RPAREN", tok)
 	}
 
-	if tok.Type != Number {
-		return 0, false, parserErr("NUMBER", tok)
+	name := strings.Join(words, " ")
+	typ, ok := p.dialect.typeByName(name)
+	if !ok {
+		return nil, errorAt(tok, "unknown type %q", name)
 	}
 
-	intstr := tok.Value
-	if len(intstr) > 2 {
-		if intstr[1] == 'b' {
-			val, err := strconv.ParseInt(intstr[2:], 2, 64)
-			return Int(val), false, err
-		}
+	n, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
 
-		if intstr[1] == 'x' {
-			val, err := strconv.ParseInt(intstr[2:], 16, 64)
-			return Int(val), false, err
-		}
+	return Cast{
+		To:    typ,
+		Value: n,
+	}, nil
+}
+
+// parseExpr parses an expression, including the conditional
+// expressions, which bind looser than any binary operator and
+// are right associative.
+func (p *parser) parseExpr() (Node, error) {
+	n, err := p.parseBinExpr(1)
+	if err != nil {
+		return nil, err
 	}
 
-	val, err := strconv.ParseInt(intstr, 10, 64)
-	return Int(val), false, err
+	tok := p.scry(1)[0]
+	switch {
+	case tok.Type == QUESTION:
+		// cond ? a : b
+		if !p.dialect.ternary() {
+			return nil, errorAt(tok, "operator %s not supported in %s",
+				tok.Type, p.dialect)
+		}
+		p.forget(1)
+		return p.parseCond(n, COLON)
+	case tok.Type == Ident && tok.Value == "if" && p.dialect == LangPython:
+		// a if cond else b
+		p.forget(1)
+		return p.parsePythonCond(n)
+	}
+	return n, nil
 }
 
-func (p *parser) parseUnary() (n Node, err error) {
+// parseCond parses the branches of a conditional expression.
+// They are separated by sep.
+func (p *parser) parseCond(cond Node, sep Token) (Node, error) {
+	then, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+
 	tok := p.next()
+	if tok.Type != sep {
+		return nil, parserErr(sep.String(), tok)
+	}
 
-	var val UnaryExpr
-	if tok.Type == NOT {
-		val.Op = OpNOT
-	} else {
-		return nil, fmt.Errorf("invalid unary: %q", tok.Value)
+	els, err := p.parseExpr()
+	if err != nil {
+		return nil, err
 	}
 
-	num, eof, err := p.parseNum()
+	return CondExpr{
+		Cond: cond,
+		Then: then,
+		Else: els,
+		Span: join(cond, els),
+	}, nil
+}
+
+// parsePythonCond parses the rest of the Python conditional
+// expression, the then branch comes before the condition.
+func (p *parser) parsePythonCond(then Node) (Node, error) {
+	cond, err := p.parseBinExpr(1)
 	if err != nil {
 		return nil, err
 	}
-	if eof {
-		return nil, fmt.Errorf("expected number")
+
+	tok := p.next()
+	if tok.Type != Ident || tok.Value != "else" {
+		return nil, parserErr("else", tok)
+	}
+
+	els, err := p.parseExpr()
+	if err != nil {
+		return nil, err
 	}
 
-	val.Value = num
-	return val, nil
+	return CondExpr{
+		Cond: cond,
+		Then: then,
+		Else: els,
+		Span: join(then, els),
+	}, nil
 }
 
-func (p *parser) parseBinOP() (a Optype, eof bool, err error) {
-	p.scry(1)
+// parseBinExpr parses binary expressions by precedence climbing.
+// Only operators binding at least as tight as minprec are
+// consumed, the rest is left for the callers up in the stack.
+// All binary operators are left associative, except for the
+// chained comparisons of Python (a < b < c is a < b and b < c)
+// and Rust, where they are an error.
+func (p *parser) parseBinExpr(minprec int) (Node, error) {
+	lhs, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+
+	// rhs of the last comparison, used by chains
+	var cmp Node
+
+	for {
+		p.scry(1)
+
+		optok := p.lookahead[0]
+		op, ok := p.dialect.binaryOP(optok)
+		if !ok {
+			return lhs, nil
+		}
+
+		prec, ok := p.prec[op]
+		if !ok {
+			return nil, errorAt(optok, "operator %s not supported in %s",
+				op, p.dialect)
+		}
+		if prec < minprec {
+			return lhs, nil
+		}
+
+		p.forget(1)
+
+		rhs, err := p.parseBinExpr(prec + 1)
+		if err != nil {
+			return nil, err
+		}
+
+		switch {
+		case cmp != nil && isComparison(op) && p.dialect == LangPython:
+			lhs = BinExpr{
+				Op:  OpLAND,
+				Lhs: lhs,
+				Rhs: BinExpr{
+					Op:   op,
+					Lhs:  cmp,
+					Rhs:  rhs,
+					Span: join(cmp, rhs),
+				},
+				Span: join(lhs, rhs),
+			}
+		case cmp != nil && isComparison(op) && p.dialect == LangRust:
+			return nil, errorAt(optok, "comparison operators cannot be chained")
+		default:
+			lhs = BinExpr{
+				Op:   op,
+				Lhs:  lhs,
+				Rhs:  rhs,
+				Span: join(lhs, rhs),
+			}
+		}
 
-	optok := p.lookahead[0]
-	if optok.Type == EOF {
-		return 0, true, nil
+		cmp = nil
+		if isComparison(op) {
+			cmp = rhs
+		}
 	}
+}
 
-	op, ok := validBinOP(optok.Type)
-	if !ok {
-		return 0, false, parserErr("OPERATION", optok)
+func (p *parser) parseNum() (Node, error) {
+	tok := p.next()
+	if tok.Type == EOF {
+		return nil, p.eoferr("number")
 	}
 
-	p.forget(1)
-	return op, false, nil
+	if tok.Type != Number {
+		return nil, parserErr("NUMBER", tok)
+	}
+
+	n, err := parseInt(tok.Value, p.dialect)
+	if err != nil {
+		return nil, errorAt(tok, "%s", err)
+	}
+	n.Lit = tok.Value
+	return n, nil
 }
 
-func validBinOP(tok Token) (Optype, bool) {
-	switch tok {
-	case AND:
-		return OpAND, true
-	case OR:
-		return OpOR, true
-	case XOR:
-		return OpXOR, true
-	case SHL:
-		return OpSHL, true
-	case SHR:
-		return OpSHR, true
+// parseUnary parses unary operations. They bind tighter than
+// any binary operator and could be nested, like in ~-x.
+func (p *parser) parseUnary() (n Node, err error) {
+	tok := p.next()
+
+	var val UnaryExpr
+
+	op, ok := p.dialect.unaryOP(tok)
+	if !ok {
+		return nil, errorAt(tok, "invalid unary: %q", tok.Value)
 	}
+	val.Op = op
 
-	return -1, false
+	if tok.Type == Ident {
+		// python's not binds looser than comparisons
+		// but tighter than and/or.
+		val.Value, err = p.parseBinExpr(p.prec[OpEQ])
+		if err != nil {
+			return nil, err
+		}
+		return val, nil
+	}
+
+	val.Value, err = p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	return val, nil
 }
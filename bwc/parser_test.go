@@ -1,8 +1,12 @@
 package bwc
 
 import (
+	"errors"
 	"reflect"
+	"runtime"
+	"strings"
 	"testing"
+	"time"
 )
 
 type testcase struct {
@@ -24,7 +28,7 @@ func test(t *testing.T, tc testcase) {
 			got.Type(), got)
 	}
 
-	if !reflect.DeepEqual(got, tc.ast) {
+	if !EqualNodes(got, tc.ast) {
 		t.Fatalf("node differs: (%s) != (%s)", got, tc.ast)
 	}
 }
@@ -35,14 +39,14 @@ func TestParseAssign(t *testing.T) {
 			code: "a = 1",
 			ast: Assign{
 				Varname: "a",
-				Expr:    Int(1),
+				Expr:    NewInt(1),
 			},
 		},
 		{
 			code: "a = a",
 			ast: Assign{
 				Varname: "a",
-				Expr:    Var("a"),
+				Expr:    Var{Name: "a"},
 			},
 		},
 		{
@@ -50,8 +54,8 @@ func TestParseAssign(t *testing.T) {
 			ast: Assign{
 				Varname: "a",
 				Expr: BinExpr{
-					Lhs: Var("a"),
-					Rhs: Var("b"),
+					Lhs: Var{Name: "a"},
+					Rhs: Var{Name: "b"},
 					Op:  OpOR,
 				},
 			},
@@ -68,8 +72,8 @@ func TestParserBinExpr(t *testing.T) {
 			code: "0|1",
 			ast: BinExpr{
 				Op:  OpOR,
-				Lhs: Int(0),
-				Rhs: Int(1),
+				Lhs: NewInt(0),
+				Rhs: NewInt(1),
 			},
 		},
 		{
@@ -78,34 +82,34 @@ func TestParserBinExpr(t *testing.T) {
 				Op: OpOR,
 				Lhs: BinExpr{
 					Op:  OpOR,
-					Lhs: Int(0),
-					Rhs: Int(1),
+					Lhs: NewInt(0),
+					Rhs: NewInt(1),
 				},
-				Rhs: Int(2),
+				Rhs: NewInt(2),
 			},
 		},
 		{
 			code: "a|1",
 			ast: BinExpr{
 				Op:  OpOR,
-				Lhs: Var("a"),
-				Rhs: Int(1),
+				Lhs: Var{Name: "a"},
+				Rhs: NewInt(1),
 			},
 		},
 		{
 			code: "(a|1)",
 			ast: BinExpr{
 				Op:  OpOR,
-				Lhs: Var("a"),
-				Rhs: Int(1),
+				Lhs: Var{Name: "a"},
+				Rhs: NewInt(1),
 			},
 		},
 		{
 			code: "((a|1))",
 			ast: BinExpr{
 				Op:  OpOR,
-				Lhs: Var("a"),
-				Rhs: Int(1),
+				Lhs: Var{Name: "a"},
+				Rhs: NewInt(1),
 			},
 		},
 		{
@@ -114,13 +118,1346 @@ func TestParserBinExpr(t *testing.T) {
 				Op: OpOR,
 				Lhs: BinExpr{
 					Op:  OpOR,
-					Lhs: Var("a"),
-					Rhs: Int(1),
+					Lhs: Var{Name: "a"},
+					Rhs: NewInt(1),
 				},
-				Rhs: Int(2),
+				Rhs: NewInt(2),
 			},
 		},
 	} {
 		test(t, tc)
 	}
 }
+
+func TestParserPrecedence(t *testing.T) {
+	for _, tc := range []testcase{
+		{
+			code: "a|b&c",
+			ast: BinExpr{
+				Op:  OpOR,
+				Lhs: Var{Name: "a"},
+				Rhs: BinExpr{
+					Op:  OpAND,
+					Lhs: Var{Name: "b"},
+					Rhs: Var{Name: "c"},
+				},
+			},
+		},
+		{
+			code: "x<<2|y",
+			ast: BinExpr{
+				Op: OpOR,
+				Lhs: BinExpr{
+					Op:  OpSHL,
+					Lhs: Var{Name: "x"},
+					Rhs: NewInt(2),
+				},
+				Rhs: Var{Name: "y"},
+			},
+		},
+		{
+			code: "a^b|c^d",
+			ast: BinExpr{
+				Op: OpOR,
+				Lhs: BinExpr{
+					Op:  OpXOR,
+					Lhs: Var{Name: "a"},
+					Rhs: Var{Name: "b"},
+				},
+				Rhs: BinExpr{
+					Op:  OpXOR,
+					Lhs: Var{Name: "c"},
+					Rhs: Var{Name: "d"},
+				},
+			},
+		},
+		{
+			code: "a&b^c",
+			ast: BinExpr{
+				Op: OpXOR,
+				Lhs: BinExpr{
+					Op:  OpAND,
+					Lhs: Var{Name: "a"},
+					Rhs: Var{Name: "b"},
+				},
+				Rhs: Var{Name: "c"},
+			},
+		},
+		{
+			code: "1<<2>>1",
+			ast: BinExpr{
+				Op: OpSHR,
+				Lhs: BinExpr{
+					Op:  OpSHL,
+					Lhs: NewInt(1),
+					Rhs: NewInt(2),
+				},
+				Rhs: NewInt(1),
+			},
+		},
+		{
+			code: "(a|b)&c",
+			ast: BinExpr{
+				Op: OpAND,
+				Lhs: BinExpr{
+					Op:  OpOR,
+					Lhs: Var{Name: "a"},
+					Rhs: Var{Name: "b"},
+				},
+				Rhs: Var{Name: "c"},
+			},
+		},
+		{
+			code: "x&x-1",
+			ast: BinExpr{
+				Op:  OpAND,
+				Lhs: Var{Name: "x"},
+				Rhs: BinExpr{
+					Op:  OpSUB,
+					Lhs: Var{Name: "x"},
+					Rhs: NewInt(1),
+				},
+			},
+		},
+		{
+			code: "x*2+1<<y",
+			ast: BinExpr{
+				Op: OpSHL,
+				Lhs: BinExpr{
+					Op: OpADD,
+					Lhs: BinExpr{
+						Op:  OpMUL,
+						Lhs: Var{Name: "x"},
+						Rhs: NewInt(2),
+					},
+					Rhs: NewInt(1),
+				},
+				Rhs: Var{Name: "y"},
+			},
+		},
+		{
+			code: "x&-x",
+			ast: BinExpr{
+				Op:  OpAND,
+				Lhs: Var{Name: "x"},
+				Rhs: UnaryExpr{
+					Op:    OpNEG,
+					Value: Var{Name: "x"},
+				},
+			},
+		},
+		{
+			code: "-(a+1)*2",
+			ast: BinExpr{
+				Op: OpMUL,
+				Lhs: UnaryExpr{
+					Op: OpNEG,
+					Value: BinExpr{
+						Op:  OpADD,
+						Lhs: Var{Name: "a"},
+						Rhs: NewInt(1),
+					},
+				},
+				Rhs: NewInt(2),
+			},
+		},
+		{
+			code: "a&~1|b",
+			ast: BinExpr{
+				Op: OpOR,
+				Lhs: BinExpr{
+					Op:  OpAND,
+					Lhs: Var{Name: "a"},
+					Rhs: UnaryExpr{
+						Op:    OpNOT,
+						Value: NewInt(1),
+					},
+				},
+				Rhs: Var{Name: "b"},
+			},
+		},
+	} {
+		test(t, tc)
+	}
+}
+
+func TestParserLeftToRight(t *testing.T) {
+	got, err := Parse("0|1&2|3", LeftToRight())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := BinExpr{
+		Op: OpOR,
+		Lhs: BinExpr{
+			Op: OpAND,
+			Lhs: BinExpr{
+				Op:  OpOR,
+				Lhs: NewInt(0),
+				Rhs: NewInt(1),
+			},
+			Rhs: NewInt(2),
+		},
+		Rhs: NewInt(3),
+	}
+
+	if !EqualNodes(got, expected) {
+		t.Fatalf("node differs: (%s) != (%s)", got, expected)
+	}
+}
+
+func TestParserErrors(t *testing.T) {
+	for _, code := range []string{
+		"",
+		"0|",
+		"(0|1",
+		"0|1)",
+		"1 2",
+		"a = ",
+		"(unsigned bogus)x",
+		"(uint32_t)",
+	} {
+		_, err := Parse(code)
+		if err == nil {
+			t.Fatalf("expected error parsing %q", code)
+		}
+	}
+}
+
+func TestParserRecovery(t *testing.T) {
+	for _, tc := range []struct {
+		code    string
+		dialect Dialect
+		errs    []int // errs are the positions of the errors
+		partial string
+	}{
+		{
+			code:    "x = 1\ny = (2 * )\nz = 3 @ 4\nx | z",
+			errs:    []int{15, 23},
+			partial: "x = 1; x | z",
+		},
+		{
+			code:    "a b c\n1 + )\n3",
+			errs:    []int{2, 10},
+			partial: "3",
+		},
+		{
+			code:    "fn f(a) { a +; a }\nf(1 +",
+			errs:    []int{13, 24},
+			partial: "fn f(a) { a }",
+		},
+		{
+			code:    "fn f(a) {\n a +* 1\n}\nf(1)",
+			errs:    []int{14},
+			partial: "f(1)",
+		},
+		{
+			code:    "x = (1 + 2\ny = 3 @ 4\nz = 5",
+			errs:    []int{11, 17},
+			partial: "z = 5",
+		},
+		{
+			code:    "x = f(1 @ 2,\n3)\ny = 4 @\nx | y",
+			errs:    []int{8, 22},
+			partial: "x | y",
+		},
+		{
+			code:    "x = (1 + ; y = (2 *\ny = 3 @ 4\n5",
+			errs:    []int{9, 22},
+			partial: "5",
+		},
+		{
+			code:    "x = f(1,\n2 +); y = 1 << ",
+			errs:    []int{12, 24},
+			partial: "",
+		},
+		{
+			code:    "x = 1 < 2 < 3; y = 1 >>> 2; x",
+			dialect: LangRust,
+			errs:    []int{10, 21},
+			partial: "x",
+		},
+		{
+			code:    "func f() {\nx := \ny = ]\n}",
+			dialect: LangGo,
+			errs:    []int{19, 24},
+		},
+	} {
+		n, err := Parse(tc.code, Lang(tc.dialect))
+
+		var errs ErrorList
+		if !errors.As(err, &errs) {
+			t.Fatalf("%q: expected an ErrorList but got %#v", tc.code, err)
+		}
+		var pos []int
+		for _, e := range errs {
+			pos = append(pos, e.Pos)
+		}
+		if !reflect.DeepEqual(pos, tc.errs) {
+			t.Fatalf("%q: got errors %v at %v != expected %v",
+				tc.code, errs, pos, tc.errs)
+		}
+
+		if tc.partial == "" {
+			if n != nil {
+				t.Fatalf("%q: unexpected partial AST %s", tc.code, n)
+			}
+			continue
+		}
+		expected, err := Parse(tc.partial, Lang(tc.dialect))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !EqualNodes(n, expected) {
+			t.Fatalf("%q: got partial AST (%s) != (%s)", tc.code, n, expected)
+		}
+	}
+}
+
+func TestParserDialects(t *testing.T) {
+	for _, tc := range []struct {
+		code    string
+		dialect Dialect
+		ast     Node
+	}{
+		{
+			// C: a & (b << c)
+			code:    "a&b<<c",
+			dialect: LangC,
+			ast: BinExpr{
+				Op:  OpAND,
+				Lhs: Var{Name: "a"},
+				Rhs: BinExpr{
+					Op:  OpSHL,
+					Lhs: Var{Name: "b"},
+					Rhs: Var{Name: "c"},
+				},
+			},
+		},
+		{
+			// Go: (a & b) << c
+			code:    "a&b<<c",
+			dialect: LangGo,
+			ast: BinExpr{
+				Op: OpSHL,
+				Lhs: BinExpr{
+					Op:  OpAND,
+					Lhs: Var{Name: "a"},
+					Rhs: Var{Name: "b"},
+				},
+				Rhs: Var{Name: "c"},
+			},
+		},
+		{
+			// Go: (a | b) ^ c
+			code:    "a|b^c",
+			dialect: LangGo,
+			ast: BinExpr{
+				Op: OpXOR,
+				Lhs: BinExpr{
+					Op:  OpOR,
+					Lhs: Var{Name: "a"},
+					Rhs: Var{Name: "b"},
+				},
+				Rhs: Var{Name: "c"},
+			},
+		},
+		{
+			code:    "a|b&^c",
+			dialect: LangGo,
+			ast: BinExpr{
+				Op:  OpOR,
+				Lhs: Var{Name: "a"},
+				Rhs: BinExpr{
+					Op:  OpANDNOT,
+					Lhs: Var{Name: "b"},
+					Rhs: Var{Name: "c"},
+				},
+			},
+		},
+		{
+			code:    "a|b>>>1",
+			dialect: LangJava,
+			ast: BinExpr{
+				Op:  OpOR,
+				Lhs: Var{Name: "a"},
+				Rhs: BinExpr{
+					Op:  OpUSHR,
+					Lhs: Var{Name: "b"},
+					Rhs: NewInt(1),
+				},
+			},
+		},
+		{
+			code:    "!0",
+			dialect: LangRust,
+			ast: UnaryExpr{
+				Op:    OpNOT,
+				Value: NewInt(0),
+			},
+		},
+		{
+			code:    "not a|1",
+			dialect: LangPython,
+			ast: UnaryExpr{
+				Op: OpLNOT,
+				Value: BinExpr{
+					Op:  OpOR,
+					Lhs: Var{Name: "a"},
+					Rhs: NewInt(1),
+				},
+			},
+		},
+	} {
+		got, err := Parse(tc.code, Lang(tc.dialect))
+		if err != nil {
+			t.Fatalf("%s: %s", tc.dialect, err)
+		}
+
+		if !EqualNodes(got, tc.ast) {
+			t.Fatalf("%s: node differs: (%s) != (%s)",
+				tc.dialect, got, tc.ast)
+		}
+	}
+}
+
+func TestParserDialectUnsupportedOps(t *testing.T) {
+	for _, tc := range []struct {
+		code    string
+		dialect Dialect
+	}{
+		{code: "a&^b", dialect: LangC},
+		{code: "a>>>1", dialect: LangGo},
+		{code: "a>>>1", dialect: LangRust},
+		{code: "!a", dialect: LangPython},
+		{code: "^a", dialect: LangC},
+		{code: "a ? b : c", dialect: LangGo},
+		{code: "a ? b : c", dialect: LangRust},
+		{code: "a ? b : c", dialect: LangPython},
+		{code: "a ? b", dialect: LangC},
+		{code: "a && b", dialect: LangPython},
+		{code: "a if b", dialect: LangPython},
+		{code: "a < b < c", dialect: LangRust},
+		{code: "a == b != c", dialect: LangRust},
+		{code: "a&^b", dialect: LangJS},
+	} {
+		_, err := Parse(tc.code, Lang(tc.dialect))
+		if err == nil {
+			t.Fatalf("%s: expected error parsing %q",
+				tc.dialect, tc.code)
+		}
+	}
+}
+
+func TestParserUnary(t *testing.T) {
+	not := func(n Node) Node {
+		return UnaryExpr{Op: OpNOT, Value: n}
+	}
+
+	for _, tc := range []struct {
+		code    string
+		dialect Dialect
+		ast     Node
+	}{
+		{code: "~X", dialect: LangC, ast: not(Var{Name: "X"})},
+		{code: "~~0xff", dialect: LangC, ast: not(not(NewInt(0xff)))},
+		{
+			code:    "~(a|b)",
+			dialect: LangC,
+			ast: not(BinExpr{
+				Op:  OpOR,
+				Lhs: Var{Name: "a"},
+				Rhs: Var{Name: "b"},
+			}),
+		},
+		{
+			code:    "-~x",
+			dialect: LangC,
+			ast: UnaryExpr{
+				Op:    OpNEG,
+				Value: not(Var{Name: "x"}),
+			},
+		},
+		{
+			code:    "~-x",
+			dialect: LangC,
+			ast: not(UnaryExpr{
+				Op:    OpNEG,
+				Value: Var{Name: "x"},
+			}),
+		},
+		{
+			code:    "!a&b",
+			dialect: LangC,
+			ast: BinExpr{
+				Op: OpAND,
+				Lhs: UnaryExpr{
+					Op:    OpLNOT,
+					Value: Var{Name: "a"},
+				},
+				Rhs: Var{Name: "b"},
+			},
+		},
+		{
+			code:    "+a*-b",
+			dialect: LangJava,
+			ast: BinExpr{
+				Op: OpMUL,
+				Lhs: UnaryExpr{
+					Op:    OpPOS,
+					Value: Var{Name: "a"},
+				},
+				Rhs: UnaryExpr{
+					Op:    OpNEG,
+					Value: Var{Name: "b"},
+				},
+			},
+		},
+		{
+			code:    "^x&^y",
+			dialect: LangGo,
+			ast: BinExpr{
+				Op:  OpANDNOT,
+				Lhs: not(Var{Name: "x"}),
+				Rhs: Var{Name: "y"},
+			},
+		},
+		{
+			code:    "a^^b",
+			dialect: LangGo,
+			ast: BinExpr{
+				Op:  OpXOR,
+				Lhs: Var{Name: "a"},
+				Rhs: not(Var{Name: "b"}),
+			},
+		},
+		{code: "!!0", dialect: LangRust, ast: not(not(NewInt(0)))},
+		{code: "~uint8(x)", dialect: LangGo, ast: not(Cast{To: U8, Value: Var{Name: "x"}})},
+		{code: "~(uint8_t)~x", dialect: LangC, ast: not(Cast{To: U8, Value: not(Var{Name: "x"})})},
+	} {
+		got, err := Parse(tc.code, Lang(tc.dialect))
+		if err != nil {
+			t.Fatalf("%s: %s: %s", tc.dialect, tc.code, err)
+		}
+
+		if !EqualNodes(got, tc.ast) {
+			t.Fatalf("%s: node differs: (%s) != (%s)",
+				tc.dialect, got, tc.ast)
+		}
+	}
+}
+
+func TestParserLogical(t *testing.T) {
+	bin := func(op Optype, lhs, rhs Node) Node {
+		return BinExpr{Op: op, Lhs: lhs, Rhs: rhs}
+	}
+	a, b, c, m := Var{Name: "a"}, Var{Name: "b"}, Var{Name: "c"}, Var{Name: "m"}
+
+	for _, tc := range []struct {
+		code    string
+		dialect Dialect
+		ast     Node
+	}{
+		{
+			// the classic C pitfall
+			code:    "a&m==m",
+			dialect: LangC,
+			ast:     bin(OpAND, a, bin(OpEQ, m, m)),
+		},
+		{code: "a&m==m", dialect: LangGo, ast: bin(OpEQ, bin(OpAND, a, m), m)},
+		{code: "a&m==m", dialect: LangRust, ast: bin(OpEQ, bin(OpAND, a, m), m)},
+		{code: "a|m!=0", dialect: LangPython, ast: bin(OpNEQ, bin(OpOR, a, m), NewInt(0))},
+		{code: "a<b==b>=c", dialect: LangC, ast: bin(OpEQ, bin(OpLT, a, b), bin(OpGE, b, c))},
+		{code: "a<<1<b", dialect: LangC, ast: bin(OpLT, bin(OpSHL, a, NewInt(1)), b)},
+		{
+			code:    "a||b&&c",
+			dialect: LangC,
+			ast:     bin(OpLOR, a, bin(OpLAND, b, c)),
+		},
+		{
+			code:    "a&&b|c",
+			dialect: LangJava,
+			ast:     bin(OpLAND, a, bin(OpOR, b, c)),
+		},
+		{
+			code:    "!a||b",
+			dialect: LangGo,
+			ast:     bin(OpLOR, UnaryExpr{Op: OpLNOT, Value: a}, b),
+		},
+		{
+			code:    "a or b and c",
+			dialect: LangPython,
+			ast:     bin(OpLOR, a, bin(OpLAND, b, c)),
+		},
+		{
+			code:    "not a == b and c",
+			dialect: LangPython,
+			ast: bin(OpLAND,
+				UnaryExpr{Op: OpLNOT, Value: bin(OpEQ, a, b)},
+				c),
+		},
+		{
+			code:    "a < b <= c",
+			dialect: LangPython,
+			ast:     bin(OpLAND, bin(OpLT, a, b), bin(OpLE, b, c)),
+		},
+		{
+			code:    "a < b < c",
+			dialect: LangC,
+			ast:     bin(OpLT, bin(OpLT, a, b), c),
+		},
+		{
+			code:    "c ? a : b",
+			dialect: LangC,
+			ast:     CondExpr{Cond: c, Then: a, Else: b},
+		},
+		{
+			code:    "a&m ? a|b : a^b",
+			dialect: LangJS,
+			ast: CondExpr{
+				Cond: bin(OpAND, a, m),
+				Then: bin(OpOR, a, b),
+				Else: bin(OpXOR, a, b),
+			},
+		},
+		{
+			code:    "a ? b : c ? 1 : 2",
+			dialect: LangC,
+			ast: CondExpr{
+				Cond: a,
+				Then: b,
+				Else: CondExpr{Cond: c, Then: NewInt(1), Else: NewInt(2)},
+			},
+		},
+		{
+			code:    "a ? b ? 1 : 2 : c",
+			dialect: LangJava,
+			ast: CondExpr{
+				Cond: a,
+				Then: CondExpr{Cond: b, Then: NewInt(1), Else: NewInt(2)},
+				Else: c,
+			},
+		},
+		{
+			code:    "a if c else b",
+			dialect: LangPython,
+			ast:     CondExpr{Cond: c, Then: a, Else: b},
+		},
+		{
+			code:    "m = (a & m) == m ? a : b",
+			dialect: LangC,
+			ast: Assign{
+				Varname: "m",
+				Expr: CondExpr{
+					Cond: bin(OpEQ, bin(OpAND, a, m), m),
+					Then: a,
+					Else: b,
+				},
+			},
+		},
+	} {
+		got, err := Parse(tc.code, Lang(tc.dialect))
+		if err != nil {
+			t.Fatalf("%s: %s: %s", tc.dialect, tc.code, err)
+		}
+
+		if !EqualNodes(got, tc.ast) {
+			t.Fatalf("%s: %s: node differs: (%s) != (%s)",
+				tc.dialect, tc.code, got, tc.ast)
+		}
+	}
+}
+
+func TestParserCasts(t *testing.T) {
+	for _, tc := range []struct {
+		code    string
+		dialect Dialect
+		ast     Node
+	}{
+		{
+			code:    "(uint32_t)x",
+			dialect: LangC,
+			ast: Cast{
+				To:    U32,
+				Value: Var{Name: "x"},
+			},
+		},
+		{
+			code:    "(unsigned long long)~x",
+			dialect: LangC,
+			ast: Cast{
+				To: U64,
+				Value: UnaryExpr{
+					Op:    OpNOT,
+					Value: Var{Name: "x"},
+				},
+			},
+		},
+		{
+			code:    "(uint8_t)x+1",
+			dialect: LangC,
+			ast: BinExpr{
+				Op: OpADD,
+				Lhs: Cast{
+					To:    U8,
+					Value: Var{Name: "x"},
+				},
+				Rhs: NewInt(1),
+			},
+		},
+		{
+			code:    "(x)",
+			dialect: LangC,
+			ast:     Var{Name: "x"},
+		},
+		{
+			code:    "uint64(x|1)",
+			dialect: LangGo,
+			ast: Cast{
+				To: U64,
+				Value: BinExpr{
+					Op:  OpOR,
+					Lhs: Var{Name: "x"},
+					Rhs: NewInt(1),
+				},
+			},
+		},
+		{
+			code:    "int(x)",
+			dialect: LangGo,
+			ast: Cast{
+				To:    I64,
+				Value: Var{Name: "x"},
+			},
+		},
+		{
+			code:    "(int)x",
+			dialect: LangJava,
+			ast: Cast{
+				To:    I32,
+				Value: Var{Name: "x"},
+			},
+		},
+		{
+			code:    "u8(x)",
+			dialect: LangRust,
+			ast: Cast{
+				To:    U8,
+				Value: Var{Name: "x"},
+			},
+		},
+	} {
+		got, err := Parse(tc.code, Lang(tc.dialect))
+		if err != nil {
+			t.Fatalf("%s: %s", tc.code, err)
+		}
+
+		if !EqualNodes(got, tc.ast) {
+			t.Fatalf("%s: node differs: (%s) != (%s)",
+				tc.code, got, tc.ast)
+		}
+	}
+}
+
+func TestParserCalls(t *testing.T) {
+	for _, tc := range []struct {
+		code    string
+		dialect Dialect
+		ast     Node
+	}{
+		{
+			code:    "popcount(x)",
+			dialect: LangC,
+			ast:     Call{Name: "popcount", Args: []Node{Var{Name: "x"}}},
+		},
+		{
+			code:    "f()",
+			dialect: LangC,
+			ast:     Call{Name: "f"},
+		},
+		{
+			code:    "rotl(x|1, n ? 3 : 4)",
+			dialect: LangC,
+			ast: Call{
+				Name: "rotl",
+				Args: []Node{
+					BinExpr{Op: OpOR, Lhs: Var{Name: "x"}, Rhs: NewInt(1)},
+					CondExpr{Cond: Var{Name: "n"}, Then: NewInt(3), Else: NewInt(4)},
+				},
+			},
+		},
+		{
+			code:    "~clz(x)&0xff",
+			dialect: LangGo,
+			ast: BinExpr{
+				Op: OpAND,
+				Lhs: UnaryExpr{
+					Op:    OpNOT,
+					Value: Call{Name: "clz", Args: []Node{Var{Name: "x"}}},
+				},
+				Rhs: NewInt(0xff),
+			},
+		},
+		{
+			code:    "bswap(uint32(x))",
+			dialect: LangGo,
+			ast: Call{
+				Name: "bswap",
+				Args: []Node{Cast{To: U32, Value: Var{Name: "x"}}},
+			},
+		},
+		{
+			code:    "rotl(x,\n\t3)",
+			dialect: LangGo,
+			ast:     Call{Name: "rotl", Args: []Node{Var{Name: "x"}, NewInt(3)}},
+		},
+	} {
+		got, err := Parse(tc.code, Lang(tc.dialect))
+		if err != nil {
+			t.Fatalf("%s: %s: %s", tc.dialect, tc.code, err)
+		}
+
+		if !EqualNodes(got, tc.ast) {
+			t.Fatalf("%s: %s: node differs: (%s) != (%s)",
+				tc.dialect, tc.code, got, tc.ast)
+		}
+	}
+
+	for _, code := range []string{"f(", "f(1,)", "f(1 2)", "f(,1)"} {
+		_, err := Parse(code)
+		if err == nil {
+			t.Fatalf("expected error parsing %q", code)
+		}
+	}
+}
+
+func TestParserFuncDecl(t *testing.T) {
+	for _, tc := range []testcase{
+		{
+			code: "fn low(x) = x & 0xf",
+			ast: FuncDecl{
+				Name:   "low",
+				Params: []string{"x"},
+				Body:   BinExpr{Op: OpAND, Lhs: Var{Name: "x"}, Rhs: NewInt(0xf)},
+			},
+		},
+		{
+			code: "fn one() = 1",
+			ast: FuncDecl{
+				Name: "one",
+				Body: NewInt(1),
+			},
+		},
+		{
+			code: "fn f(a, b) {\n\ta |= b\n\ta << 1\n}",
+			ast: FuncDecl{
+				Name:   "f",
+				Params: []string{"a", "b"},
+				Body: StmtList{
+					Stmts: []Node{
+						Assign{Varname: "a", Op: OpOR, Expr: Var{Name: "b"}},
+						BinExpr{Op: OpSHL, Lhs: Var{Name: "a"}, Rhs: NewInt(1)},
+					},
+				},
+			},
+		},
+		{
+			code: "fn f(a) { a }\nf(1)",
+			ast: StmtList{
+				Stmts: []Node{
+					FuncDecl{Name: "f", Params: []string{"a"}, Body: Var{Name: "a"}},
+					Call{Name: "f", Args: []Node{NewInt(1)}},
+				},
+			},
+		},
+		{
+			code: "fn = 1",
+			ast:  Assign{Varname: "fn", Expr: NewInt(1)},
+		},
+	} {
+		test(t, tc)
+	}
+
+	for _, code := range []string{
+		"fn f(x) x",
+		"fn f(x, x) = x",
+		"fn f(x,) = x",
+		"fn f(1) = x",
+		"fn f(x) {}",
+		"fn f(x) { x",
+		"fn f(x) { fn g(y) = y }",
+	} {
+		_, err := Parse(code)
+		if err == nil {
+			t.Fatalf("expected error parsing %q", code)
+		}
+	}
+}
+
+func TestParserMacros(t *testing.T) {
+	for _, tc := range []testcase{
+		{
+			code: "#define MASK 0xff",
+			ast:  MacroDecl{Name: "MASK", Body: "0xff"},
+		},
+		{
+			code: "#define GET(m, v) \\\n\t(((v) & (m)) >> __builtin_ctz(m))",
+			ast: MacroDecl{
+				Name:   "GET",
+				Params: []string{"m", "v"},
+				Body:   "(((v) & (m)) >> __builtin_ctz(m))",
+				Func:   true,
+			},
+		},
+		{
+			code: "#define F() 1",
+			ast:  MacroDecl{Name: "F", Body: "1", Func: true},
+		},
+		{
+			code: "#undef MASK",
+			ast:  MacroDecl{Name: "MASK", Undef: true},
+		},
+		{
+			code: "#define A 1 << 4\nx = A",
+			ast: StmtList{
+				Stmts: []Node{
+					MacroDecl{Name: "A", Body: "1 << 4"},
+					Assign{
+						Varname: "x",
+						Expr:    BinExpr{Op: OpSHL, Lhs: NewInt(1), Rhs: NewInt(4)},
+					},
+				},
+			},
+		},
+		{
+			code: "#define SQ(x) ((x) * (x))\nSQ(a + 1)",
+			ast: StmtList{
+				Stmts: []Node{
+					MacroDecl{
+						Name:   "SQ",
+						Params: []string{"x"},
+						Body:   "((x) * (x))",
+						Func:   true,
+					},
+					BinExpr{
+						Op:  OpMUL,
+						Lhs: BinExpr{Op: OpADD, Lhs: Var{Name: "a"}, Rhs: NewInt(1)},
+						Rhs: BinExpr{Op: OpADD, Lhs: Var{Name: "a"}, Rhs: NewInt(1)},
+					},
+				},
+			},
+		},
+		{
+			code: "#define SQ(x) x * x\nSQ",
+			ast: StmtList{
+				Stmts: []Node{
+					MacroDecl{
+						Name:   "SQ",
+						Params: []string{"x"},
+						Body:   "x * x",
+						Func:   true,
+					},
+					Var{Name: "SQ"},
+				},
+			},
+		},
+		{
+			code: "#define foo foo\nfoo",
+			ast: StmtList{
+				Stmts: []Node{
+					MacroDecl{Name: "foo", Body: "foo"},
+					Var{Name: "foo"},
+				},
+			},
+		},
+		{
+			code: "static const uint8_t MASK = 0xff",
+			ast:  Assign{Varname: "MASK", Expr: Cast{To: U8, Value: NewInt(0xff)}},
+		},
+		{
+			code: "unsigned long x",
+			ast:  Assign{Varname: "x", Expr: Cast{To: U64, Value: NewInt(0)}},
+		},
+		{
+			code: "enum flags { A, B = 1 << 2, C, };",
+			ast: Enum{
+				Consts: []Assign{
+					{Varname: "A", Expr: Cast{
+						To:    I32,
+						Value: BinExpr{Op: OpADD, Lhs: NewInt(-1), Rhs: NewInt(1)},
+					}},
+					{Varname: "B", Expr: Cast{
+						To:    I32,
+						Value: BinExpr{Op: OpSHL, Lhs: NewInt(1), Rhs: NewInt(2)},
+					}},
+					{Varname: "C", Expr: Cast{
+						To:    I32,
+						Value: BinExpr{Op: OpADD, Lhs: Var{Name: "B"}, Rhs: NewInt(1)},
+					}},
+				},
+			},
+		},
+	} {
+		test(t, tc)
+	}
+
+	for _, code := range []string{
+		"#include <stdint.h>",
+		"#define",
+		"#define 1 2",
+		"#define F(a, a) a",
+		"#define F(a b) a",
+		"#undef A B",
+		"#define F(a) a\nF(1, 2)",
+		"#define F(a) a\nF(1",
+		"#define A 1 @\nA",
+		"enum {}",
+		"enum { A B }",
+		"static const foo x = 1",
+	} {
+		_, err := Parse(code)
+		if err == nil {
+			t.Fatalf("expected error parsing %q", code)
+		}
+	}
+}
+
+func TestParserSlices(t *testing.T) {
+	for _, tc := range []testcase{
+		{
+			code: "X[19:12]",
+			ast:  Slice{Value: Var{Name: "X"}, Hi: NewInt(19), Lo: NewInt(12)},
+		},
+		{
+			code: "X[3]",
+			ast:  Slice{Value: Var{Name: "X"}, Hi: NewInt(3)},
+		},
+		{
+			code: "~X[3] + 1",
+			ast: BinExpr{
+				Op:  OpADD,
+				Lhs: UnaryExpr{Op: OpNOT, Value: Slice{Value: Var{Name: "X"}, Hi: NewInt(3)}},
+				Rhs: NewInt(1),
+			},
+		},
+		{
+			code: "(a | b)[7:0][3]",
+			ast: Slice{
+				Value: Slice{
+					Value: BinExpr{Op: OpOR, Lhs: Var{Name: "a"}, Rhs: Var{Name: "b"}},
+					Hi:    NewInt(7),
+					Lo:    NewInt(0),
+				},
+				Hi: NewInt(3),
+			},
+		},
+		{
+			code: "X[n+3:n]",
+			ast: Slice{
+				Value: Var{Name: "X"},
+				Hi:    BinExpr{Op: OpADD, Lhs: Var{Name: "n"}, Rhs: NewInt(3)},
+				Lo:    Var{Name: "n"},
+			},
+		},
+		{
+			code: "c ? X[1:0] : X[3:2]",
+			ast: CondExpr{
+				Cond: Var{Name: "c"},
+				Then: Slice{Value: Var{Name: "X"}, Hi: NewInt(1), Lo: NewInt(0)},
+				Else: Slice{Value: Var{Name: "X"}, Hi: NewInt(3), Lo: NewInt(2)},
+			},
+		},
+		{
+			code: "X[7:4] = 0b1010",
+			ast: SliceAssign{
+				Varname: "X",
+				Hi:      NewInt(7),
+				Lo:      NewInt(4),
+				Expr:    NewInt(0b1010),
+			},
+		},
+		{
+			code: "X[0] = X[1]",
+			ast: SliceAssign{
+				Varname: "X",
+				Hi:      NewInt(0),
+				Expr:    Slice{Value: Var{Name: "X"}, Hi: NewInt(1)},
+			},
+		},
+	} {
+		test(t, tc)
+	}
+
+	for _, code := range []string{
+		"X[]",
+		"X[1",
+		"X[1:]",
+		"X[1:0:0]",
+		"X[1] + 1 = 2",
+		"X[1][0] = 2",
+	} {
+		_, err := Parse(code)
+		if err == nil {
+			t.Fatalf("expected error parsing %q", code)
+		}
+	}
+}
+
+func TestParserConcat(t *testing.T) {
+	b10 := Int{Val: NewInt(2).Val, Typ: Type{Bits: 2}}
+	for _, tc := range []testcase{
+		{
+			code: "{hi, lo}",
+			ast:  Concat{Parts: []Node{Var{Name: "hi"}, Var{Name: "lo"}}},
+		},
+		{
+			code: "{4{2'b10}}",
+			ast: Replicate{
+				Count: NewInt(4),
+				Value: Concat{Parts: []Node{b10}},
+			},
+		},
+		{
+			code: "{a, {2{b, 2'b10}}} | 1",
+			ast: BinExpr{
+				Op: OpOR,
+				Lhs: Concat{Parts: []Node{
+					Var{Name: "a"},
+					Replicate{
+						Count: NewInt(2),
+						Value: Concat{Parts: []Node{Var{Name: "b"}, b10}},
+					},
+				}},
+				Rhs: NewInt(1),
+			},
+		},
+		{
+			code: "x = {\n\ta,\n\tb}",
+			ast: Assign{
+				Varname: "x",
+				Expr:    Concat{Parts: []Node{Var{Name: "a"}, Var{Name: "b"}}},
+			},
+		},
+	} {
+		test(t, tc)
+	}
+
+	for _, code := range []string{
+		"{}",
+		"{a,}",
+		"{a b}",
+		"{a, b",
+		"{2{a}",
+		"{2{a} b}",
+		"{2{3{a}}}",
+		"{a, 2{b}}",
+	} {
+		_, err := Parse(code)
+		if err == nil {
+			t.Fatalf("expected error parsing %q", code)
+		}
+	}
+}
+
+func TestParserWideNumbers(t *testing.T) {
+	for _, tc := range []struct {
+		code string
+		hex  string
+	}{
+		{code: "0xffffffffffffffff", hex: "ffffffffffffffff"},
+		{code: "0x8000000000000000", hex: "8000000000000000"},
+		{code: "0xffffffffffffffffffffffffffffffff", hex: "ffffffffffffffffffffffffffffffff"},
+		{code: "0b" + strings.Repeat("1", 100), hex: "f" + strings.Repeat("f", 24)},
+		{code: "340282366920938463463374607431768211455", hex: strings.Repeat("f", 32)},
+	} {
+		got, err := Parse(tc.code)
+		if err != nil {
+			t.Fatalf("%s: %s", tc.code, err)
+		}
+
+		n, ok := got.(Int)
+		if !ok {
+			t.Fatalf("%s: expected Int but got %s", tc.code, got.Type())
+		}
+		if n.Val.Text(16) != tc.hex {
+			t.Fatalf("%s: got(%s) != expected(%s)", tc.code,
+				n.Val.Text(16), tc.hex)
+		}
+	}
+}
+
+func TestParserNumberSyntax(t *testing.T) {
+	for _, tc := range []struct {
+		code    string
+		dialect Dialect
+		val     int64
+		typ     Type
+	}{
+		{code: "0xFF", val: 0xff},
+		{code: "0XFF", val: 0xff},
+		{code: "0B11", val: 3},
+		{code: "0755", val: 0755},
+		{code: "0o755", val: 0755},
+		{code: "0755", dialect: LangGo, val: 0755},
+		{code: "0755", dialect: LangRust, val: 755},
+		{code: "000", dialect: LangPython, val: 0},
+		{code: "1_000_000", val: 1000000},
+		{code: "0xffff_0000", val: 0xffff0000},
+		{code: "1ULL", val: 1, typ: U64},
+		{code: "0xffu", val: 0xff, typ: U32},
+		{code: "0x1ffffffffu", val: 0x1ffffffff, typ: U64},
+		{code: "10L", val: 10, typ: I64},
+		{code: "10ul", val: 10, typ: U64},
+		{code: "0xffu64", val: 0xff, typ: U64},
+		{code: "0xff_u8", val: 0xff, typ: U8},
+		{code: "0x7fi8", val: 0x7f, typ: I8},
+		{code: "1usize", val: 1, typ: U64},
+		{code: "123n", val: 123, typ: Unbounded},
+		{code: "8'hFF", val: 0xff, typ: U8},
+		{code: "4'b1010", val: 10, typ: Type{Bits: 4}},
+		{code: "4'hff", val: 0xf, typ: Type{Bits: 4}},
+		{code: "8'sb1000_0000", val: -128, typ: I8},
+		{code: "12'd4095", val: 4095, typ: Type{Bits: 12}},
+		{code: "'hdead", val: 0xdead, typ: U32},
+	} {
+		got, err := Parse(tc.code, Lang(tc.dialect))
+		if err != nil {
+			t.Fatalf("%s: %s", tc.code, err)
+		}
+
+		n, ok := got.(Int)
+		if !ok {
+			t.Fatalf("%s: expected Int but got %s", tc.code, got.Type())
+		}
+		if n.Val.Int64() != tc.val || n.Typ != tc.typ {
+			t.Fatalf("%s: got(%s %s) != expected(%d %s)", tc.code,
+				n.Val, n.Typ, tc.val, tc.typ)
+		}
+	}
+
+	for _, tc := range []struct {
+		code    string
+		dialect Dialect
+	}{
+		{code: "08", dialect: LangC},
+		{code: "0755", dialect: LangPython},
+		{code: "256u8", dialect: LangRust},
+		{code: "0x80i8", dialect: LangRust},
+		{code: "0x10000000000000000ULL", dialect: LangC},
+		{code: "0'hff", dialect: LangC},
+	} {
+		_, err := Parse(tc.code, Lang(tc.dialect))
+		if err == nil {
+			t.Fatalf("%s: expected error parsing %q",
+				tc.dialect, tc.code)
+		}
+	}
+}
+
+func TestParserStatements(t *testing.T) {
+	for _, tc := range []testcase{
+		{
+			code: "a = 1; b = a",
+			ast: StmtList{
+				Stmts: []Node{
+					Assign{
+						Varname: "a",
+						Expr:    NewInt(1),
+					},
+					Assign{
+						Varname: "b",
+						Expr:    Var{Name: "a"},
+					},
+				},
+			},
+		},
+		{
+			code: "\n;a = 1;\n\n",
+			ast: Assign{
+				Varname: "a",
+				Expr:    NewInt(1),
+			},
+		},
+		{
+			code: "X := x\nX |= X << 8\nX",
+			ast: StmtList{
+				Stmts: []Node{
+					Assign{
+						Varname: "X",
+						Expr:    Var{Name: "x"},
+						Define:  true,
+					},
+					Assign{
+						Varname: "X",
+						Op:      OpOR,
+						Expr: BinExpr{
+							Op:  OpSHL,
+							Lhs: Var{Name: "X"},
+							Rhs: NewInt(8),
+						},
+					},
+					Var{Name: "X"},
+				},
+			},
+		},
+		{
+			code: "a = (1 |\n 2)",
+			ast: Assign{
+				Varname: "a",
+				Expr: BinExpr{
+					Op:  OpOR,
+					Lhs: NewInt(1),
+					Rhs: NewInt(2),
+				},
+			},
+		},
+		{
+			code: "a -= 1",
+			ast: Assign{
+				Varname: "a",
+				Op:      OpSUB,
+				Expr:    NewInt(1),
+			},
+		},
+	} {
+		test(t, tc)
+	}
+
+	for _, tc := range []struct {
+		code    string
+		dialect Dialect
+	}{
+		{code: "a = 1 b = 2", dialect: LangC},
+		{code: "a := 1\n| 2", dialect: LangGo},
+		{code: "a &^= 1", dialect: LangC},
+		{code: "a >>>= 1", dialect: LangGo},
+		{code: ";;", dialect: LangC},
+		{code: "1 = a", dialect: LangC},
+	} {
+		_, err := Parse(tc.code, Lang(tc.dialect))
+		if err == nil {
+			t.Fatalf("%s: expected error parsing %q",
+				tc.dialect, tc.code)
+		}
+	}
+}
+
+func TestParserNoLeaks(t *testing.T) {
+	before := runtime.NumGoroutine()
+	for i := 0; i < 100; i++ {
+		for _, code := range []string{"1 + )", "x = (1 +", "1 @ 2", "a b c d"} {
+			if _, err := Parse(code); err == nil {
+				t.Fatalf("expected error parsing %q", code)
+			}
+		}
+	}
+
+	// give leaked goroutines, if any, the chance to show up
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if after := runtime.NumGoroutine(); after > before {
+		t.Fatalf("goroutines leaked: %d before and %d after", before, after)
+	}
+}
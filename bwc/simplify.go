@@ -0,0 +1,831 @@
+package bwc
+
+import (
+	"math/big"
+	"strings"
+)
+
+// simplifier rewrites expressions to simpler ones with the
+// same values. The rules are only applied when they keep the
+// type of the expression, the types come from evaluating the
+// expressions in the probe interpreter, with the variables
+// holding values of their types.
+type simplifier struct {
+	dialect Dialect
+	probe   *interp
+}
+
+// maxSteps limits the rules applied to a node, every rule
+// makes the node smaller, so this is just a safety net.
+const maxSteps = 100
+
+// noZeros is the number of trailing zeros of the zero.
+const noZeros = 1 << 30
+
+// Simplify returns the expressions of n rewritten to simpler
+// forms with the same values, in the dialect of opts:
+//
+//	((X | (X << 16)) & 0x0000ffff0000ffff) & 0xffff
+//
+// is simplified to X & 0xffff in Go. It folds the constants, merges
+// masks and shifts, and removes the operations known to have
+// no effect, like x | 0, x & ~x and x & (x | y). The variables
+// are assumed to be of the int type of the dialect.
+//
+// The folded constants keep their type in the Typ of the Int,
+// unless the other operand gives it, and Format converts them
+// to it. The rewritten expressions of other types are converted
+// with casts, so evaluating or formatting and parsing the result
+// gives the same values and types of n. Function bodies, ifs and loops are not changed.
+func Simplify(n Node, opts ...Option) Node {
+	s := simplifier{
+		dialect: newConfig(opts).dialect,
+		probe:   NewInterp(opts...),
+	}
+	return s.simplify(n)
+}
+
+// Simplify parses the code and simplifies it as the package
+// Simplify does, with the variables and functions defined in
+// the interpreter. The code is not executed, the macros it
+// defines are not kept.
+func (e *interp) Simplify(code string) (Node, error) {
+	macros := e.copyMacros()
+	n, err := parse(code, e.cfg, macros)
+	if err != nil {
+		return nil, err
+	}
+
+	probe := &interp{
+		environ: make(map[string]Value, len(e.environ)),
+		funcs:   make(map[string]FuncDecl, len(e.funcs)),
+		macros:  macros,
+		cfg:     e.cfg,
+	}
+	for name, val := range e.environ {
+		probe.environ[name] = val
+	}
+	for name, fn := range e.funcs {
+		probe.funcs[name] = fn
+	}
+
+	s := simplifier{dialect: e.cfg.dialect, probe: probe}
+	return s.simplify(n), nil
+}
+
+// simplify the statements of n, the probe runs the
+// assignments so the variables get the types they have
+// after them.
+func (s simplifier) simplify(n Node) Node {
+	Inspect(n, func(n Node) bool {
+		if v, ok := n.(Var); ok {
+			if _, ok := s.probe.environ[v.Name]; !ok {
+				s.probe.environ[v.Name] = NewValue(1, s.dialect.intType())
+			}
+		}
+		return true
+	})
+
+	stmts := statements(n)
+	ret := make([]Node, len(stmts))
+	for i, stmt := range stmts {
+		switch stmt.Type() {
+		case NodeIf, NodeFor:
+			// the types of the variables depend on the
+			// path taken, they are not known after it.
+			ret[i] = stmt
+			s.forget(stmt)
+			continue
+		case NodeFuncDecl, NodeMacroDecl, NodeEnum:
+			ret[i] = stmt
+		default:
+			ret[i] = Rewrite(Rewrite(stmt, s.rewrite), s.untype)
+		}
+		if _, err := s.probe.Eval(stmt); err != nil {
+			s.forget(stmt)
+		}
+	}
+
+	if list, ok := n.(StmtList); ok {
+		list.Stmts = ret
+		return list
+	}
+	return ret[0]
+}
+
+// forget the variables assigned by the statement, the
+// expressions using them will have unknown types.
+func (s simplifier) forget(stmt Node) {
+	Inspect(stmt, func(n Node) bool {
+		switch n := n.(type) {
+		case Assign:
+			delete(s.probe.environ, n.Varname)
+		case SliceAssign:
+			delete(s.probe.environ, n.Varname)
+		}
+		return true
+	})
+}
+
+// rewrite applies the rules to n until none applies, the
+// children of n are already simplified.
+func (s simplifier) rewrite(n Node) Node {
+	for i := 0; i < maxSteps; i++ {
+		next, ok := s.step(n)
+		if !ok {
+			break
+		}
+		n = next
+	}
+	return n
+}
+
+// step applies one rule to n, it tells if any applied.
+func (s simplifier) step(n Node) (Node, bool) {
+	if c, ok := s.fold(n); ok {
+		return c, true
+	}
+
+	switch n := n.(type) {
+	case UnaryExpr:
+		if n.Op == OpPOS {
+			return s.replace(n, n.Value)
+		}
+		// ~~x and - -x, in JavaScript the unary operations
+		// wrap in the type of x and then convert to numbers.
+		val, ok := n.Value.(UnaryExpr)
+		if !ok || val.Op != n.Op || (n.Op != OpNOT && n.Op != OpNEG) {
+			break
+		}
+		if _, ok := s.sameType(val, val.Value); ok || s.dialect != LangJS {
+			return s.replace(n, val.Value)
+		}
+	case CondExpr:
+		if cond, ok := s.constant(n.Cond); ok {
+			if cond.Sign() != 0 {
+				return s.replace(n, n.Then)
+			}
+			return s.replace(n, n.Else)
+		}
+	case BinExpr:
+		return s.binary(n)
+	}
+	return n, false
+}
+
+// fold evaluates the constant expressions, the ones without
+// variables or calls of functions other than the builtins.
+// The concatenations are kept, as they are part of the
+// replications.
+func (s simplifier) fold(n Node) (Node, bool) {
+	switch n.Type() {
+	case NodeInt, NodeConcat, NodeAssign, NodeSliceAssign, NodeStmtList:
+		return n, false
+	}
+
+	// the results of bitwise operations on hexadecimal
+	// literals are in hexadecimal too.
+	constant, hex := true, false
+	Inspect(n, func(n Node) bool {
+		switch n := n.(type) {
+		case Var:
+			constant = false
+		case Call:
+			if _, ok := builtins[n.Name]; !ok {
+				constant = false
+			}
+		case Int:
+			lit := strings.ToLower(n.Lit)
+			hex = hex || strings.HasPrefix(lit, "0x")
+		}
+		return constant
+	})
+	if !constant {
+		return n, false
+	}
+
+	val, err := s.probe.Eval(n)
+	if err != nil {
+		return n, false
+	}
+	switch n := n.(type) {
+	case BinExpr:
+		hex = hex && isBitwiseOP(n.Op)
+	case UnaryExpr:
+		hex = hex && n.Op == OpNOT
+	default:
+		hex = false
+	}
+	return s.number(val, hex), true
+}
+
+// number returns the Int with the value, the literal is in
+// hexadecimal if hex and the value is not negative.
+func (s simplifier) number(val Value, hex bool) Int {
+	ret := newInt(val.Big())
+	ret.Typ = val.Type
+	if hex && val.Sign() >= 0 {
+		ret.Lit = "0x" + val.Text(16)
+	}
+	return ret
+}
+
+// untype removes the types of the folded constants of the
+// operations that are given by the other operand, as in x & 0xf0
+// from x & 0xff & 0xf0, so they are formatted without casts.
+func (s simplifier) untype(n Node) Node {
+	bin, ok := n.(BinExpr)
+	if !ok {
+		return n
+	}
+	switch bin.Op {
+	case OpLAND, OpLOR:
+	case OpSHL, OpSHR, OpUSHR:
+		// only the value of the count is used
+		bin.Rhs = s.untyped(bin.Rhs, nil)
+	default:
+		bin.Lhs = s.untyped(bin.Lhs, bin.Rhs)
+		bin.Rhs = s.untyped(bin.Rhs, bin.Lhs)
+	}
+	return bin
+}
+
+// untyped returns n without its type if it is an Int with the
+// value of its literal, converted to the type of other by the
+// operations. Negative numbers are negations when parsed, they
+// keep their types.
+func (s simplifier) untyped(n, other Node) Node {
+	i, ok := n.(Int)
+	if !ok || i.Typ == (Type{}) || i.Val.Sign() < 0 {
+		return n
+	}
+	if i.Lit != "" {
+		if lit, err := parseInt(i.Lit, s.dialect); err != nil || lit.Typ != (Type{}) {
+			return n
+		}
+	}
+
+	lit := Int{Val: i.Val, Lit: i.Lit}
+	if !s.dialect.literalType(lit).fits(lit.Val) {
+		return n
+	}
+	if other == nil {
+		return lit
+	}
+
+	t, ok := s.typeOf(other)
+	if !ok || t != i.Typ {
+		return n
+	}
+	sum, ok := s.typeOf(BinExpr{Op: OpADD, Lhs: Cast{To: t, Value: newInt(big.NewInt(0))}, Rhs: lit})
+	if !ok || sum != t {
+		return n
+	}
+	return lit
+}
+
+// constant returns the value of n if it is an Int.
+func (s simplifier) constant(n Node) (*big.Int, bool) {
+	i, ok := n.(Int)
+	if !ok {
+		return nil, false
+	}
+	return s.probe.evalInt(i).int(), true
+}
+
+// mask returns the value of n if it is an Int not negative.
+// In JavaScript the masks are converted to 32 bits signed, so
+// they only have up to 31 bits.
+func (s simplifier) mask(n Node) (*big.Int, bool) {
+	c, ok := s.constant(n)
+	if !ok || c.Sign() < 0 || (s.dialect == LangJS && c.BitLen() > 31) {
+		return nil, false
+	}
+	return c, true
+}
+
+// commutes tells if the operands of op could be swapped.
+func commutes(op Optype) bool {
+	switch op {
+	case OpAND, OpOR, OpXOR, OpADD, OpMUL:
+		return true
+	}
+	return false
+}
+
+func (s simplifier) binary(n BinExpr) (Node, bool) {
+	_, lconst := n.Lhs.(Int)
+	_, rconst := n.Rhs.(Int)
+	if commutes(n.Op) && lconst && !rconst {
+		// the constants go to the right: 1 | x is x | 1
+		n.Lhs, n.Rhs = n.Rhs, n.Lhs
+		return n, true
+	}
+
+	if ret, ok := s.same(n); ok {
+		return ret, true
+	}
+	if ret, ok := s.complement(n); ok {
+		return ret, true
+	}
+	if ret, ok := s.absorb(n); ok {
+		return ret, true
+	}
+
+	c, ok := s.constant(n.Rhs)
+	if !ok {
+		return n, false
+	}
+	if ret, ok := s.identity(n, c); ok {
+		return ret, true
+	}
+	if ret, ok := s.merge(n); ok {
+		return ret, true
+	}
+	if n.Op == OpAND {
+		return s.and(n)
+	}
+	return n, false
+}
+
+// same simplifies the operations on the same operands, like
+// x & x to x and x ^ x to 0.
+func (s simplifier) same(n BinExpr) (Node, bool) {
+	if !EqualNodes(n.Lhs, n.Rhs) {
+		return n, false
+	}
+	switch n.Op {
+	case OpAND, OpOR:
+		return s.replace(n, n.Lhs)
+	case OpXOR, OpSUB, OpANDNOT:
+		return s.zero(n)
+	}
+	return n, false
+}
+
+// complement simplifies x & ~x to 0, and x | ~x and x ^ ~x
+// to all ones.
+func (s simplifier) complement(n BinExpr) (Node, bool) {
+	not := func(a, b Node) bool {
+		u, ok := b.(UnaryExpr)
+		return ok && u.Op == OpNOT && EqualNodes(a, u.Value)
+	}
+	if !not(n.Lhs, n.Rhs) && !not(n.Rhs, n.Lhs) {
+		return n, false
+	}
+
+	switch n.Op {
+	case OpAND:
+		return s.zero(n)
+	case OpOR, OpXOR:
+		t, ok := s.typeOf(n)
+		if !ok {
+			return n, false
+		}
+		return s.number(NewValue(-1, t), false), true
+	}
+	return n, false
+}
+
+// absorb simplifies x & (x | y) and x | (x & y) to x, in
+// any order of the operands.
+func (s simplifier) absorb(n BinExpr) (Node, bool) {
+	var inner Optype
+	switch n.Op {
+	case OpAND:
+		inner = OpOR
+	case OpOR:
+		inner = OpAND
+	default:
+		return n, false
+	}
+
+	absorbs := func(x, y Node) bool {
+		bin, ok := y.(BinExpr)
+		if !ok || bin.Op != inner {
+			return false
+		}
+		_, ok = s.sameType(n, bin)
+		return ok && (EqualNodes(x, bin.Lhs) || EqualNodes(x, bin.Rhs))
+	}
+	switch {
+	case absorbs(n.Lhs, n.Rhs):
+		return s.replace(n, n.Lhs)
+	case absorbs(n.Rhs, n.Lhs):
+		return s.replace(n, n.Rhs)
+	}
+	return n, false
+}
+
+// identity removes the operations with the constant c that
+// do nothing, like x | 0, and the ones always zero, like
+// x & 0.
+func (s simplifier) identity(n BinExpr, c *big.Int) (Node, bool) {
+	switch n.Op {
+	case OpOR, OpXOR, OpADD, OpSUB, OpANDNOT, OpSHL, OpSHR:
+		if c.Sign() == 0 {
+			return s.replace(n, n.Lhs)
+		}
+	case OpUSHR:
+		// the >>> of JavaScript converts to unsigned
+		if c.Sign() == 0 && s.dialect != LangJS {
+			return s.replace(n, n.Lhs)
+		}
+	case OpMUL:
+		switch {
+		case c.Sign() == 0:
+			return s.zero(n)
+		case c.Cmp(big.NewInt(1)) == 0:
+			return s.replace(n, n.Lhs)
+		}
+	case OpAND:
+		if c.Sign() == 0 {
+			return s.zero(n)
+		}
+		t, ok := s.typeOf(n)
+		if !ok {
+			return n, false
+		}
+		if s.dialect == LangJS {
+			t = I32
+		}
+		// all the bits of the type are set
+		if t.Bits == 0 && c.Cmp(big.NewInt(-1)) == 0 ||
+			t.Bits != 0 && t.Unsigned().wrap(c).Cmp(ones(t.Bits)) == 0 {
+			return s.replace(n, n.Lhs)
+		}
+	}
+	return n, false
+}
+
+// merge combines the constants of (x op c1) op c2 to
+// x op (c1 op c2), and the counts of (x << c1) << c2 to
+// x << (c1 + c2) when the sum is less than the width.
+func (s simplifier) merge(n BinExpr) (Node, bool) {
+	lhs, ok := n.Lhs.(BinExpr)
+	if !ok || lhs.Op != n.Op {
+		return n, false
+	}
+	if _, ok := lhs.Rhs.(Int); !ok {
+		return n, false
+	}
+
+	switch n.Op {
+	case OpAND, OpOR, OpXOR, OpADD:
+		// the constants are combined in the type of n, so
+		// the operations must not change types in between.
+		t, ok := s.sameType(n, lhs)
+		if !ok {
+			return n, false
+		}
+		c := s.rewrite(BinExpr{
+			Op:  n.Op,
+			Lhs: Cast{To: t, Value: lhs.Rhs},
+			Rhs: Cast{To: t, Value: n.Rhs},
+		})
+		return s.replace(n, BinExpr{Op: n.Op, Lhs: lhs.Lhs, Rhs: c})
+	case OpSHL, OpSHR, OpUSHR:
+	default:
+		return n, false
+	}
+
+	a, _ := s.constant(lhs.Rhs)
+	b, _ := s.constant(n.Rhs)
+	if a.Sign() < 0 || b.Sign() < 0 {
+		return n, false
+	}
+
+	t, ok := s.typeOf(lhs)
+	if !ok {
+		return n, false
+	}
+	width := int64(t.Bits)
+	switch {
+	case s.dialect == LangJS:
+		width = 32
+	case width == 0:
+		width = maxShift + 1
+	}
+
+	count := new(big.Int).Add(a, b)
+	if count.Cmp(big.NewInt(width)) >= 0 {
+		return n, false
+	}
+	return s.replace(n, BinExpr{Op: n.Op, Lhs: lhs.Lhs, Rhs: newInt(count)})
+}
+
+// and uses the bits known to be zero in x & c: it is zero if
+// x has no bits of c, and it is x if c has all the bits of
+// x. The sides of (a | b) & c, or a ^ b, that have no bits of
+// c are removed.
+func (s simplifier) and(n BinExpr) (Node, bool) {
+	c, ok := s.mask(n.Rhs)
+	if !ok {
+		return n, false
+	}
+
+	x := s.known(n.Lhs)
+	if x.zeroIn(c) {
+		return s.zero(n)
+	}
+	if x.ones != nil && new(big.Int).AndNot(x.ones, c).Sign() == 0 {
+		return s.replace(n, n.Lhs)
+	}
+
+	lhs, ok := n.Lhs.(BinExpr)
+	if !ok || (lhs.Op != OpOR && lhs.Op != OpXOR) {
+		return n, false
+	}
+	if _, ok := s.sameType(n, lhs); !ok {
+		// the bits of c must be in the width of lhs
+		t, ok := s.typeOf(lhs)
+		if !ok || t.Bits == 0 || uint(c.BitLen()) > t.Bits {
+			return n, false
+		}
+	}
+	if s.known(lhs.Lhs).zeroIn(c) {
+		return s.replace(n, s.rewrite(BinExpr{Op: OpAND, Lhs: lhs.Rhs, Rhs: n.Rhs}))
+	}
+	if s.known(lhs.Rhs).zeroIn(c) {
+		return s.replace(n, s.rewrite(BinExpr{Op: OpAND, Lhs: lhs.Lhs, Rhs: n.Rhs}))
+	}
+	return n, false
+}
+
+// zero returns the zero with the type of n.
+func (s simplifier) zero(n Node) (Node, bool) {
+	t, ok := s.typeOf(n)
+	if !ok {
+		return n, false
+	}
+	return s.number(NewValue(0, t), false), true
+}
+
+// replace returns repl in place of n. If repl has another
+// type it is converted to the type of n, the rules make repl
+// the value of n before the conversions done by the
+// operations of n.
+func (s simplifier) replace(n, repl Node) (Node, bool) {
+	t, ok := s.typeOf(n)
+	if !ok {
+		return n, false
+	}
+	rt, ok := s.typeOf(repl)
+	if !ok || !s.jsSafe(n, repl) {
+		return n, false
+	}
+	if rt != t {
+		repl = Cast{To: t, Value: repl}
+	}
+	return repl, true
+}
+
+// sameType returns the type of a and b, if it is the same.
+// The conversions of the operands of an operation sign extend
+// the signed types, so the values of an inner operation of
+// another type could differ in the bits beyond its width.
+func (s simplifier) sameType(a, b Node) (Type, bool) {
+	t, ok := s.typeOf(a)
+	if !ok {
+		return t, false
+	}
+	u, ok := s.typeOf(b)
+	return t, ok && t == u
+}
+
+// jsSafe tells if the JavaScript numbers of repl are in the
+// range of the results of n, as the bitwise operations
+// convert their numbers to 32 bits.
+func (s simplifier) jsSafe(n, repl Node) bool {
+	if s.dialect != LangJS || !jsBitwise(n) {
+		return true
+	}
+	if c, ok := s.constant(repl); ok {
+		return I32.fits(c)
+	}
+	return jsBitwise(repl)
+}
+
+// jsBitwise tells if n is a JavaScript bitwise operation,
+// with a 32 bits signed result.
+func jsBitwise(n Node) bool {
+	switch n := n.(type) {
+	case BinExpr:
+		return isBitwiseOP(n.Op) && n.Op != OpUSHR
+	case UnaryExpr:
+		return n.Op == OpNOT
+	}
+	return false
+}
+
+// typeOf returns the type of n, evaluated by the probe. The
+// type is unknown if it depends on the values, like in calls
+// of functions and conditional expressions with branches of
+// different types.
+func (s simplifier) typeOf(n Node) (Type, bool) {
+	known := true
+	Inspect(n, func(n Node) bool {
+		switch n := n.(type) {
+		case Call:
+			if _, ok := builtins[n.Name]; !ok {
+				known = false
+			}
+		case Replicate:
+			if _, ok := n.Count.(Int); !ok {
+				known = false
+			}
+		case CondExpr:
+			then, ok1 := s.typeOf(n.Then)
+			els, ok2 := s.typeOf(n.Else)
+			if !ok1 || !ok2 || then != els {
+				known = false
+			}
+		}
+		return known
+	})
+	if !known {
+		return Type{}, false
+	}
+
+	val, err := s.probe.Eval(n)
+	if err != nil {
+		return Type{}, false
+	}
+	return val.Type, true
+}
+
+// knownBits are the facts known about the bits of a value: ones is
+// nil or has all the bits that could be set, so the value is
+// not negative, and zeros is the number of trailing bits that
+// are zero.
+type knownBits struct {
+	ones  *big.Int
+	zeros uint
+}
+
+// zeroIn tells if all the bits of the mask are zero.
+func (b knownBits) zeroIn(mask *big.Int) bool {
+	rest := new(big.Int).Rsh(mask, b.zeros)
+	if rest.Sign() == 0 {
+		return true
+	}
+	rest.Lsh(rest, b.zeros)
+	return b.ones != nil && rest.And(rest, b.ones).Sign() == 0
+}
+
+// ones returns the mask of the n low bits.
+func ones(n uint) *big.Int {
+	mask := new(big.Int).Lsh(big.NewInt(1), n)
+	return mask.Sub(mask, big.NewInt(1))
+}
+
+// known returns the bits known of n.
+func (s simplifier) known(n Node) knownBits {
+	switch n := n.(type) {
+	case Int:
+		c, ok := s.mask(n)
+		if !ok {
+			return knownBits{}
+		}
+		if c.Sign() == 0 {
+			return knownBits{ones: c, zeros: noZeros}
+		}
+		return knownBits{ones: c, zeros: c.TrailingZeroBits()}
+	case Var:
+		if s.dialect == LangJS {
+			return knownBits{}
+		}
+		t, ok := s.typeOf(n)
+		if !ok || t.Bits == 0 || t.Signed {
+			return knownBits{}
+		}
+		return knownBits{ones: ones(t.Bits)}
+	case Slice:
+		hi, ok1 := s.constant(n.Hi)
+		lo, ok2 := s.constant(n.Lo)
+		switch {
+		case !ok1:
+			return knownBits{}
+		case n.Lo == nil:
+			return knownBits{ones: big.NewInt(1)}
+		case ok2 && hi.Cmp(lo) >= 0 && hi.IsUint64() && lo.IsUint64():
+			return knownBits{ones: ones(uint(hi.Uint64() - lo.Uint64() + 1))}
+		}
+		return knownBits{}
+	case Cast:
+		return s.castBits(n)
+	case BinExpr:
+		return s.binaryBits(n)
+	}
+	return knownBits{}
+}
+
+func (s simplifier) castBits(n Cast) knownBits {
+	x := s.known(n.Value)
+	if n.To.Bits == 0 {
+		return x
+	}
+	if x.zeros > n.To.Bits {
+		x.zeros = n.To.Bits
+	}
+
+	switch {
+	case !n.To.Signed && x.ones != nil:
+		x.ones = new(big.Int).And(x.ones, ones(n.To.Bits))
+	case !n.To.Signed:
+		x.ones = ones(n.To.Bits)
+	case x.ones != nil && uint(x.ones.BitLen()) >= n.To.Bits:
+		x.ones = nil
+	}
+	return x
+}
+
+func (s simplifier) binaryBits(n BinExpr) knownBits {
+	x := s.known(n.Lhs)
+	switch n.Op {
+	case OpSHL, OpSHR, OpUSHR:
+		k, ok := s.mask(n.Rhs)
+		if !ok || !k.IsUint64() || k.Uint64() > maxShift {
+			return knownBits{}
+		}
+		return s.shiftBits(n, x, uint(k.Uint64()))
+	}
+
+	y := s.known(n.Rhs)
+	ret := knownBits{zeros: minZeros(x.zeros, y.zeros)}
+	switch n.Op {
+	case OpAND:
+		ret.zeros = x.zeros + y.zeros - ret.zeros
+		switch {
+		case x.ones != nil && y.ones != nil:
+			ret.ones = new(big.Int).And(x.ones, y.ones)
+		case x.ones != nil:
+			ret.ones = x.ones
+		default:
+			ret.ones = y.ones
+		}
+	case OpOR, OpXOR:
+		if x.ones != nil && y.ones != nil {
+			ret.ones = new(big.Int).Or(x.ones, y.ones)
+		}
+	case OpADD, OpSUB:
+	case OpMUL:
+		ret.zeros = minZeros(x.zeros+y.zeros, noZeros)
+	default:
+		return knownBits{}
+	}
+	return ret
+}
+
+func minZeros(a, b uint) uint {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// shiftBits returns the bits of x shifted by k. The ones
+// shifted left must fit the type, or they could turn the
+// value negative.
+func (s simplifier) shiftBits(n BinExpr, x knownBits, k uint) knownBits {
+	t, ok := s.typeOf(n)
+	if !ok {
+		return knownBits{}
+	}
+	switch s.dialect {
+	case LangJS:
+		t = I32
+		fallthrough
+	case LangJava:
+		// the count is masked by the width
+		k &= t.Bits - 1
+	}
+
+	if n.Op != OpSHL {
+		if x.zeros != noZeros {
+			x.zeros -= minZeros(x.zeros, k)
+		}
+		if x.ones != nil {
+			x.ones = new(big.Int).Rsh(x.ones, k)
+		}
+		return x
+	}
+
+	x.zeros = minZeros(x.zeros+k, noZeros)
+	if t.Bits != 0 {
+		x.zeros = minZeros(x.zeros, t.Bits)
+	}
+
+	if x.ones != nil {
+		x.ones = new(big.Int).Lsh(x.ones, k)
+
+		width := t.Bits
+		if t.Signed {
+			width--
+		}
+		if t.Bits != 0 && uint(x.ones.BitLen()) > width {
+			x.ones = nil
+		}
+	}
+	return x
+}
@@ -0,0 +1,284 @@
+package bwc
+
+import (
+	"fmt"
+	"math/big"
+	"math/rand"
+	"testing"
+)
+
+func TestSimplify(t *testing.T) {
+	for _, tc := range []struct {
+		code    string
+		dialect Dialect
+		out     string
+	}{
+		{code: "((X | (X << 16)) & 0x0000ffff0000ffff) & 0xffff", out: "X & i64(0xffff)"},
+		{code: "((X | (X << 16)) & 0x0000ffff0000ffff) & 0xffff", dialect: LangGo,
+			out: "X & 0xffff"},
+		{code: "1 + 2 * 3", out: "7"},
+		{code: "0xf0 | 0x0f", out: "0xff"},
+		{code: "popcount(0xff) + x", out: "x + 8"},
+		{code: "3 | x", out: "x | 3"},
+		{code: "x | 0", out: "x"},
+		{code: "x * 1 + 0", out: "x"},
+		{code: "x * 0", out: "0"},
+		{code: "x & 0xffffffff", out: "u32(x)"},
+		{code: "x & -1", out: "x"},
+		{code: "x ^ x", out: "0"},
+		{code: "x & x | y - y", out: "x"},
+		{code: "x & ~x", out: "0"},
+		{code: "~x & x", out: "0"},
+		{code: "x | ~x", out: "-1"},
+		{code: "~~x + - -y", out: "x + y"},
+		{code: "x & (x | y)", out: "x"},
+		{code: "(x & y) | x", out: "x"},
+		{code: "x & 0xff & 0xf0", out: "x & 0xf0"},
+		{code: "(x | 1) | 6", out: "x | 7"},
+		{code: "x << 2 << 3", out: "x << 5"},
+		{code: "x >> 30 >> 2", out: "x >> 30 >> 2"},
+		{code: "(x << 8 | y) & 0xff", out: "y & 0xff"},
+		{code: "(x & 0xf) << 4 & 0xf", out: "0"},
+		{code: "x[7:0] & 0xff", out: "x[7:0]"},
+		{code: "(x & 0xf0) & 0xff", out: "x & 0xf0"},
+		{code: "x | (uint64_t)0", out: "u64(x)"},
+		{code: "x - (uint64_t)1", out: "x - u64(1)"},
+		{code: "x + (uint64_t)1 + 1", out: "x + u64(2)"},
+		{code: "x + (1 << 31)", out: "x + i32(-2147483648)"},
+		{code: "1 ? x : y", out: "x"},
+		{code: "y = 2 + 2; x & (y - y)", out: "y = 4\n0"},
+		{code: "u8(x) & 0xff", dialect: LangGo, out: "uint8(x)"},
+		{code: "y := u8(x); y & 0xff", dialect: LangGo, out: "y := uint8(x)\ny"},
+		{code: "x &^ x", dialect: LangGo, out: "int(0)"},
+		{code: "x & 0xff & 0xff00", dialect: LangPython, out: "0"},
+		{code: "x << 40 << 40", dialect: LangPython, out: "x << 80"},
+		{code: "x << 20 << 20", dialect: LangJava, out: "x << 20 << 20"},
+		{code: "x >>> 1 >>> 2", dialect: LangJava, out: "x >>> 3"},
+		{code: "x | 0", dialect: LangJS, out: "x | 0"},
+		{code: "~~x", dialect: LangJS, out: "~ ~x"},
+		{code: "(x | 0) | 0", dialect: LangJS, out: "x | 0"},
+		{code: "x & 0xffffffff", dialect: LangJS, out: "x & 0xffffffff"},
+		{code: "fn f(a) = a | 0", out: "fn f(a) = a | 0"},
+	} {
+		n, err := Parse(tc.code, Lang(tc.dialect))
+		if err != nil {
+			t.Fatalf("%q: %s", tc.code, err)
+		}
+		got := Format(Simplify(n, Lang(tc.dialect)), Lang(tc.dialect))
+		if got != tc.out {
+			t.Fatalf("%s: %q: got:\n%s\nexpected:\n%s", tc.dialect, tc.code, got, tc.out)
+		}
+	}
+}
+
+func TestInterpSimplifyMacros(t *testing.T) {
+	e := NewInterp()
+	if _, err := e.Exec("#define P 4"); err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := e.Simplify("#define Q 5\nx | P + Q")
+	if err != nil {
+		t.Fatal(err)
+	}
+	const out = "#define Q 5\nx | 9"
+	if got := Format(n); got != out {
+		t.Fatalf("got %q, expected %q", got, out)
+	}
+
+	if _, err := e.Exec("Q"); err == nil || err.Error() != "undefined variable Q" {
+		t.Fatalf("the macro Q was defined by Simplify: %v", err)
+	}
+	if _, err := e.Exec("P"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// exprgen generates random expressions on the variables a and
+// b, reusing the expressions already made, so there are
+// operations on the same operands to simplify.
+type exprgen struct {
+	rnd     *rand.Rand
+	dialect Dialect
+	binops  []Optype
+	shifts  []Optype
+	pool    []Node
+}
+
+func newExprgen(seed int64, d Dialect) *exprgen {
+	g := &exprgen{
+		rnd:     rand.New(rand.NewSource(seed)),
+		dialect: d,
+		binops:  []Optype{OpAND, OpAND, OpOR, OpOR, OpXOR, OpADD, OpSUB, OpMUL},
+		shifts:  []Optype{OpSHL, OpSHR},
+	}
+	switch d {
+	case LangGo:
+		g.binops = append(g.binops, OpANDNOT)
+	case LangJava, LangJS:
+		g.shifts = append(g.shifts, OpUSHR)
+	}
+	return g
+}
+
+func (g *exprgen) expr(depth int) Node {
+	if depth == 0 || g.rnd.Intn(6) == 0 {
+		return g.leaf()
+	}
+	if len(g.pool) > 0 && g.rnd.Intn(4) == 0 {
+		return g.pool[g.rnd.Intn(len(g.pool))]
+	}
+
+	var n Node
+	switch g.rnd.Intn(10) {
+	case 0:
+		n = UnaryExpr{
+			Op:    []Optype{OpNOT, OpNEG}[g.rnd.Intn(2)],
+			Value: g.expr(depth - 1),
+		}
+	case 1, 2:
+		n = BinExpr{
+			Op:  g.shifts[g.rnd.Intn(len(g.shifts))],
+			Lhs: g.expr(depth - 1),
+			Rhs: NewInt(g.rnd.Int63n(40)),
+		}
+	case 3:
+		n = Cast{
+			To:    []Type{U8, U16, I32, U64}[g.rnd.Intn(4)],
+			Value: g.expr(depth - 1),
+		}
+	case 4:
+		lo := g.rnd.Int63n(16)
+		n = Slice{
+			Value: g.expr(depth - 1),
+			Hi:    NewInt(lo + g.rnd.Int63n(16)),
+			Lo:    NewInt(lo),
+		}
+	case 5:
+		if g.dialect.ternary() || g.dialect == LangPython {
+			n = CondExpr{
+				Cond: g.expr(depth - 1),
+				Then: g.expr(depth - 1),
+				Else: g.expr(depth - 1),
+			}
+			break
+		}
+		fallthrough
+	default:
+		n = BinExpr{
+			Op:  g.binops[g.rnd.Intn(len(g.binops))],
+			Lhs: g.expr(depth - 1),
+			Rhs: g.expr(depth - 1),
+		}
+	}
+	g.pool = append(g.pool, n)
+	return n
+}
+
+func (g *exprgen) leaf() Node {
+	if g.rnd.Intn(2) == 0 {
+		return Var{Name: []string{"a", "b"}[g.rnd.Intn(2)]}
+	}
+
+	var val *big.Int
+	switch g.rnd.Intn(4) {
+	case 0:
+		val = big.NewInt(g.rnd.Int63n(3))
+	case 1:
+		// masks of low bits, like 0xff
+		val = ones(uint(g.rnd.Intn(65)))
+	case 2:
+		val = new(big.Int).Lsh(ones(uint(g.rnd.Intn(33))), uint(g.rnd.Intn(33)))
+	default:
+		val = big.NewInt(g.rnd.Int63n(1 << 16))
+	}
+	return Int{Val: val, Lit: fmt.Sprintf("0x%x", val)}
+}
+
+// value returns a random value of type t, biased to the
+// edges of its range.
+func (g *exprgen) value(t Type) Value {
+	var x *big.Int
+	switch g.rnd.Intn(4) {
+	case 0:
+		x = big.NewInt(g.rnd.Int63n(5) - 2)
+	case 1:
+		x = new(big.Int).Lsh(big.NewInt(1), uint(g.rnd.Intn(64)))
+		x.Add(x, big.NewInt(g.rnd.Int63n(3)-1))
+	default:
+		x = big.NewInt(g.rnd.Int63())
+	}
+	if g.rnd.Intn(2) == 0 {
+		x.Neg(x)
+	}
+	return newValue(x, t)
+}
+
+func TestSimplifyEquivalence(t *testing.T) {
+	dialects := []Dialect{LangC, LangGo, LangRust, LangJava, LangJS, LangPython}
+	for _, d := range dialects {
+		g := newExprgen(int64(d), d)
+		e := NewInterp(Lang(d))
+
+		types := []Type{d.intType()}
+		if d.intType() != Unbounded {
+			types = append(types, U8, U32, I64, U64)
+		}
+
+		simplified := 0
+		for i := 0; i < 3000; i++ {
+			if i%100 == 0 {
+				g.pool = nil
+			}
+			code := Format(g.expr(4), Lang(d))
+			n, err := Parse(code, Lang(d))
+			if err != nil {
+				t.Fatalf("%s: parsing %q: %s", d, code, err)
+			}
+
+			typ := types[g.rnd.Intn(len(types))]
+			e.environ["a"] = g.value(typ)
+			e.environ["b"] = g.value(typ)
+
+			simple, err := e.Simplify(code)
+			if err != nil {
+				t.Fatalf("%s: simplifying %q: %s", d, code, err)
+			}
+			if !EqualNodes(simple, n) {
+				simplified++
+			}
+			// the code formatted must have the same types
+			formatted := Format(simple, Lang(d))
+			reparsed, err := Parse(formatted, Lang(d))
+			if err != nil {
+				t.Fatalf("%s: %q simplified to %q: %s", d, code, formatted, err)
+			}
+
+			for j := 0; j < 8; j++ {
+				e.environ["a"] = g.value(typ)
+				e.environ["b"] = g.value(typ)
+
+				expected, err := e.Eval(n)
+				if err != nil {
+					continue
+				}
+				for _, simple := range []Node{simple, reparsed} {
+					got, err := e.Eval(simple)
+					if err != nil {
+						t.Fatalf("%s: %q simplified to %q, a=%s b=%s (%s): %s",
+							d, code, formatted, e.environ["a"],
+							e.environ["b"], typ, err)
+					}
+					if got.Type != expected.Type || got.int().Cmp(expected.int()) != 0 {
+						t.Fatalf("%s: %q = %s (%s), simplified to %q = %s (%s), a=%s b=%s",
+							d, code, expected, expected.Type, formatted,
+							got, got.Type, e.environ["a"], e.environ["b"])
+					}
+				}
+			}
+		}
+		if simplified < 1000 {
+			t.Fatalf("%s: only %d expressions were simplified", d, simplified)
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package bwc
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+)
+
+// Loc returns the span, the nodes embedding a Span have their
+// code location.
+func (s Span) Loc() Span { return s }
+
+// lineTable maps offsets of the code to positions.
+type lineTable struct {
+	starts []int // starts are the offsets of the lines
+	size   int
+}
+
+func newLineTable(code string) lineTable {
+	lines := lineTable{
+		starts: []int{0},
+		size:   len(code),
+	}
+	for i := 0; i < len(code); i++ {
+		if code[i] == '\n' {
+			lines.starts = append(lines.starts, i+1)
+		}
+	}
+	return lines
+}
+
+// position of the offset, offsets out of the code are the
+// nearest end of the code.
+func (l lineTable) position(offset int) Position {
+	if offset < 0 {
+		offset = 0
+	}
+	if offset > l.size {
+		offset = l.size
+	}
+
+	line := sort.Search(len(l.starts), func(i int) bool {
+		return l.starts[i] > offset
+	})
+	return Position{
+		Offset: offset,
+		Line:   line,
+		Column: offset - l.starts[line-1] + 1,
+	}
+}
+
+// span returns the span of the code [start, end).
+func (l lineTable) span(start, end int) Span {
+	return Span{
+		Start: l.position(start),
+		End:   l.position(end),
+	}
+}
+
+// withSpan returns n with the span s.
+func withSpan(n Node, s Span) Node {
+	switch n := n.(type) {
+	case Int:
+		n.Span = s
+		return n
+	case Var:
+		n.Span = s
+		return n
+	case UnaryExpr:
+		n.Span = s
+		return n
+	case BinExpr:
+		n.Span = s
+		return n
+	case CondExpr:
+		n.Span = s
+		return n
+	case Call:
+		n.Span = s
+		return n
+	case FuncDecl:
+		n.Span = s
+		return n
+	case Return:
+		n.Span = s
+		return n
+	case If:
+		n.Span = s
+		return n
+	case For:
+		n.Span = s
+		return n
+	case MacroDecl:
+		n.Span = s
+		return n
+	case Enum:
+		n.Span = s
+		return n
+	case Slice:
+		n.Span = s
+		return n
+	case Concat:
+		n.Span = s
+		return n
+	case Replicate:
+		n.Span = s
+		return n
+	case SliceAssign:
+		n.Span = s
+		return n
+	case Cast:
+		n.Span = s
+		return n
+	case Assign:
+		n.Span = s
+		return n
+	case StmtList:
+		n.Span = s
+		return n
+	}
+	panic(fmt.Sprintf("invalid node: %T", n))
+}
+
+// EqualNodes tells if the nodes are the same, ignoring where they
+// are in the code and how the integer literals are written, so
+// 0xff and 255 are equal.
+func EqualNodes(a, b Node) bool {
+	return reflect.DeepEqual(withoutSpans(reflect.ValueOf(a)),
+		withoutSpans(reflect.ValueOf(b)))
+}
+
+var (
+	spanType = reflect.TypeOf(Span{})
+	intType  = reflect.TypeOf(Int{})
+)
+
+// withoutSpans returns a copy of v with all the spans and
+// literals zeroed.
+func withoutSpans(v reflect.Value) interface{} {
+	if !v.IsValid() {
+		return nil
+	}
+	return clearSpans(v).Interface()
+}
+
+func clearSpans(v reflect.Value) reflect.Value {
+	switch v.Kind() {
+	case reflect.Interface:
+		if v.IsNil() {
+			return v
+		}
+		ret := reflect.New(v.Type()).Elem()
+		ret.Set(clearSpans(v.Elem()))
+		return ret
+	case reflect.Slice:
+		if v.IsNil() {
+			return v
+		}
+		ret := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		for i := 0; i < v.Len(); i++ {
+			ret.Index(i).Set(clearSpans(v.Index(i)))
+		}
+		return ret
+	case reflect.Struct:
+		if v.Type() == spanType {
+			return reflect.Zero(spanType)
+		}
+		ret := reflect.New(v.Type()).Elem()
+		ret.Set(v)
+		for i := 0; i < v.NumField(); i++ {
+			if ret.Field(i).CanSet() {
+				ret.Field(i).Set(clearSpans(v.Field(i)))
+			}
+		}
+		if v.Type() == intType {
+			ret.FieldByName("Lit").SetString("")
+		}
+		return ret
+	}
+	return v
+}
@@ -0,0 +1,109 @@
+package bwc
+
+import (
+	"testing"
+)
+
+// spanText returns the code of the node span.
+func spanText(code string, n Node) string {
+	span := n.Loc()
+	return code[span.Start.Offset:span.End.Offset]
+}
+
+func TestSpans(t *testing.T) {
+	code := "x = 0xff\ny = ~x[7:4] + (x | 1)\nfn f(a) { a; a << 1 }\nc ? 1 : 2"
+	n, err := Parse(code)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stmts := n.(StmtList).Stmts
+	assign := stmts[1].(Assign)
+	sum := assign.Expr.(BinExpr)
+	fn := stmts[2].(FuncDecl)
+
+	for _, tc := range []struct {
+		node Node
+		text string
+	}{
+		{node: n, text: code},
+		{node: stmts[0], text: "x = 0xff"},
+		{node: stmts[0].(Assign).Expr, text: "0xff"},
+		{node: assign, text: "y = ~x[7:4] + (x | 1)"},
+		{node: sum, text: "~x[7:4] + (x | 1)"},
+		{node: sum.Lhs, text: "~x[7:4]"},
+		{node: sum.Lhs.(UnaryExpr).Value, text: "x[7:4]"},
+		{node: sum.Lhs.(UnaryExpr).Value.(Slice).Lo, text: "4"},
+		{node: sum.Rhs, text: "(x | 1)"},
+		{node: fn, text: "fn f(a) { a; a << 1 }"},
+		{node: fn.Body, text: "a; a << 1"},
+		{node: stmts[3], text: "c ? 1 : 2"},
+	} {
+		if got := spanText(code, tc.node); got != tc.text {
+			t.Fatalf("%s: got span %q != expected %q", tc.node, got, tc.text)
+		}
+	}
+
+	got := sum.Rhs.Loc()
+	expected := Span{
+		Start: Position{Offset: 23, Line: 2, Column: 15},
+		End:   Position{Offset: 30, Line: 2, Column: 22},
+	}
+	if got != expected {
+		t.Fatalf("got span %+v != expected %+v", got, expected)
+	}
+}
+
+func TestGoSpans(t *testing.T) {
+	code := "func f(x uint8) uint8 {\n\tx |= 1\n\treturn x << 1\n}"
+	n, err := Parse(code, Lang(LangGo))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fn := n.(FuncDecl)
+	stmts := fn.Body.(StmtList).Stmts
+	for _, tc := range []struct {
+		node Node
+		text string
+	}{
+		{node: fn, text: code},
+		{node: stmts[0], text: "x |= 1"},
+		{node: stmts[1], text: "return x << 1"},
+		{node: stmts[1].(Return).Value, text: "x << 1"},
+	} {
+		if got := spanText(code, tc.node); got != tc.text {
+			t.Fatalf("%s: got span %q != expected %q", tc.node, got, tc.text)
+		}
+	}
+
+	if pos := stmts[1].Loc().Start; pos.Line != 3 || pos.Column != 2 {
+		t.Fatalf("got position %+v", pos)
+	}
+}
+
+func TestEqualNodes(t *testing.T) {
+	a, err := Parse("x = 1 +  f(y)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := Parse("x=1+f(y)")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if a.Loc() == b.Loc() {
+		t.Fatal("the spans must differ")
+	}
+	if !EqualNodes(a, b) {
+		t.Fatalf("%s != %s", a, b)
+	}
+
+	c, err := Parse("x = 1 + f(z)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if EqualNodes(a, c) {
+		t.Fatalf("%s == %s", a, c)
+	}
+}
@@ -0,0 +1,600 @@
+package bwc
+
+import (
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+)
+
+// SymValue is a value of a symbolic evaluation, with a
+// formula for each bit on the bits of the variables.
+type SymValue struct {
+	Type Type
+
+	// Bits has the formulas of the bits, from the least
+	// significant. The bits beyond them are copies of the
+	// last one for signed types and zero for unsigned ones,
+	// so the values of fixed types have all their bits.
+	Bits []*Formula
+}
+
+// symVarBits is the width of the symbolic variables of the
+// dialects with unbounded integers.
+const symVarBits = 64
+
+// EvalSymbolic evaluates the code with the variables that are
+// not defined as symbols, instead of failing, and returns the
+// value of the last statement: each of its bits is a formula
+// on the bits of the variables. With X undefined in C, X & 0xf0
+// has the bits 7:4 of X and zero in the others.
+//
+// Only the bitwise operations, with constant shift counts, as
+// well as casts, slices and concatenations, work on symbolic
+// values. The other operations, and the conditions of ifs and
+// loops, need constant values. The symbolic variables have the
+// int type of the dialect, 32 bits in JavaScript and 64 bits
+// for Python integers.
+// The interpreter is not changed.
+func (e *interp) EvalSymbolic(code string) (SymValue, error) {
+	macros := e.copyMacros()
+	n, err := parse(code, e.cfg, macros)
+	if err != nil {
+		return SymValue{}, err
+	}
+
+	// the code runs in a copy of the interpreter, where the
+	// values could be symbolic
+	s := &interp{
+		environ: make(map[string]Value, len(e.environ)),
+		funcs:   make(map[string]FuncDecl, len(e.funcs)),
+		macros:  macros,
+		cfg:     e.cfg,
+		sym:     newFormulas(),
+	}
+	for name, val := range e.environ {
+		s.environ[name] = val
+	}
+	for name, fn := range e.funcs {
+		s.funcs[name] = fn
+	}
+
+	var ret Value
+	for _, stmt := range statements(n) {
+		val, err := s.Eval(stmt)
+		if err != nil {
+			return SymValue{}, err
+		}
+		if !isDecl(stmt) {
+			ret = val
+		}
+	}
+	if ret.val == nil && ret.sym == nil {
+		return SymValue{}, nil
+	}
+	return SymValue{Type: ret.Type, Bits: ret.bits()}, nil
+}
+
+// symVar returns the symbolic variable v, the variables are
+// defined as they are used.
+func (e *interp) symVar(v Var) Value {
+	d := e.cfg.dialect
+	t := fixedType(d, d.intType())
+	width := t.Bits
+	if width == 0 {
+		width = symVarBits
+	}
+
+	bits := make([]*Formula, width)
+	for i := range bits {
+		bits[i] = e.sym.variable(v.Name, uint(i))
+	}
+	val := Value{Type: t, sym: bits}
+	e.environ[v.Name] = val
+	return val
+}
+
+// symbolic returns the value with the bits, it is an integer
+// if all the bits are constant.
+func symbolic(t Type, bits []*Formula) Value {
+	if val, ok := (SymValue{Type: t, Bits: bits}).value(); ok {
+		return val
+	}
+	return Value{Type: t, sym: bits}
+}
+
+// bits returns the formulas of the bits of v. The integers
+// have as many bits as their type, or up to the sign bit.
+func (v Value) bits() []*Formula {
+	if v.sym != nil {
+		return v.sym
+	}
+
+	width := v.Type.Bits
+	if width == 0 {
+		// the sign bit is the last one
+		width = uint(v.int().BitLen()) + 1
+	}
+
+	bits := make([]*Formula, width)
+	for i := range bits {
+		bits[i] = bit(v, uint(i))
+	}
+	return bits
+}
+
+// value returns the value of v, if all its bits are constant.
+func (v SymValue) value() (Value, bool) {
+	x := new(big.Int)
+	for i, bit := range v.Bits {
+		b, ok := bit.Const()
+		if !ok {
+			return Value{}, false
+		}
+		if b {
+			x.SetBit(x, i, 1)
+		}
+	}
+
+	if v.Type.Bits == 0 && x.Bit(len(v.Bits)-1) == 1 {
+		x.Sub(x, new(big.Int).Lsh(big.NewInt(1), uint(len(v.Bits))))
+	}
+	return newValue(x, v.Type), true
+}
+
+// bit returns the bit i of v, beyond the bits of v it is the
+// extension of the sign.
+func bit(v Value, i uint) *Formula {
+	if v.sym == nil {
+		// the integers are in the range of their type, so
+		// their two's complement extends the sign too
+		return constFormula(v.int().Bit(int(i)) == 1)
+	}
+
+	bits := v.sym
+	switch {
+	case i < uint(len(bits)):
+		return bits[i]
+	case v.Type.Signed:
+		return bits[len(bits)-1]
+	}
+	return falseFormula
+}
+
+// convertBits converts the symbolic v to type t, as done by
+// casts.
+func convertBits(v Value, t Type) Value {
+	if t.Bits == 0 {
+		bits := v.bits()
+		if !v.Type.Signed {
+			// a zero sign bit keeps it positive
+			bits = append(bits[:len(bits):len(bits)], falseFormula)
+		}
+		return symbolic(t, bits)
+	}
+
+	bits := make([]*Formula, t.Bits)
+	for i := range bits {
+		bits[i] = bit(v, uint(i))
+	}
+	return symbolic(t, bits)
+}
+
+// varNames returns the names of the variables in the bits of
+// v, separated by commas.
+func (v Value) varNames() string {
+	seen := map[*Formula]bool{}
+	names := map[string]bool{}
+
+	var visit func(f *Formula)
+	visit = func(f *Formula) {
+		if seen[f] {
+			return
+		}
+		seen[f] = true
+		if name, _, ok := f.Var(); ok {
+			names[name] = true
+		}
+		for _, arg := range f.args {
+			visit(arg)
+		}
+	}
+	for _, bit := range v.bits() {
+		visit(bit)
+	}
+
+	var ret []string
+	for name := range names {
+		ret = append(ret, name)
+	}
+	sort.Strings(ret)
+	return strings.Join(ret, ", ")
+}
+
+// needsValue is the error of the operations that need the
+// value of the symbolic v.
+func (e *interp) needsValue(n Node, v Value) error {
+	return fmt.Errorf("%s needs the value of %s",
+		Format(n, Lang(e.cfg.dialect)), v.varNames())
+}
+
+// symUnaryExpr evaluates the unary operation on the symbolic
+// val, only the bitwise not works on the bits.
+func (e *interp) symUnaryExpr(expr UnaryExpr, val Value) (Value, error) {
+	if expr.Op != OpNOT {
+		return Value{}, e.needsValue(expr, val)
+	}
+
+	d := e.cfg.dialect
+	if d == LangJS {
+		val = val.Convert(I32)
+	}
+
+	typ := d.promote(val.Type)
+	val = val.Convert(typ)
+	bits := make([]*Formula, len(val.bits()))
+	for i := range bits {
+		bits[i] = e.sym.not(bit(val, uint(i)))
+	}
+	ret := symbolic(typ, bits)
+
+	if d == LangJS {
+		// javascript has only numbers
+		return ret.Convert(Unbounded), nil
+	}
+	return ret, nil
+}
+
+// symBinExpr evaluates the binary operation with a symbolic
+// operand, only the bitwise operations work on the bits.
+func (e *interp) symBinExpr(expr BinExpr, lhs, rhs Value) (Value, error) {
+	if !isBitwiseOP(expr.Op) {
+		if lhs.sym == nil {
+			lhs = rhs
+		}
+		return Value{}, e.needsValue(expr, lhs)
+	}
+
+	d := e.cfg.dialect
+	if d == LangJS {
+		return e.symJSBinOP(expr, lhs, rhs)
+	}
+
+	switch expr.Op {
+	case OpSHL, OpSHR, OpUSHR:
+		return symShift(d, expr, lhs, rhs)
+	}
+
+	typ, err := d.binaryType(lhs.Type, rhs.Type)
+	if err != nil {
+		return Value{}, fmt.Errorf("%s: %s", expr, err)
+	}
+	return e.bitwise(expr.Op, lhs.Convert(typ), rhs.Convert(typ)), nil
+}
+
+// symJSBinOP is the bitwise operation of JavaScript, on 32 bits
+// signed integers, as jsBinOP.
+func (e *interp) symJSBinOP(expr BinExpr, lhs, rhs Value) (Value, error) {
+	a := lhs.Convert(I32)
+	b := rhs.Convert(I32)
+
+	var (
+		ret Value
+		err error
+	)
+	switch expr.Op {
+	case OpSHL, OpSHR:
+		ret, err = symShift(LangJS, expr, a, b)
+	case OpUSHR:
+		expr.Op = OpSHR
+		ret, err = symShift(LangJS, expr, lhs.Convert(U32), b)
+	default:
+		ret = e.bitwise(expr.Op, a, b)
+	}
+
+	if err != nil {
+		return Value{}, err
+	}
+	return ret.Convert(Unbounded), nil
+}
+
+// bitwise applies op to the bits of a and b, they have the
+// same type.
+func (e *interp) bitwise(op Optype, a, b Value) Value {
+	width := len(a.bits())
+	if len(b.bits()) > width {
+		width = len(b.bits())
+	}
+
+	bits := make([]*Formula, width)
+	for i := range bits {
+		x, y := bit(a, uint(i)), bit(b, uint(i))
+		switch op {
+		case OpAND:
+			bits[i] = e.sym.and(x, y)
+		case OpANDNOT:
+			bits[i] = e.sym.and(x, e.sym.not(y))
+		case OpOR:
+			bits[i] = e.sym.or(x, y)
+		case OpXOR:
+			bits[i] = e.sym.xor(x, y)
+		}
+	}
+	return symbolic(a.Type, bits)
+}
+
+// symShift moves the bits of lhs, the count must be constant.
+// The result has the type of the (promoted) lhs.
+func symShift(d Dialect, expr BinExpr, lhs, rhs Value) (Value, error) {
+	if rhs.sym != nil {
+		return Value{}, fmt.Errorf("%s: the shift count needs the value of %s",
+			expr, rhs.varNames())
+	}
+
+	typ := d.promote(lhs.Type)
+	n, err := d.shiftCount(rhs, typ)
+	if err != nil {
+		return Value{}, err
+	}
+
+	lhs = lhs.Convert(typ)
+	if expr.Op == OpUSHR {
+		lhs = lhs.Convert(typ.Unsigned())
+	}
+	src := lhs.bits()
+
+	var bits []*Formula
+	switch {
+	case typ.Bits == 0 && expr.Op == OpSHL:
+		bits = make([]*Formula, n, uint(len(src))+n)
+		for i := range bits {
+			bits[i] = falseFormula
+		}
+		bits = append(bits, src...)
+	case typ.Bits == 0:
+		bits = src[len(src)-1:]
+		if n < uint(len(src)) {
+			bits = src[n:]
+		}
+	case expr.Op == OpSHL:
+		bits = make([]*Formula, typ.Bits)
+		for i := range bits {
+			bits[i] = falseFormula
+			if uint(i) >= n {
+				bits[i] = bit(lhs, uint(i)-n)
+			}
+		}
+	default:
+		bits = make([]*Formula, typ.Bits)
+		for i := range bits {
+			bits[i] = bit(lhs, uint(i)+n)
+		}
+	}
+	return symbolic(typ, bits), nil
+}
+
+// symSlice returns the bits hi:lo of the symbolic val, shifted
+// down to bit 0, as evalSlice.
+func (e *interp) symSlice(slice Slice, val Value, hi, lo uint) (Value, error) {
+	d := e.cfg.dialect
+	t := fixedType(d, val.Type)
+	if t.Bits == 0 {
+		if sign, ok := val.sym[len(val.sym)-1].Const(); !ok || sign {
+			return Value{}, fmt.Errorf("%s: negative unbounded integer %s",
+				slice, slice.Value)
+		}
+	}
+	val = val.Convert(t)
+
+	bits := make([]*Formula, hi-lo+1, hi-lo+2)
+	for i := range bits {
+		bits[i] = bit(val, lo+uint(i))
+	}
+
+	ret := symbolic(Unbounded, append(bits, falseFormula))
+	if t.Bits != 0 && d != LangJS {
+		ret = ret.Convert(t.Unsigned())
+	}
+	return ret, nil
+}
+
+// symConcat concatenates the bits of the parts, as evalConcat.
+func symConcat(parts []Value) Value {
+	// the first part has the most significant bits
+	var bits []*Formula
+	for i := len(parts) - 1; i >= 0; i-- {
+		bits = append(bits, parts[i].bits()...)
+	}
+	return symbolic(Type{Bits: uint(len(bits))}, bits)
+}
+
+// symReplicate concatenates val count times.
+func symReplicate(val Value, count int64) Value {
+	var bits []*Formula
+	for i := int64(0); i < count; i++ {
+		bits = append(bits, val.bits()...)
+	}
+	return symbolic(Type{Bits: uint(len(bits))}, bits)
+}
+
+// symSliceAssign sets the bits hi:lo of old to val, one of them
+// is symbolic. The value must fit the slice: its other bits
+// must be zero.
+func symSliceAssign(assign SliceAssign, old, val Value, hi, lo uint) (Value, error) {
+	width := hi - lo + 1
+	for i := width; i <= uint(len(val.bits())); i++ {
+		if bit(val, i) != falseFormula {
+			return Value{}, fmt.Errorf("%s: %s does not fit in %d bits",
+				assign, assign.Expr, width)
+		}
+	}
+
+	bits := make([]*Formula, len(old.bits()), uint(len(old.bits()))+width)
+	copy(bits, old.bits())
+	for i := uint(len(bits)); i <= hi; i++ {
+		// the unbounded integers grow, keeping the sign
+		bits = append(bits, bit(old, i))
+	}
+	for i := uint(0); i < width; i++ {
+		bits[lo+i] = bit(val, i)
+	}
+	if old.Type.Bits == 0 {
+		bits = append(bits, bit(old, uint(len(bits))))
+	}
+	return symbolic(old.Type, bits), nil
+}
+
+// String reports the formulas of the bits, grouping the bits
+// in the same pattern: the bits with the same formula, or the
+// consecutive bits of a variable, one after the other or every
+// k bits:
+//
+//	type: u64
+//	bits 2k = X[k], k = 0..31
+//	bits 2k+1 = 0, k = 0..31
+func (v SymValue) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "type: %s", v.Type)
+
+	// the bits of unbounded values extend their last bit, so
+	// the copies of it and the zero sign are not reported
+	var ext *Formula
+	if v.Type == Unbounded && len(v.Bits) > 0 {
+		n := len(v.Bits)
+		for n > 1 && v.Bits[n-1] == v.Bits[n-2] {
+			n--
+		}
+		ext = v.Bits[n-1]
+		if val, ok := ext.Const(); ok && !val {
+			ext = nil
+		}
+		v.Bits = v.Bits[:n-1]
+	}
+
+	// the largest groups are taken first, the ones with the
+	// consecutive bits when there is a tie
+	var groups []bitGroup
+	done := make([]bool, len(v.Bits))
+	for left := len(v.Bits); left > 0; {
+		var best bitGroup
+		for i := range v.Bits {
+			if done[i] {
+				continue
+			}
+			g := v.pattern(i, done)
+			if g.count > best.count || g.count == best.count && g.stride < best.stride {
+				best = g
+			}
+		}
+		for k := 0; k < best.count; k++ {
+			done[best.first+k*best.stride] = true
+		}
+		left -= best.count
+		groups = append(groups, best)
+	}
+
+	sort.Slice(groups, func(i, j int) bool {
+		return groups[i].first < groups[j].first
+	})
+	for _, g := range groups {
+		b.WriteString("\n")
+		b.WriteString(v.group(g))
+	}
+	if ext != nil {
+		fmt.Fprintf(&b, "\nbits %d.. = %s", len(v.Bits), ext)
+	}
+	return b.String()
+}
+
+// bitGroup is a group of bits every stride bits from the bit
+// first, with the same formula or with the next bit of a
+// variable.
+type bitGroup struct {
+	first, stride, count int
+	next                 bool
+}
+
+// pattern returns the largest group of bits starting at the
+// bit i.
+func (v SymValue) pattern(i int, done []bool) bitGroup {
+	best := bitGroup{first: i, stride: 1, count: 1}
+	for _, next := range []bool{false, true} {
+		for s := 1; i+s < len(v.Bits); s++ {
+			n := 1
+			for j := i + s; j < len(v.Bits) && !done[j] && v.follows(i, j, n, next); j += s {
+				n++
+			}
+			if n > best.count {
+				best = bitGroup{first: i, stride: s, count: n, next: next}
+			}
+		}
+	}
+	return best
+}
+
+// follows tells if the bit j is the n-th of the group of the
+// bit i: the same formula, or the next bit of the variable.
+func (v SymValue) follows(i, j, n int, next bool) bool {
+	a, b := v.Bits[i], v.Bits[j]
+	if !next {
+		return a == b
+	}
+	if (a.op == formulaNot) != (b.op == formulaNot) {
+		return false
+	}
+
+	name, bit, ok := varBit(a)
+	name2, bit2, ok2 := varBit(b)
+	return ok && ok2 && name == name2 && bit2 == bit+uint(n)
+}
+
+// group formats the bits of the group g.
+func (v SymValue) group(g bitGroup) string {
+	i := g.first
+	first, last := v.Bits[i], v.Bits[i+(g.count-1)*g.stride]
+	if g.count == 1 {
+		return fmt.Sprintf("bit %d = %s", i, first)
+	}
+
+	if g.stride == 1 {
+		val := first.String()
+		if g.next {
+			_, lo, _ := varBit(first)
+			_, hi, _ := varBit(last)
+			val = bitPattern(first, fmt.Sprintf("%d:%d", hi, lo))
+		}
+		return fmt.Sprintf("bits %d:%d = %s", i+g.count-1, i, val)
+	}
+
+	out := fmt.Sprintf("%dk", g.stride)
+	if i != 0 {
+		out += fmt.Sprintf("+%d", i)
+	}
+	val := first.String()
+	if g.next {
+		idx := "k"
+		if _, bit, _ := varBit(first); bit != 0 {
+			idx += fmt.Sprintf("+%d", bit)
+		}
+		val = bitPattern(first, idx)
+	}
+	return fmt.Sprintf("bits %s = %s, k = 0..%d", out, val, g.count-1)
+}
+
+// varBit returns the variable of the bit f, which may be
+// negated.
+func varBit(f *Formula) (string, uint, bool) {
+	if f.op == formulaNot {
+		f = f.args[0]
+	}
+	return f.Var()
+}
+
+// bitPattern formats the bit f with the index idx, like ~X[k].
+func bitPattern(f *Formula, idx string) string {
+	name, _, _ := varBit(f)
+	if f.op == formulaNot {
+		return fmt.Sprintf("~%s[%s]", name, idx)
+	}
+	return fmt.Sprintf("%s[%s]", name, idx)
+}
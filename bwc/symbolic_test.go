@@ -0,0 +1,194 @@
+package bwc
+
+import (
+	"math/big"
+	"testing"
+)
+
+func TestEvalSymbolic(t *testing.T) {
+	for _, tc := range []struct {
+		code    string
+		dialect Dialect
+		out     string
+	}{
+		{code: "X & 0xf0", out: "type: i32\nbits 3:0 = 0\nbits 7:4 = X[7:4]\nbits 31:8 = 0"},
+		{code: "X ^ X", out: "type: i32\nbits 31:0 = 0"},
+		{code: "~X", out: "type: i32\nbits 31:0 = ~X[31:0]"},
+		{code: "X[7:0] << 8 | X[15:8]", out: "type: u32\nbits 7:0 = X[15:8]\nbits 15:8 = X[7:0]\nbits 31:16 = 0"},
+		{code: "(X ^ Y) & 1", out: "type: i32\nbit 0 = X[0] ^ Y[0]\nbits 31:1 = 0"},
+		{code: "{(uint8_t)X, (uint8_t)Y}", out: "type: u16\nbits 7:0 = Y[7:0]\nbits 15:8 = X[7:0]"},
+		{code: "Y = 4; X >> Y & 0xf", out: "type: i32\nbits 3:0 = X[7:4]\nbits 31:4 = 0"},
+		{code: "(uint8_t)(X >> 24)", out: "type: u8\nbits 7:0 = X[31:24]"},
+		{code: "X >> 28", out: "type: i32\nbits 2:0 = X[30:28]\nbits 31:3 = X[31]"},
+		{code: "X & 0xf", dialect: LangPython, out: "type: unbounded\nbits 3:0 = X[3:0]"},
+		{code: "-1 & ~X", dialect: LangPython, out: "type: unbounded\nbits 62:0 = ~X[62:0]\nbits 63.. = ~X[63]"},
+		{code: "X >> 70 | 0xf0", dialect: LangPython, out: "type: unbounded\nbits 3:0 = X[63]\nbits 7:4 = 1\nbits 8.. = X[63]"},
+		{code: "-1 << 4", dialect: LangPython, out: "type: unbounded\nbits 3:0 = 0\nbits 4.. = 1"},
+		{code: "X >>> 31", dialect: LangJS, out: "type: unbounded\nbit 0 = X[31]"},
+		{code: "X & 0xff", dialect: LangGo, out: "type: i64\nbits 7:0 = X[7:0]\nbits 63:8 = 0"},
+		{code: "u8(X) &^ 0xf", dialect: LangGo, out: "type: u8\nbits 3:0 = 0\nbits 7:4 = X[7:4]"},
+	} {
+		got, err := NewInterp(Lang(tc.dialect)).EvalSymbolic(tc.code)
+		if err != nil {
+			t.Fatalf("%s: %q: %s", tc.dialect, tc.code, err)
+		}
+		if got.String() != tc.out {
+			t.Fatalf("%s: %q: got:\n%s\nexpected:\n%s", tc.dialect, tc.code, got, tc.out)
+		}
+	}
+}
+
+func TestEvalSymbolicStripe(t *testing.T) {
+	e := NewInterp(Lang(LangGo))
+	_, err := e.Exec(`func stripe(x uint32) uint64 {
+	v := uint64(x)
+	v = (v | v<<16) & 0x0000ffff0000ffff
+	v = (v | v<<8) & 0x00ff00ff00ff00ff
+	v = (v | v<<4) & 0x0f0f0f0f0f0f0f0f
+	v = (v | v<<2) & 0x3333333333333333
+	v = (v | v<<1) & 0x5555555555555555
+	return v
+}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := e.EvalSymbolic("stripe(u32(X))")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "type: u64\nbits 2k = X[k], k = 0..31\nbits 2k+1 = 0, k = 0..31"
+	if got.String() != expected {
+		t.Fatalf("got:\n%s\nexpected:\n%s", got, expected)
+	}
+
+	if _, err := e.Eval(Var{Name: "X"}); err == nil {
+		t.Fatal("X must not be defined by the symbolic evaluation")
+	}
+}
+
+func TestEvalSymbolicMacros(t *testing.T) {
+	e := NewInterp()
+	got, err := e.EvalSymbolic("#define R 7\nX & R")
+	if err != nil {
+		t.Fatal(err)
+	}
+	const out = "type: i32\nbits 2:0 = X[2:0]\nbits 31:3 = 0"
+	if got.String() != out {
+		t.Fatalf("got:\n%s\nexpected:\n%s", got, out)
+	}
+
+	if _, err := e.Exec("R"); err == nil || err.Error() != "undefined variable R" {
+		t.Fatalf("the macro R was defined by EvalSymbolic: %v", err)
+	}
+}
+
+func TestEvalSymbolicErrors(t *testing.T) {
+	for _, tc := range []struct {
+		code string
+		err  string
+	}{
+		{code: "X + 1", err: "X + 1 needs the value of X"},
+		{code: "1 << X", err: "1 << X: the shift count needs the value of X"},
+		{code: "X ? 1 : 2", err: "the condition X needs the value of X"},
+		{code: "1 / 0", err: "division by zero: 1 / 0"},
+	} {
+		_, err := NewInterp().EvalSymbolic(tc.code)
+		if err == nil {
+			t.Fatalf("%q: expected error %q", tc.code, tc.err)
+		}
+		if err.Error() != tc.err {
+			t.Fatalf("%q: got error %q != expected %q", tc.code, err, tc.err)
+		}
+	}
+}
+
+// evalFormula evaluates f with the bits of the variables.
+func evalFormula(f *Formula, vars map[string]*big.Int) bool {
+	if val, ok := f.Const(); ok {
+		return val
+	}
+	if name, bit, ok := f.Var(); ok {
+		return vars[name].Bit(int(bit)) == 1
+	}
+
+	ret := evalFormula(f.args[0], vars)
+	for _, arg := range f.args[1:] {
+		switch f.op {
+		case formulaAnd:
+			ret = ret && evalFormula(arg, vars)
+		case formulaOr:
+			ret = ret || evalFormula(arg, vars)
+		case formulaXor:
+			ret = ret != evalFormula(arg, vars)
+		}
+	}
+	if f.op == formulaNot {
+		return !ret
+	}
+	return ret
+}
+
+func TestEvalSymbolicEquivalence(t *testing.T) {
+	dialects := []Dialect{LangC, LangGo, LangRust, LangJava, LangJS, LangPython}
+	for _, d := range dialects {
+		g := newExprgen(int64(d), d)
+		g.binops = []Optype{OpAND, OpOR, OpXOR}
+		if d == LangGo {
+			g.binops = append(g.binops, OpANDNOT)
+		}
+
+		// the values of the symbolic variables
+		typ := fixedType(d, d.intType())
+		if typ == Unbounded {
+			typ = I64
+		}
+
+		concrete := NewInterp(Lang(d))
+		evaluated := 0
+		for i := 0; i < 2000; i++ {
+			if i%100 == 0 {
+				g.pool = nil
+			}
+			code := Format(g.expr(4), Lang(d))
+			n, err := Parse(code, Lang(d))
+			if err != nil {
+				t.Fatalf("%s: parsing %q: %s", d, code, err)
+			}
+
+			sym, err := NewInterp(Lang(d)).EvalSymbolic(code)
+			if err != nil {
+				continue
+			}
+			evaluated++
+
+			for j := 0; j < 8; j++ {
+				vars := map[string]*big.Int{}
+				for _, name := range []string{"a", "b"} {
+					val := g.value(typ)
+					vars[name] = val.int()
+					concrete.environ[name] = newValue(val.int(), fixedType(d, d.intType()))
+				}
+
+				expected, err := concrete.Eval(n)
+				if err != nil {
+					t.Fatalf("%s: %q: a=%s b=%s: %s", d, code, vars["a"], vars["b"], err)
+				}
+
+				bits := make([]*Formula, len(sym.Bits))
+				for k, bit := range sym.Bits {
+					bits[k] = constFormula(evalFormula(bit, vars))
+				}
+				got, _ := SymValue{Type: sym.Type, Bits: bits}.value()
+				if got.Type != expected.Type || got.int().Cmp(expected.int()) != 0 {
+					t.Fatalf("%s: %q = %s (%s), symbolic evaluation gives %s (%s), a=%s b=%s:\n%s",
+						d, code, expected, expected.Type, got, got.Type,
+						vars["a"], vars["b"], sym)
+				}
+			}
+		}
+		if evaluated < 500 {
+			t.Fatalf("%s: only %d expressions were evaluated", d, evaluated)
+		}
+	}
+}
@@ -13,12 +13,40 @@ const (
 	LParen
 	RParen
 	Equal
+	Define
+	AssignOp
+	Semicolon
 	OR
 	AND
+	ANDNOT
 	XOR
 	NOT
+	BANG
 	SHL
 	SHR
+	USHR
+	PLUS
+	MINUS
+	MUL
+	DIV
+	FDIV
+	MOD
+	EQ
+	NEQ
+	LT
+	LE
+	GT
+	GE
+	LAND
+	LOR
+	QUESTION
+	COLON
+	Comma
+	LBrace
+	RBrace
+	LBracket
+	RBracket
+	Directive
 	EOF
 )
 
@@ -34,18 +62,74 @@ func (t Token) String() string {
 		return ")"
 	case Equal:
 		return "="
+	case Define:
+		return ":="
+	case AssignOp:
+		return "OP="
+	case Semicolon:
+		return ";"
 	case OR:
 		return "|"
 	case AND:
 		return "&"
+	case ANDNOT:
+		return "&^"
 	case XOR:
 		return "^"
 	case NOT:
 		return "~"
+	case BANG:
+		return "!"
 	case SHL:
 		return "<<"
 	case SHR:
 		return ">>"
+	case USHR:
+		return ">>>"
+	case PLUS:
+		return "+"
+	case MINUS:
+		return "-"
+	case MUL:
+		return "*"
+	case DIV:
+		return "/"
+	case FDIV:
+		return "//"
+	case MOD:
+		return "%"
+	case EQ:
+		return "=="
+	case NEQ:
+		return "!="
+	case LT:
+		return "<"
+	case LE:
+		return "<="
+	case GT:
+		return ">"
+	case GE:
+		return ">="
+	case LAND:
+		return "&&"
+	case LOR:
+		return "||"
+	case QUESTION:
+		return "?"
+	case COLON:
+		return ":"
+	case Comma:
+		return ","
+	case LBrace:
+		return "{"
+	case RBrace:
+		return "}"
+	case LBracket:
+		return "["
+	case RBracket:
+		return "]"
+	case Directive:
+		return "DIRECTIVE"
 	case Illegal:
 		return "<ileggal>"
 	case EOF:
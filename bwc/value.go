@@ -0,0 +1,309 @@
+package bwc
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+type (
+	// Type is the integer type of a value.
+	Type struct {
+		Bits   uint // Bits is the width, zero for unbounded integers.
+		Signed bool
+	}
+
+	// Value is an integer of a given Type. The integer is
+	// always in the range of its type, operations that
+	// overflow wrap around like in the compiled code.
+	Value struct {
+		Type Type
+		val  *big.Int
+
+		// sym has the formulas of the bits of the symbolic
+		// values, see EvalSymbolic. It is nil for integers.
+		sym []*Formula
+	}
+)
+
+var (
+	U8  = Type{Bits: 8}
+	U16 = Type{Bits: 16}
+	U32 = Type{Bits: 32}
+	U64 = Type{Bits: 64}
+	I8  = Type{Bits: 8, Signed: true}
+	I16 = Type{Bits: 16, Signed: true}
+	I32 = Type{Bits: 32, Signed: true}
+	I64 = Type{Bits: 64, Signed: true}
+
+	U128 = Type{Bits: 128}
+	U256 = Type{Bits: 256}
+	I128 = Type{Bits: 128, Signed: true}
+	I256 = Type{Bits: 256, Signed: true}
+
+	// Unbounded integers never overflow. They are the Python
+	// integers and the untyped constants of Go and Rust.
+	Unbounded = Type{Signed: true}
+)
+
+func (t Type) String() string {
+	if t.Bits == 0 {
+		return "unbounded"
+	}
+	if t.Signed {
+		return fmt.Sprintf("i%d", t.Bits)
+	}
+	return fmt.Sprintf("u%d", t.Bits)
+}
+
+// Unsigned returns the unsigned type with the width of t.
+func (t Type) Unsigned() Type {
+	if t.Bits == 0 {
+		return t
+	}
+	return Type{Bits: t.Bits}
+}
+
+// fits tells if x is in the range of t.
+func (t Type) fits(x *big.Int) bool {
+	return t.wrap(x).Cmp(x) == 0
+}
+
+// wrap x around the width of t. The bits beyond the width
+// are discarded and, for signed types, the most significant
+// bit is the sign, as in two's complement.
+func (t Type) wrap(x *big.Int) *big.Int {
+	if t.Bits == 0 {
+		return x
+	}
+
+	mod := new(big.Int).Lsh(big.NewInt(1), t.Bits)
+	mask := new(big.Int).Sub(mod, big.NewInt(1))
+
+	r := new(big.Int).And(x, mask)
+	if t.Signed && r.Bit(int(t.Bits-1)) == 1 {
+		r.Sub(r, mod)
+	}
+	return r
+}
+
+// NewValue creates a value of type t from x,
+// wrapping it if needed.
+func NewValue(x int64, t Type) Value {
+	return newValue(big.NewInt(x), t)
+}
+
+func newValue(x *big.Int, t Type) Value {
+	return Value{
+		Type: t,
+		val:  t.wrap(x),
+	}
+}
+
+// int returns the integer, the zero value is 0.
+func (v Value) int() *big.Int {
+	if v.val == nil {
+		return new(big.Int)
+	}
+	return v.val
+}
+
+// Big returns a copy of the integer.
+func (v Value) Big() *big.Int {
+	return new(big.Int).Set(v.int())
+}
+
+// Int64 returns the low 64 bits of the integer.
+func (v Value) Int64() int64 {
+	return int64(v.Uint64())
+}
+
+// Uint64 returns the low 64 bits of the integer.
+func (v Value) Uint64() uint64 {
+	return U64.wrap(v.int()).Uint64()
+}
+
+// Sign returns -1, 0 or 1 if the value is negative,
+// zero or positive.
+func (v Value) Sign() int {
+	return v.int().Sign()
+}
+
+// Convert the value to type t as done by casts.
+func (v Value) Convert(t Type) Value {
+	if v.sym != nil {
+		return convertBits(v, t)
+	}
+	return newValue(v.int(), t)
+}
+
+// String returns the integer, or the variables of the bits of
+// the symbolic values.
+func (v Value) String() string {
+	if v.sym != nil {
+		return v.varNames()
+	}
+	return v.int().String()
+}
+
+// Text returns the value in the given base. Negative values
+// of fixed width types are written in two's complement.
+func (v Value) Text(base int) string {
+	x := v.int()
+	if x.Sign() >= 0 {
+		return x.Text(base)
+	}
+
+	if v.Type.Bits == 0 {
+		return "-" + new(big.Int).Neg(x).Text(base)
+	}
+	return v.Type.Unsigned().wrap(x).Text(base)
+}
+
+// builtinTypes are the type names available in every dialect.
+var builtinTypes = map[string]Type{
+	"u8":  U8,
+	"u16": U16,
+	"u32": U32,
+	"u64": U64,
+	"i8":  I8,
+	"i16": I16,
+	"i32": I32,
+	"i64": I64,
+
+	"u128": U128,
+	"u256": U256,
+	"i128": I128,
+	"i256": I256,
+}
+
+var cTypes = map[string]Type{
+	"uint8_t":   U8,
+	"uint16_t":  U16,
+	"uint32_t":  U32,
+	"uint64_t":  U64,
+	"int8_t":    I8,
+	"int16_t":   I16,
+	"int32_t":   I32,
+	"int64_t":   I64,
+	"size_t":    U64,
+	"ssize_t":   I64,
+	"uintptr_t": U64,
+	"intptr_t":  I64,
+
+	// gcc and clang 128 bits integers
+	"__int128":          I128,
+	"__int128_t":        I128,
+	"signed __int128":   I128,
+	"unsigned __int128": U128,
+	"__uint128_t":       U128,
+
+	// linux kernel types
+	"s8":  I8,
+	"s16": I16,
+	"s32": I32,
+	"s64": I64,
+
+	"char":                   I8,
+	"signed char":            I8,
+	"unsigned char":          U8,
+	"short":                  I16,
+	"short int":              I16,
+	"signed short":           I16,
+	"unsigned short":         U16,
+	"unsigned short int":     U16,
+	"int":                    I32,
+	"signed":                 I32,
+	"signed int":             I32,
+	"unsigned":               U32,
+	"unsigned int":           U32,
+	"long":                   I64,
+	"long int":               I64,
+	"signed long":            I64,
+	"unsigned long":          U64,
+	"unsigned long int":      U64,
+	"long long":              I64,
+	"long long int":          I64,
+	"signed long long":       I64,
+	"unsigned long long":     U64,
+	"unsigned long long int": U64,
+}
+
+var goTypes = map[string]Type{
+	"uint8":   U8,
+	"uint16":  U16,
+	"uint32":  U32,
+	"uint64":  U64,
+	"int8":    I8,
+	"int16":   I16,
+	"int32":   I32,
+	"int64":   I64,
+	"int":     I64,
+	"uint":    U64,
+	"uintptr": U64,
+	"byte":    U8,
+	"rune":    I32,
+}
+
+var rustTypes = map[string]Type{
+	"usize": U64,
+	"isize": I64,
+}
+
+var pythonTypes = map[string]Type{
+	"int": Unbounded,
+}
+
+var javaTypes = map[string]Type{
+	"byte":  I8,
+	"short": I16,
+	"char":  U16,
+	"int":   I32,
+	"long":  I64,
+}
+
+// typeByName returns the type with the given name. Names with
+// many words, like "unsigned long", are separated by a space.
+func (d Dialect) typeByName(name string) (Type, bool) {
+	if t, ok := builtinTypes[name]; ok {
+		return t, true
+	}
+
+	t, ok := d.types()[name]
+	return t, ok
+}
+
+// types returns the type names of the dialect, besides the
+// bwc ones.
+func (d Dialect) types() map[string]Type {
+	switch d {
+	case LangC:
+		return cTypes
+	case LangGo:
+		return goTypes
+	case LangRust:
+		return rustTypes
+	case LangJava:
+		return javaTypes
+	case LangPython:
+		return pythonTypes
+	}
+	return nil
+}
+
+// isTypeWord tells if word starts a type name.
+func (d Dialect) isTypeWord(word string) bool {
+	if _, ok := d.typeByName(word); ok {
+		return true
+	}
+	if d != LangC {
+		return false
+	}
+
+	for name := range cTypes {
+		if strings.HasPrefix(name, word+" ") {
+			return true
+		}
+	}
+	return false
+}
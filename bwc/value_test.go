@@ -0,0 +1,45 @@
+package bwc
+
+import "testing"
+
+func TestValueConvert(t *testing.T) {
+	for _, tc := range []struct {
+		val  int64
+		typ  Type
+		res  int64
+		text string
+	}{
+		{val: 0x1ff, typ: U8, res: 0xff, text: "ff"},
+		{val: -1, typ: U8, res: 0xff, text: "ff"},
+		{val: 0x80, typ: I8, res: -128, text: "80"},
+		{val: -1, typ: I16, res: -1, text: "ffff"},
+		{val: 0x12345678, typ: U16, res: 0x5678, text: "5678"},
+		{val: -1, typ: U32, res: 0xffffffff, text: "ffffffff"},
+		{val: 0x80000000, typ: I32, res: -0x80000000, text: "80000000"},
+		{val: -1, typ: I64, res: -1, text: "ffffffffffffffff"},
+		{val: -1, typ: U64, res: -1, text: "ffffffffffffffff"},
+		{val: -255, typ: Unbounded, res: -255, text: "-ff"},
+	} {
+		v := NewValue(tc.val, tc.typ)
+		if v.Int64() != tc.res {
+			t.Fatalf("%d as %s: got(%s) != expected(%d)",
+				tc.val, tc.typ, v, tc.res)
+		}
+
+		if v.Text(16) != tc.text {
+			t.Fatalf("%d as %s: got(%s) != expected(%s)",
+				tc.val, tc.typ, v.Text(16), tc.text)
+		}
+	}
+}
+
+func TestValueUint64(t *testing.T) {
+	v := NewValue(-1, U64)
+	if v.Uint64() != 0xffffffffffffffff {
+		t.Fatalf("got(%d) != expected(%d)", v.Uint64(),
+			uint64(0xffffffffffffffff))
+	}
+	if v.String() != "18446744073709551615" {
+		t.Fatalf("unexpected decimal: %s", v)
+	}
+}
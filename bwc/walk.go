@@ -0,0 +1,209 @@
+package bwc
+
+import "fmt"
+
+// A Visitor's Visit method is called by Walk for each node.
+// If the visitor w returned is not nil, Walk visits the
+// children of the node with w, followed by a call of
+// w.Visit(nil).
+type Visitor interface {
+	Visit(n Node) (w Visitor)
+}
+
+// Walk traverses the AST in depth-first order, the children
+// are visited in the order they are in the code. It starts
+// calling v.Visit(n), n must not be nil.
+func Walk(v Visitor, n Node) {
+	if v = v.Visit(n); v == nil {
+		return
+	}
+
+	for _, child := range children(n) {
+		Walk(v, child)
+	}
+	v.Visit(nil)
+}
+
+// inspector is the Visitor of Inspect.
+type inspector func(Node) bool
+
+func (f inspector) Visit(n Node) Visitor {
+	if f(n) {
+		return f
+	}
+	return nil
+}
+
+// Inspect traverses the AST in depth-first order, calling
+// f(n) for each node. The children of n are only inspected
+// if f(n) returns true, and then f(nil) is called.
+func Inspect(n Node, f func(Node) bool) {
+	Walk(inspector(f), n)
+}
+
+// children returns the nodes of n, in the order of the code.
+// The missing optional nodes, like the Else of an If, are not
+// returned.
+func children(n Node) []Node {
+	var nodes []Node
+	switch n := n.(type) {
+	case Int, Var, MacroDecl:
+	case UnaryExpr:
+		nodes = []Node{n.Value}
+	case BinExpr:
+		nodes = []Node{n.Lhs, n.Rhs}
+	case CondExpr:
+		nodes = []Node{n.Cond, n.Then, n.Else}
+	case Call:
+		nodes = n.Args
+	case FuncDecl:
+		nodes = []Node{n.Body}
+	case Return:
+		nodes = []Node{n.Value}
+	case If:
+		nodes = []Node{n.Cond, n.Then, n.Else}
+	case For:
+		nodes = []Node{n.Init, n.Cond, n.Post, n.Body}
+	case Enum:
+		for _, c := range n.Consts {
+			nodes = append(nodes, c)
+		}
+	case Slice:
+		nodes = []Node{n.Value, n.Hi, n.Lo}
+	case Concat:
+		nodes = n.Parts
+	case Replicate:
+		nodes = []Node{n.Count, n.Value}
+	case SliceAssign:
+		nodes = []Node{n.Hi, n.Lo, n.Expr}
+	case Cast:
+		nodes = []Node{n.Value}
+	case Assign:
+		nodes = []Node{n.Expr}
+	case StmtList:
+		nodes = n.Stmts
+	default:
+		panic(fmt.Sprintf("invalid node: %T", n))
+	}
+
+	ret := nodes[:0:0]
+	for _, n := range nodes {
+		if n != nil {
+			ret = append(ret, n)
+		}
+	}
+	return ret
+}
+
+// Rewrite transforms the AST in post-order: the children of
+// each node are rewritten first, then f is called with the
+// node holding the new children, and its result replaces the
+// node. The nodes are values, so n is not changed.
+//
+// The Value of a Replicate must be rewritten to a Concat and
+// the constants of an Enum to Assign nodes, otherwise Rewrite
+// panics. Optional nodes that are nil are not rewritten.
+func Rewrite(n Node, f func(Node) Node) Node {
+	if n == nil {
+		return nil
+	}
+
+	rw := func(n Node) Node {
+		return Rewrite(n, f)
+	}
+
+	switch n := n.(type) {
+	case Int, Var, MacroDecl:
+		return f(n)
+	case UnaryExpr:
+		n.Value = rw(n.Value)
+		return f(n)
+	case BinExpr:
+		n.Lhs = rw(n.Lhs)
+		n.Rhs = rw(n.Rhs)
+		return f(n)
+	case CondExpr:
+		n.Cond = rw(n.Cond)
+		n.Then = rw(n.Then)
+		n.Else = rw(n.Else)
+		return f(n)
+	case Call:
+		n.Args = rewriteList(n.Args, f)
+		return f(n)
+	case FuncDecl:
+		n.Body = rw(n.Body)
+		return f(n)
+	case Return:
+		n.Value = rw(n.Value)
+		return f(n)
+	case If:
+		n.Cond = rw(n.Cond)
+		n.Then = rw(n.Then)
+		n.Else = rw(n.Else)
+		return f(n)
+	case For:
+		n.Init = rw(n.Init)
+		n.Cond = rw(n.Cond)
+		n.Post = rw(n.Post)
+		n.Body = rw(n.Body)
+		return f(n)
+	case Enum:
+		consts := make([]Assign, len(n.Consts))
+		for i, c := range n.Consts {
+			val := rw(c)
+			assign, ok := val.(Assign)
+			if !ok {
+				panic(fmt.Sprintf("constant %s of enum rewritten to %T",
+					c.Varname, val))
+			}
+			consts[i] = assign
+		}
+		n.Consts = consts
+		return f(n)
+	case Slice:
+		n.Value = rw(n.Value)
+		n.Hi = rw(n.Hi)
+		n.Lo = rw(n.Lo)
+		return f(n)
+	case Concat:
+		n.Parts = rewriteList(n.Parts, f)
+		return f(n)
+	case Replicate:
+		n.Count = rw(n.Count)
+		value := rw(n.Value)
+		concat, ok := value.(Concat)
+		if !ok {
+			panic(fmt.Sprintf("value of replication rewritten to %T", value))
+		}
+		n.Value = concat
+		return f(n)
+	case SliceAssign:
+		n.Hi = rw(n.Hi)
+		n.Lo = rw(n.Lo)
+		n.Expr = rw(n.Expr)
+		return f(n)
+	case Cast:
+		n.Value = rw(n.Value)
+		return f(n)
+	case Assign:
+		n.Expr = rw(n.Expr)
+		return f(n)
+	case StmtList:
+		n.Stmts = rewriteList(n.Stmts, f)
+		return f(n)
+	}
+	panic(fmt.Sprintf("invalid node: %T", n))
+}
+
+// rewriteList rewrites the nodes to a new slice, so the
+// slice of the original node is not changed.
+func rewriteList(nodes []Node, f func(Node) Node) []Node {
+	if nodes == nil {
+		return nil
+	}
+	ret := make([]Node, len(nodes))
+	for i, n := range nodes {
+		ret[i] = Rewrite(n, f)
+	}
+	return ret
+}
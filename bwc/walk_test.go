@@ -0,0 +1,192 @@
+package bwc_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/madlambda/bwc/bwc"
+)
+
+func parse(t *testing.T, code string, opts ...bwc.Option) bwc.Node {
+	t.Helper()
+	n, err := bwc.Parse(code, opts...)
+	if err != nil {
+		t.Fatalf("%q: %s", code, err)
+	}
+	return n
+}
+
+func TestInspect(t *testing.T) {
+	for _, tc := range []struct {
+		code  string
+		nodes string
+	}{
+		{
+			code:  "x = ~a[3:0] | f(b, 1)",
+			nodes: "Assign BinExpr UnaryExpr Slice Var Int Int Call Var Int",
+		},
+		{
+			code:  "fn f(a) { a ? {2{a}} : (u8)a }; enum { A, B = 2 }",
+			nodes: "StmtList FuncDecl CondExpr Var Replicate Int Concat Var Cast Var Enum Assign Cast BinExpr Int Int Assign Cast Int",
+		},
+	} {
+		var got []string
+		bwc.Inspect(parse(t, tc.code), func(n bwc.Node) bool {
+			if n != nil {
+				got = append(got, strings.TrimPrefix(n.Type().String(), "Node"))
+			}
+			return true
+		})
+		if strings.Join(got, " ") != tc.nodes {
+			t.Fatalf("%q: got nodes %v != expected %s", tc.code, got, tc.nodes)
+		}
+	}
+}
+
+func TestInspectGo(t *testing.T) {
+	code := "func f(x uint8) uint8 {\n\tfor i := 0; i < 8; i++ {\n\t\tif x > i {\n\t\t\treturn x\n\t\t}\n\t}\n\treturn 0\n}"
+
+	var got []string
+	bwc.Inspect(parse(t, code, bwc.Lang(bwc.LangGo)), func(n bwc.Node) bool {
+		if n != nil {
+			got = append(got, strings.TrimPrefix(n.Type().String(), "Node"))
+		}
+		return true
+	})
+
+	expected := "FuncDecl StmtList For Assign Int BinExpr Var Int Assign Int If BinExpr Var Var Return Var Return Int"
+	if strings.Join(got, " ") != expected {
+		t.Fatalf("got nodes %v != expected %s", got, expected)
+	}
+}
+
+// depthVisitor records the depth of the variables.
+type depthVisitor struct {
+	depth  int
+	depths map[string]int
+}
+
+func (v *depthVisitor) Visit(n bwc.Node) bwc.Visitor {
+	if n == nil {
+		v.depth--
+		return nil
+	}
+	if n, ok := n.(bwc.Var); ok {
+		v.depths[n.Name] = v.depth
+	}
+	v.depth++
+	return v
+}
+
+func TestWalk(t *testing.T) {
+	v := &depthVisitor{depths: map[string]int{}}
+	bwc.Walk(v, parse(t, "a + (b * (c - d))"))
+
+	expected := map[string]int{"a": 1, "b": 2, "c": 3, "d": 3}
+	for name, depth := range expected {
+		if v.depths[name] != depth {
+			t.Fatalf("%s: got depth %d != expected %d", name, v.depths[name], depth)
+		}
+	}
+	if v.depth != 0 {
+		t.Fatalf("unbalanced Visit(nil) calls, depth is %d", v.depth)
+	}
+}
+
+func TestInspectSkip(t *testing.T) {
+	var calls []string
+	bwc.Inspect(parse(t, "f(a) + g(b)"), func(n bwc.Node) bool {
+		if call, ok := n.(bwc.Call); ok {
+			calls = append(calls, call.Name)
+			return false
+		}
+		if _, ok := n.(bwc.Var); ok {
+			t.Fatal("the arguments must not be inspected")
+		}
+		return true
+	})
+	if strings.Join(calls, " ") != "f g" {
+		t.Fatalf("got calls %v", calls)
+	}
+}
+
+func TestRewrite(t *testing.T) {
+	for _, tc := range []struct {
+		code string
+		out  string
+	}{
+		{code: "x + y * x", out: "z + y * z"},
+		{code: "f(x, {2{x}})[x]", out: "f(z, {2{z}})[z]"},
+		{code: "fn g(a) { x = a; x << 1 }", out: "fn g(a) {\n\tz = a\n\tz << 1\n}"},
+		{code: "x[3:0] = x", out: "z[3:0] = z"},
+		{code: "enum { A = x, B }", out: "enum { A = z, B }"},
+	} {
+		n := parse(t, tc.code)
+		orig := bwc.Format(n)
+
+		got := bwc.Rewrite(n, func(n bwc.Node) bwc.Node {
+			switch n := n.(type) {
+			case bwc.Var:
+				if n.Name == "x" {
+					n.Name = "z"
+				}
+				return n
+			case bwc.Assign:
+				if n.Varname == "x" {
+					n.Varname = "z"
+				}
+				return n
+			case bwc.SliceAssign:
+				if n.Varname == "x" {
+					n.Varname = "z"
+				}
+				return n
+			}
+			return n
+		})
+
+		if out := bwc.Format(got); out != tc.out {
+			t.Fatalf("%q: got:\n%s\nexpected:\n%s", tc.code, out, tc.out)
+		}
+		if bwc.Format(n) != orig {
+			t.Fatalf("%q: the original AST changed to %s", tc.code, bwc.Format(n))
+		}
+	}
+}
+
+func TestRewritePostOrder(t *testing.T) {
+	// fold the additions of constants, the children are
+	// folded before their parents.
+	n := parse(t, "(1 + 2) + (3 + x)")
+	got := bwc.Rewrite(n, func(n bwc.Node) bwc.Node {
+		bin, ok := n.(bwc.BinExpr)
+		if !ok || bin.Op != bwc.OpADD {
+			return n
+		}
+		lhs, ok1 := bin.Lhs.(bwc.Int)
+		rhs, ok2 := bin.Rhs.(bwc.Int)
+		if !ok1 || !ok2 {
+			return n
+		}
+		return bwc.NewInt(lhs.Val.Int64() + rhs.Val.Int64())
+	})
+
+	if out := bwc.Format(got); out != "3 + (3 + x)" {
+		t.Fatalf("got %s", out)
+	}
+}
+
+func TestRewritePanics(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expected a panic")
+		}
+	}()
+
+	bwc.Rewrite(parse(t, "{2{a}}"), func(n bwc.Node) bwc.Node {
+		if n.Type() == bwc.NodeConcat {
+			return bwc.NewInt(0)
+		}
+		return n
+	})
+}
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"bufio"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
@@ -10,7 +12,12 @@ import (
 	"github.com/madlambda/bwc/bwc"
 )
 
-var cmd string
+var (
+	cmd          string
+	lang         string
+	leftToRight  bool
+	explainParse bool
+)
 
 func abortonerr(err error) {
 	if err != nil {
@@ -19,69 +26,303 @@ func abortonerr(err error) {
 	}
 }
 
-func printResult(res int) {
-	fmt.Printf("dec: %d\n", res)
-	fmt.Printf("bin: %b\n", res)
-	fmt.Printf("hex: %x\n", res)
+// spanError is an error in a part of the code, like
+// bwc.ParseError and bwc.EvalError.
+type spanError interface {
+	error
+	Span() (int, int)
 }
 
-func execCmd() (int, error) {
-	interp := bwc.NewInterp()
+// printError prints the error and the line of code with a
+// caret under the offending part. All the syntax errors of
+// the code are printed.
+func printError(w io.Writer, code string, err error) {
+	var errs bwc.ErrorList
+	if errors.As(err, &errs) {
+		for _, perr := range errs {
+			printSpanError(w, code, perr)
+		}
+		return
+	}
 
-	tree, err := bwc.Parse(cmd)
-	if err != nil {
-		return 0, err
+	var serr spanError
+	if errors.As(err, &serr) {
+		printSpanError(w, code, serr)
+		return
+	}
+	fmt.Fprintf(w, "error: %s\n", err)
+}
+
+// printSpanError prints the error, the lines of code with
+// many lines are numbered.
+func printSpanError(w io.Writer, code string, err spanError) {
+	start, end := err.Span()
+	if strings.Contains(code, "\n") {
+		line := strings.Count(code[:clamp(start, len(code))], "\n") + 1
+		fmt.Fprintf(w, "error: line %d: %s\n", line, err)
+	} else {
+		fmt.Fprintf(w, "error: %s\n", err)
+	}
+	if start < end {
+		fmt.Fprintln(w, bwc.Caret(code, start, end))
+	}
+}
+
+func clamp(n, max int) int {
+	if n < 0 {
+		return 0
+	}
+	if n > max {
+		return max
+	}
+	return n
+}
+
+func printResult(res bwc.Value) {
+	fmt.Printf("dec: %s\n", res)
+	fmt.Printf("bin: %s\n", res.Text(2))
+	fmt.Printf("hex: %s\n", res.Text(16))
+	fmt.Printf("type: %s\n", res.Type)
+}
+
+func options() []bwc.Option {
+	dialect, err := bwc.ParseDialect(lang)
+	abortonerr(err)
+
+	opts := []bwc.Option{bwc.Lang(dialect)}
+	if leftToRight {
+		opts = append(opts, bwc.LeftToRight())
+	}
+	return opts
+}
+
+func printResults(res []bwc.Value) {
+	for i, val := range res {
+		if i > 0 {
+			fmt.Printf("\n")
+		}
+		printResult(val)
+	}
+}
+
+// interpreter is the interface of bwc.NewInterp used by the REPL.
+type interpreter interface {
+	Exec(code string) ([]bwc.Value, error)
+	Explain(code string) (string, error)
+	Simplify(code string) (bwc.Node, error)
+	EvalSymbolic(code string) (bwc.SymValue, error)
+	Funcs() []bwc.FuncDecl
+	Undef(name string) error
+}
+
+const replHelp = `:funcs           list the defined functions
+:rm <name>...    remove the functions
+:explain <code>  run the code showing how it was parsed
+:simplify <code> show the code simplified
+:bits <code>     show the bits of the variables making the result
+:help            show this help`
+
+// replCmd runs the REPL commands, they start with a colon.
+func replCmd(interp interpreter, line string) error {
+	args := strings.Fields(line)
+	switch args[0] {
+	case ":funcs":
+		for _, fn := range interp.Funcs() {
+			fmt.Println(bwc.Format(fn, options()...))
+		}
+	case ":rm":
+		for _, name := range args[1:] {
+			if err := interp.Undef(name); err != nil {
+				return err
+			}
+		}
+	case ":explain":
+		code := strings.TrimSpace(strings.TrimPrefix(line, ":explain"))
+		if code == "" {
+			return errors.New(":explain expects the code")
+		}
+		explanation, err := interp.Explain(code)
+		fmt.Print(explanation)
+		return err
+	case ":simplify":
+		code := strings.TrimSpace(strings.TrimPrefix(line, ":simplify"))
+		if code == "" {
+			return errors.New(":simplify expects the code")
+		}
+		n, err := interp.Simplify(code)
+		if err != nil {
+			return err
+		}
+		fmt.Println(bwc.Format(n, options()...))
+	case ":bits":
+		code := strings.TrimSpace(strings.TrimPrefix(line, ":bits"))
+		if code == "" {
+			return errors.New(":bits expects the code")
+		}
+		val, err := interp.EvalSymbolic(code)
+		if err != nil {
+			return err
+		}
+		fmt.Println(val)
+	case ":help":
+		fmt.Println(replHelp)
+	default:
+		return fmt.Errorf("unknown command %s, try :help", args[0])
+	}
+	return nil
+}
+
+// incomplete tells if the code has unclosed blocks, like a
+// function body spanning many lines, or ends with the \ of
+// a C macro continuing in the next line.
+func incomplete(code string) bool {
+	return strings.Count(code, "{") > strings.Count(code, "}") ||
+		strings.HasSuffix(code, "\\")
+}
+
+// fmtCmd formats the script files, or the standard input, to
+// the standard output. With -w the files are overwritten, but
+// not the ones with comments or uses of macros, that would be
+// lost.
+func fmtCmd(args []string) error {
+	flags := flag.NewFlagSet("fmt", flag.ExitOnError)
+	write := flags.Bool("w", false, "Writes the result to the files")
+	flags.Parse(args)
+
+	if flags.NArg() == 0 {
+		code, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return err
+		}
+		out, err := formatCode(string(code))
+		if err != nil {
+			printError(os.Stderr, string(code), err)
+			return errors.New("invalid code")
+		}
+		fmt.Print(out)
+		return nil
 	}
 
-	res, err := interp.Eval(tree)
+	var failed, kept bool
+	for _, path := range flags.Args() {
+		code, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+
+		out, err := formatCode(string(code))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s:\n", path)
+			printError(os.Stderr, string(code), err)
+			failed = true
+			continue
+		}
+
+		if !*write {
+			fmt.Print(out)
+			continue
+		}
+		if !bwc.Lossless(string(code), options()...) {
+			fmt.Fprintf(os.Stderr, "%s: not written, the comments or the uses of macros would be lost\n",
+				path)
+			kept = true
+			continue
+		}
+		if err := os.WriteFile(path, []byte(out), 0644); err != nil {
+			return err
+		}
+	}
+
+	if failed {
+		return errors.New("invalid code")
+	}
+	if kept {
+		return errors.New("files not written")
+	}
+	return nil
+}
+
+// formatCode returns the canonical form of the code.
+func formatCode(code string) (string, error) {
+	n, err := bwc.Parse(code, options()...)
 	if err != nil {
-		return 0, err
+		return "", err
 	}
+	return bwc.Format(n, options()...) + "\n", nil
+}
 
-	return int(res), nil
+// run executes the code, printing the results or, with
+// -explain-parse, how the code was parsed.
+func run(interp interpreter, code string) error {
+	if explainParse {
+		explanation, err := interp.Explain(code)
+		fmt.Print(explanation)
+		return err
+	}
+
+	res, err := interp.Exec(code)
+	printResults(res)
+	return err
 }
 
 func cli() {
-	interp := bwc.NewInterp()
+	interp := bwc.NewInterp(options()...)
 
+	input := bufio.NewScanner(os.Stdin)
 	for {
 		fmt.Printf("bwc> ")
-		var line [256]byte
-		n, err := os.Stdin.Read(line[:])
-		if err == io.EOF {
+		if !input.Scan() {
+			abortonerr(input.Err())
 			break
 		}
-		abortonerr(err)
 
-		buf := strings.TrimSpace(string(line[:n-1]))
+		buf := strings.TrimSpace(input.Text())
 		if len(buf) == 0 {
 			fmt.Printf("\n")
 			continue
 		}
 
-		tree, err := bwc.Parse(buf)
-		if err != nil {
-			fmt.Printf("error: %s\n", err)
+		if strings.HasPrefix(buf, ":") {
+			if err := replCmd(interp, buf); err != nil {
+				fmt.Printf("error: %s\n", err)
+			}
 			continue
 		}
 
-		res, err := interp.Eval(tree)
-		if err != nil {
-			fmt.Printf("error: %s\n", err)
+		for incomplete(buf) {
+			fmt.Printf("...> ")
+			if !input.Scan() {
+				break
+			}
+			buf += "\n" + input.Text()
 		}
 
-		printResult(int(res))
+		if err := run(interp, buf); err != nil {
+			printError(os.Stdout, buf, err)
+		}
 	}
 }
 
 func main() {
 	flag.StringVar(&cmd, "c", "", "Evaluates a command")
+	flag.StringVar(&lang, "lang", "c",
+		"Language of the code: c, go, rust, java, js or python")
+	flag.BoolVar(&leftToRight, "ltr", false,
+		"Evaluates binary operators from left to right, ignoring precedence")
+	flag.BoolVar(&explainParse, "explain-parse", false,
+		"Shows the code with all the operations in parenthesis and their values")
 	flag.Parse()
 
+	if flag.Arg(0) == "fmt" {
+		abortonerr(fmtCmd(flag.Args()[1:]))
+		return
+	}
+
 	if cmd != "" {
-		res, err := execCmd()
-		abortonerr(err)
-		printResult(res)
+		if err := run(bwc.NewInterp(options()...), cmd); err != nil {
+			printError(os.Stderr, cmd, err)
+			os.Exit(1)
+		}
 		return
 	}
 